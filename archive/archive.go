@@ -3,13 +3,15 @@ package archive
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"time"
 
+	"github.com/m-lab/disco/logging"
 	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slog"
 )
 
 // Sample represents the basic structure for metric samples.
@@ -19,34 +21,110 @@ type Sample struct {
 	CollectEnd   int64  `json:"collectend"`
 	Value        uint64 `json:"value"`
 	Counter      uint64 `json:"counter"`
+	// Gauge is the raw value of gauge metrics, which have no Value or
+	// Counter.
+	Gauge *int64 `json:"gauge,omitempty"`
+	// Scaled is the Value of counters, or the Gauge, converted to the Unit
+	// of the Model. It is only set for metrics with a scale or offset.
+	Scaled *float64 `json:"scaled,omitempty"`
 }
 
 // Model represents the structure of metric for DISCO.
 type Model struct {
-	Experiment string   `json:"experiment"`
-	Hostname   string   `json:"hostname"`
-	Metric     string   `json:"metric"`
-	Samples    []Sample `json:"sample"`
+	Experiment string `json:"experiment"`
+	Hostname   string `json:"hostname"`
+	Metric     string `json:"metric"`
+	// OID is the symbolic name of the OID the samples were collected from
+	// e.g., IF-MIB::ifHCInOctets.568. It is empty for aggregates.
+	OID string `json:"oid,omitempty"`
+	// Unit is the unit of the Scaled sample values, if any.
+	Unit string `json:"unit,omitempty"`
+	// Device describes the switch the samples were collected from, if it
+	// was detected.
+	Device  *Device  `json:"device,omitempty"`
+	Samples []Sample `json:"sample"`
+}
+
+// Format is an output format for archives. Its value is used as the file
+// extension of archives written in that format.
+type Format string
+
+const (
+	// JSONL archives contain one JSON-encoded Model per line.
+	JSONL Format = "jsonl"
+	// Parquet archives contain one Row per Sample.
+	Parquet Format = "parquet"
+)
+
+// ParseFormat returns the Format named by s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case JSONL, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown archive format '%v'", s)
+}
+
+// Marshal encodes models in the given Format.
+func Marshal(f Format, models []Model) ([]byte, error) {
+	switch f {
+	case JSONL:
+		return MarshalJSONL(models), nil
+	case Parquet:
+		return MarshalParquet(models)
+	}
+	return nil, fmt.Errorf("unknown archive format '%v'", f)
+}
+
+// MarshalJSONL returns models encoded as JSONL.
+func MarshalJSONL(models []Model) []byte {
+	var jsonData []byte
+	for _, m := range models {
+		jsonData = append(jsonData, MustMarshalJSON(m)...)
+		// Adds a newline to the end of the JSON data to effectively create JSONL.
+		jsonData = append(jsonData, '\n')
+	}
+	return jsonData
+}
+
+// ReadJSONL decodes all Models from JSONL data, such as that written by
+// MarshalJSONL.
+func ReadJSONL(r io.Reader) ([]Model, error) {
+	models := []Model{}
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		m := Model{}
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		models = append(models, m)
+	}
+	return models, nil
 }
 
 // MustMarshalJSON accepts a Model object and returns marshalled JSON.
 func MustMarshalJSON(m Model) []byte {
 	data, err := json.Marshal(m)
-	rtx.Must(err, "ERROR: failed to marshal archive.Model to JSON. This should never happen")
+	rtx.Must(err, "Failed to marshal archive.Model to JSON. This should never happen")
 	return data
 }
 
+// GetName returns the name of an archive in the given Format relative to the
+// root of a Sink, using the DefaultNameTemplate with UTC times.
+func GetName(start time.Time, end time.Time, hostname string, format Format) string {
+	name, err := defaultNamer.Name(NameData{
+		Hostname: hostname,
+		Start:    start,
+		End:      end,
+		Codec:    format,
+	})
+	rtx.Must(err, "Failed to execute the default archive name template. This should never happen")
+	return name
+}
+
 // GetPath returns a filesystem path where an archive should be written.
 func GetPath(start time.Time, end time.Time, dataDir string, hostname string) string {
-	// The directory path where the archive should be written.
-	dirs := fmt.Sprintf("%v/%v", end.Format("2006/01/02"), hostname)
-
-	startTimeStr := start.Format("2006-01-02T15:04:05")
-	endTimeStr := end.Format("2006-01-02T15:04:05")
-	archiveName := fmt.Sprintf("%v-to-%v-switch.jsonl", startTimeStr, endTimeStr)
-	archivePath := fmt.Sprintf("%v/switch/%v/%v", dataDir, dirs, archiveName)
-
-	return archivePath
+	return fmt.Sprintf("%v/%v", dataDir, GetName(start, end, hostname, JSONL))
 }
 
 // Write writes out JSON data to a file on disk.
@@ -54,12 +132,15 @@ func Write(archivePath string, data []byte) error {
 	dirPath := path.Dir(archivePath)
 	err := os.MkdirAll(dirPath, 0755)
 	if err != nil {
-		log.Printf("ERROR: failed to create archive directory path '%v': %v", dirPath, err)
+		slog.Error("failed to create archive directory", "path", dirPath, logging.Err(err))
 		return err
 	}
 
 	err = ioutil.WriteFile(archivePath, data, 0644)
-	rtx.Must(err, "ERROR: failed to write archive file. This should never happen.")
+	if err != nil {
+		slog.Error("failed to write archive file", "path", archivePath, logging.Err(err))
+		return err
+	}
 
 	return nil
 }
@@ -107,6 +107,16 @@ func Test_WriteBadPath(t *testing.T) {
 
 }
 
+func Test_WriteUnwritableFile(t *testing.T) {
+	// The archive path is an existing directory, so the directories are
+	// created but the file can't be written.
+	dir := t.TempDir()
+	err := Write(dir, []byte("data"))
+	if err == nil {
+		t.Errorf("Expected an error but did not get one")
+	}
+}
+
 func Test_Write(t *testing.T) {
 	// Creates a tempdir for testing.
 	dir, err := ioutil.TempDir("", "TestWriteUnwritableFile")
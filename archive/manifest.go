@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// ManifestSuffix is appended to the name of an archive to get the name of its
+// sidecar manifest.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes the contents of an archive, so that its integrity can be
+// verified after it has been copied elsewhere.
+type Manifest struct {
+	// Name is the name of the archive, relative to the root of the Sink.
+	Name   string `json:"name"`
+	Format Format `json:"format"`
+	// SHA256 is the hex-encoded SHA-256 digest of the archive.
+	SHA256 string `json:"sha256"`
+	Bytes  int    `json:"bytes"`
+	// Records is the number of Models in the archive, which for JSONL
+	// archives is the number of lines.
+	Records int `json:"records"`
+	// Samples is the total number of Samples of all Models.
+	Samples int       `json:"samples"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Version is the version of DISCO that wrote the archive.
+	Version string `json:"version"`
+	// ConfigHash is the hash of the metrics configuration in effect when
+	// the archive was written.
+	ConfigHash string `json:"configHash"`
+	// Device describes the switch the samples were collected from, if it
+	// was detected.
+	Device *Device `json:"device,omitempty"`
+}
+
+// Device describes a switch, as detected from its sysObjectID and sysDescr.
+type Device struct {
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	SysObjectID string `json:"sysObjectID"`
+	SysDescr    string `json:"sysDescr,omitempty"`
+	// Profile is the name of the metrics config profile used for the switch.
+	Profile string `json:"profile"`
+}
+
+// NewManifest returns a Manifest for the archive name, which contains models
+// encoded as data.
+func NewManifest(name string, format Format, data []byte, models []Model, start, end time.Time, version, configHash string) Manifest {
+	sum := sha256.Sum256(data)
+	samples := 0
+	for _, m := range models {
+		samples += len(m.Samples)
+	}
+	return Manifest{
+		Name:       name,
+		Format:     format,
+		SHA256:     hex.EncodeToString(sum[:]),
+		Bytes:      len(data),
+		Records:    len(models),
+		Samples:    samples,
+		Start:      start.UTC(),
+		End:        end.UTC(),
+		Version:    version,
+		ConfigHash: configHash,
+	}
+}
+
+// Verify returns true if data matches the length and digest in the Manifest.
+func (m Manifest) Verify(data []byte) bool {
+	sum := sha256.Sum256(data)
+	return len(data) == m.Bytes && hex.EncodeToString(sum[:]) == m.SHA256
+}
+
+// MustMarshalJSON returns the Manifest as indented JSON.
+func (m Manifest) MustMarshalJSON() []byte {
+	data, err := json.MarshalIndent(m, "", "  ")
+	rtx.Must(err, "Failed to marshal archive.Manifest to JSON. This should never happen")
+	return append(data, '\n')
+}
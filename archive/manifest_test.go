@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func Test_NewManifest(t *testing.T) {
+	data := MarshalJSONL(testModels)
+	start := time.Unix(1591845348, 0)
+	end := time.Unix(1591845358, 0)
+	m := NewManifest("switch/test.jsonl", JSONL, data, testModels, start, end, "abc1234", "deadbeef")
+
+	if m.Bytes != len(data) {
+		t.Errorf("Expected %v bytes, but got: %v", len(data), m.Bytes)
+	}
+	if m.Records != 2 || m.Samples != 4 {
+		t.Errorf("Expected 2 records and 4 samples, but got: %v and %v", m.Records, m.Samples)
+	}
+	if m.Start.Location() != time.UTC || !m.Start.Equal(start) {
+		t.Errorf("Expected start time %v in UTC, but got: %v", start, m.Start)
+	}
+	if !m.Verify(data) {
+		t.Error("Expected the manifest to verify its own data")
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[10] ^= 0xff
+	if m.Verify(corrupt) {
+		t.Error("Expected the manifest to not verify corrupt data")
+	}
+	if m.Verify(data[:len(data)-1]) {
+		t.Error("Expected the manifest to not verify truncated data")
+	}
+
+	decoded := Manifest{}
+	rtx.Must(json.Unmarshal(m.MustMarshalJSON(), &decoded), "Failed to unmarshal manifest")
+	if decoded != m {
+		t.Errorf("Expected decoded manifest %v, but got: %v", m, decoded)
+	}
+}
@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// DefaultNameTemplate is the template for the traditional DISCO archive
+// layout e.g., switch/2020/06/11/<hostname>/<start>-to-<end>-switch.jsonl.
+const DefaultNameTemplate = `switch/{{.End.Format "2006/01/02"}}/{{.Hostname}}/` +
+	`{{.Start.Format "2006-01-02T15:04:05"}}-to-{{.End.Format "2006-01-02T15:04:05"}}-switch.{{.Codec}}`
+
+// NameData holds the values available to a name template.
+type NameData struct {
+	// Hostname is the FQDN of the node.
+	Hostname string
+	// Target is the FQDN of the switch.
+	Target string
+	// Start and End are the times of the first and last samples in the
+	// archive, converted to the Namer's location.
+	Start time.Time
+	End   time.Time
+	// Sequence is the number of archives previously written by this process.
+	Sequence int
+	// Codec is the archive Format, which is also the file extension.
+	Codec Format
+}
+
+// Namer generates archive names from a text/template, with all times
+// converted to a fixed location.
+type Namer struct {
+	tmpl     *template.Template
+	location *time.Location
+}
+
+// NewNamer returns a Namer for the template text. Times are converted to
+// location before the template is executed. A nil location means UTC.
+func NewNamer(text string, location *time.Location) (*Namer, error) {
+	tmpl, err := template.New("archive").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive name template: %v", err)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	n := &Namer{
+		tmpl:     tmpl,
+		location: location,
+	}
+
+	// Make sure the template can be executed, which catches references to
+	// fields that don't exist in NameData.
+	_, err = n.Name(NameData{
+		Hostname: "mlab1-abc0t.mlab-sandbox.measurement-lab.org",
+		Target:   "s1-abc0t.measurement-lab.org",
+		Start:    time.Unix(0, 0),
+		End:      time.Unix(0, 0),
+		Codec:    JSONL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// MustNewNamer is like NewNamer, but exits on error. It should only be used
+// with constant templates.
+func MustNewNamer(text string, location *time.Location) *Namer {
+	n, err := NewNamer(text, location)
+	rtx.Must(err, "Failed to create archive Namer")
+	return n
+}
+
+// Name returns the archive name for d.
+func (n *Namer) Name(d NameData) (string, error) {
+	d.Start = d.Start.In(n.location)
+	d.End = d.End.In(n.location)
+
+	buf := &bytes.Buffer{}
+	if err := n.tmpl.Execute(buf, d); err != nil {
+		return "", fmt.Errorf("failed to execute archive name template: %v", err)
+	}
+
+	name := buf.String()
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive name template must produce a relative path, got: '%v'", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive name must not contain '..', got: '%v'", name)
+		}
+	}
+	return name, nil
+}
+
+// defaultNamer is used by GetName.
+var defaultNamer = MustNewNamer(DefaultNameTemplate, time.UTC)
@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Namer(t *testing.T) {
+	eastern, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone database not available: %v", err)
+	}
+
+	start := time.Date(2020, 06, 11, 23, 55, 00, 0, time.UTC)
+	end := time.Date(2020, 06, 12, 00, 00, 00, 0, time.UTC)
+	// The same instant in a different time zone should make no difference.
+	localEnd := end.In(eastern)
+
+	tests := []struct {
+		name     string
+		template string
+		location *time.Location
+		expect   string
+	}{
+		{
+			name:     "default-template-utc",
+			template: DefaultNameTemplate,
+			expect:   "switch/2020/06/12/mlab1-abc0t/2020-06-11T23:55:00-to-2020-06-12T00:00:00-switch.parquet",
+		},
+		{
+			name:     "default-template-local",
+			template: DefaultNameTemplate,
+			location: eastern,
+			expect:   "switch/2020/06/11/mlab1-abc0t/2020-06-11T19:55:00-to-2020-06-11T20:00:00-switch.parquet",
+		},
+		{
+			name:     "custom-template",
+			template: `{{.End.Format "2006/01/02"}}/{{.Target}}/{{.End.Format "20060102T150405Z"}}-{{.Sequence}}.{{.Codec}}`,
+			expect:   "2020/06/12/s1-abc0t/20200612T000000Z-7.parquet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewNamer(tt.template, tt.location)
+			if err != nil {
+				t.Fatalf("NewNamer() returned an error: %v", err)
+			}
+			name, err := n.Name(NameData{
+				Hostname: "mlab1-abc0t",
+				Target:   "s1-abc0t",
+				Start:    start,
+				End:      localEnd,
+				Sequence: 7,
+				Codec:    Parquet,
+			})
+			if err != nil {
+				t.Fatalf("Name() returned an error: %v", err)
+			}
+			if name != tt.expect {
+				t.Errorf("Expected name %v, but got: %v", tt.expect, name)
+			}
+		})
+	}
+}
+
+func Test_NewNamerErrors(t *testing.T) {
+	templates := []string{
+		"{{.Hostname",
+		"{{.Nonexistent}}.jsonl",
+		"/absolute/{{.Hostname}}.jsonl",
+		"../{{.Hostname}}.jsonl",
+	}
+	for _, tmpl := range templates {
+		if _, err := NewNamer(tmpl, nil); err == nil {
+			t.Errorf("Expected an error for template %q, but did not get one", tmpl)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Service   = "s3"
+	// The date and time formats required by AWS Signature Version 4.
+	sigV4DateFormat     = "20060102"
+	sigV4DateTimeFormat = "20060102T150405Z"
+	// objectStoreTimeout limits the time of each request, so that a stalled
+	// object store doesn't block writes forever.
+	objectStoreTimeout = time.Minute
+)
+
+// ObjectStoreSink writes archives to a bucket of an S3-compatible object
+// store using path-style PUT requests. Requests are signed with AWS Signature
+// Version 4, which is supported by AWS S3, MinIO and the interoperability API
+// of Google Cloud Storage (using HMAC keys). If AccessKey is empty, requests
+// are sent unsigned.
+type ObjectStoreSink struct {
+	// Endpoint is the base URL of the object store e.g.,
+	// https://storage.googleapis.com or http://localhost:9000.
+	Endpoint string
+	// Bucket is the name of the bucket to write archives to.
+	Bucket string
+	// Prefix is an optional path prepended to every archive name.
+	Prefix string
+	// Region is the region used in request signatures. GCS accepts "auto".
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+
+	// now is used to get the time of signing, and is replaced in tests.
+	now func() time.Time
+}
+
+// NewObjectStoreSink returns a new ObjectStoreSink using an http.Client whose
+// requests time out after a minute.
+func NewObjectStoreSink(endpoint, bucket, prefix, region, accessKey, secretKey string) *ObjectStoreSink {
+	return &ObjectStoreSink{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Prefix:    strings.Trim(prefix, "/"),
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: objectStoreTimeout},
+		now:       time.Now,
+	}
+}
+
+// Write uploads data to the object name, relative to the sink's Prefix.
+func (o *ObjectStoreSink) Write(name string, data []byte) error {
+	key := name
+	if o.Prefix != "" {
+		key = o.Prefix + "/" + name
+	}
+	objectPath := "/" + uriEncodePath(o.Bucket+"/"+key)
+
+	u, err := url.Parse(o.Endpoint + objectPath)
+	if err != nil {
+		return fmt.Errorf("invalid object store URL for archive '%v': %v", name, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if o.AccessKey != "" {
+		o.sign(req, u, objectPath, data)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload archive '%v': %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload archive '%v': %v: %s", name, resp.Status, body)
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (o *ObjectStoreSink) sign(req *http.Request, u *url.URL, objectPath string, data []byte) {
+	now := o.now().UTC()
+	amzDate := now.Format(sigV4DateTimeFormat)
+	date := now.Format(sigV4DateFormat)
+	payloadHash := hashHex(data)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		objectPath,
+		"", // No query string.
+		"host:" + u.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%v/%v/%v/aws4_request", date, o.Region, sigV4Service)
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := signingKey(o.SecretKey, date, o.Region, sigV4Service)
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%v Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		sigV4Algorithm, o.AccessKey, scope, signedHeaders, signature))
+}
+
+// signingKey derives the Signature Version 4 signing key for a secret key.
+func signingKey(secretKey, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secretKey), []byte(date))
+	k = hmacSHA256(k, []byte(region))
+	k = hmacSHA256(k, []byte(service))
+	return hmacSHA256(k, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncodePath percent-encodes every byte of p except the RFC 3986
+// unreserved characters and '/', as required by Signature Version 4.
+func uriEncodePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
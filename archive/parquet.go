@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Row is a flattened Model and Sample, one per sample, with typed columns.
+// All time columns are stored as UTC timestamps with nanosecond precision.
+type Row struct {
+	Experiment   string `parquet:"name=experiment, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Hostname     string `parquet:"name=hostname, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Metric       string `parquet:"name=metric, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	OID          string `parquet:"name=oid, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Timestamp    int64  `parquet:"name=timestamp, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"`
+	CollectStart int64  `parquet:"name=collectstart, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"`
+	CollectEnd   int64  `parquet:"name=collectend, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"`
+	// Value and Counter hold the bits of the uint64 Sample values, and are
+	// annotated as unsigned so that readers interpret them correctly.
+	Value   int64 `parquet:"name=value, type=INT64, convertedtype=UINT_64"`
+	Counter int64 `parquet:"name=counter, type=INT64, convertedtype=UINT_64"`
+	// Gauge, Scaled and Unit are only set for gauges and scaled metrics.
+	Gauge  *int64   `parquet:"name=gauge, type=INT64, repetitiontype=OPTIONAL"`
+	Scaled *float64 `parquet:"name=scaled, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Unit   string   `parquet:"name=unit, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	// Vendor, Model, SysObjectID and Profile describe the switch, if it was
+	// detected.
+	Vendor      string `parquet:"name=vendor, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Model       string `parquet:"name=model, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SysObjectID string `parquet:"name=sysobjectid, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Profile     string `parquet:"name=profile, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// Rows flattens a Model into one Row per Sample.
+func Rows(m Model) []Row {
+	var device Device
+	if m.Device != nil {
+		device = *m.Device
+	}
+	rows := make([]Row, 0, len(m.Samples))
+	for _, s := range m.Samples {
+		rows = append(rows, Row{
+			Experiment: m.Experiment,
+			Hostname:   m.Hostname,
+			Metric:     m.Metric,
+			OID:        m.OID,
+			// Sample timestamps are in seconds, but are stored with the same
+			// unit as the collection times.
+			Timestamp:    s.Timestamp * 1e9,
+			CollectStart: s.CollectStart,
+			CollectEnd:   s.CollectEnd,
+			Value:        int64(s.Value),
+			Counter:      int64(s.Counter),
+			Gauge:        s.Gauge,
+			Scaled:       s.Scaled,
+			Unit:         m.Unit,
+			Vendor:       device.Vendor,
+			Model:        device.Model,
+			SysObjectID:  device.SysObjectID,
+			Profile:      device.Profile,
+		})
+	}
+	return rows
+}
+
+// MarshalParquet returns the Rows of all models encoded as a
+// Snappy-compressed Parquet file.
+func MarshalParquet(models []Model) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	pw, err := writer.NewParquetWriterFromWriter(buf, new(Row), 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Parquet writer: %v", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, m := range models {
+		for _, row := range Rows(m) {
+			if err := pw.Write(row); err != nil {
+				return nil, fmt.Errorf("failed to write Parquet row: %v", err)
+			}
+		}
+	}
+
+	if err := pw.WriteStop(); err != nil {
+		return nil, fmt.Errorf("failed to finalize Parquet file: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// bufferFile is a read-only source.ParquetFile of data in memory.
+type bufferFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBufferFile(data []byte) *bufferFile {
+	return &bufferFile{Reader: bytes.NewReader(data), data: data}
+}
+
+func (f *bufferFile) Open(name string) (source.ParquetFile, error) {
+	return newBufferFile(f.data), nil
+}
+
+func (f *bufferFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("bufferFile is read-only")
+}
+
+func (f *bufferFile) Write(p []byte) (int, error) {
+	return 0, errors.New("bufferFile is read-only")
+}
+
+func (f *bufferFile) Close() error { return nil }
+
+func Test_Rows(t *testing.T) {
+	rows := Rows(testModels[0])
+	if len(rows) != len(testModels[0].Samples) {
+		t.Fatalf("Expected %v rows, but got: %v", len(testModels[0].Samples), len(rows))
+	}
+	expect := Row{
+		Experiment:   "s1-abc0t.measurement-lab.org",
+		Hostname:     "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+		Metric:       "switch.unicast.uplink.tx",
+		Timestamp:    1591845348000000000,
+		CollectStart: 1592344911240000000,
+		CollectEnd:   1592344912360000000,
+		Value:        158,
+		Counter:      1246,
+	}
+	if !reflect.DeepEqual(rows[0], expect) {
+		t.Errorf("Expected Row:\n%v\nGot:\n%v", expect, rows[0])
+	}
+
+	// The detected switch is recorded in every row.
+	m := testModels[0]
+	m.Device = &Device{Vendor: "juniper", Model: "qfx5100-48s-6q", SysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82", Profile: "juniper"}
+	expect.Vendor, expect.Model, expect.SysObjectID, expect.Profile = "juniper", "qfx5100-48s-6q", ".1.3.6.1.4.1.2636.1.1.1.2.82", "juniper"
+	if rows := Rows(m); !reflect.DeepEqual(rows[0], expect) {
+		t.Errorf("Expected Row:\n%v\nGot:\n%v", expect, rows[0])
+	}
+}
+
+func Test_MarshalParquet(t *testing.T) {
+	data, err := MarshalParquet(testModels)
+	rtx.Must(err, "Failed to marshal Parquet")
+
+	pr, err := reader.NewParquetReader(newBufferFile(data), new(Row), 1)
+	rtx.Must(err, "Failed to create Parquet reader")
+	defer pr.ReadStop()
+
+	expect := append(Rows(testModels[0]), Rows(testModels[1])...)
+	if int(pr.GetNumRows()) != len(expect) {
+		t.Fatalf("Expected %v rows, but got: %v", len(expect), pr.GetNumRows())
+	}
+
+	got := make([]Row, pr.GetNumRows())
+	rtx.Must(pr.Read(&got), "Failed to read Parquet rows")
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("Expected rows:\n%v\nGot:\n%v", expect, got)
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	for _, s := range []string{"jsonl", "parquet"} {
+		f, err := ParseFormat(s)
+		if err != nil || string(f) != s {
+			t.Errorf("Expected format %v, but got: %v, %v", s, f, err)
+		}
+	}
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Error("Expected an error but did not get one")
+	}
+}
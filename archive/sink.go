@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink is a destination to which archives are written.
+type Sink interface {
+	// Write stores data under name, which is a slash-separated path relative
+	// to the root of the Sink, as returned by GetName().
+	Write(name string, data []byte) error
+}
+
+// FileSink writes archives to files under a local directory. This is the
+// default Sink, and is used when a pusher sidecar uploads the files.
+type FileSink struct {
+	DataDir string
+}
+
+// NewFileSink returns a new FileSink rooted at dataDir.
+func NewFileSink(dataDir string) *FileSink {
+	return &FileSink{
+		DataDir: dataDir,
+	}
+}
+
+// Write writes data to the file name relative to the FileSink's DataDir.
+func (f *FileSink) Write(name string, data []byte) error {
+	return Write(fmt.Sprintf("%v/%v", f.DataDir, name), data)
+}
+
+// StdoutSink writes the contents of archives to an io.Writer, which is
+// os.Stdout unless otherwise specified. Archive names are discarded.
+type StdoutSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewStdoutSink returns a new StdoutSink writing to os.Stdout.
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{
+		w: os.Stdout,
+	}
+}
+
+// Write writes data to the StdoutSink's io.Writer.
+func (s *StdoutSink) Write(name string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.w.Write(data)
+	return err
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func Test_FileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestFileSink")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	s := NewFileSink(dir)
+	err = s.Write("switch/2020/06/11/test.jsonl", []byte("data"))
+	rtx.Must(err, "Failed to write to FileSink")
+
+	contents, err := ioutil.ReadFile(dir + "/switch/2020/06/11/test.jsonl")
+	rtx.Must(err, "Could not read FileSink file")
+	if string(contents) != "data" {
+		t.Errorf("Expected file contents 'data', but got: %s", contents)
+	}
+}
+
+func Test_StdoutSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewStdoutSink()
+	s.w = buf
+
+	rtx.Must(s.Write("a.jsonl", []byte("line1\n")), "Failed to write to StdoutSink")
+	rtx.Must(s.Write("b.jsonl", []byte("line2\n")), "Failed to write to StdoutSink")
+
+	if buf.String() != "line1\nline2\n" {
+		t.Errorf("Unexpected StdoutSink output: %q", buf.String())
+	}
+}
+
+func Test_ObjectStoreSink(t *testing.T) {
+	var gotMethod, gotPath, gotAuth, gotDate, gotHash string
+	var gotBody []byte
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		gotDate = r.Header.Get("X-Amz-Date")
+		gotHash = r.Header.Get("X-Amz-Content-Sha256")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	s := NewObjectStoreSink(srv.URL+"/", "disco-bucket", "/ndt/", "us-east-1", "AKIDEXAMPLE", "secret")
+	s.now = func() time.Time {
+		return time.Date(2020, 06, 11, 18, 18, 30, 0, time.UTC)
+	}
+
+	err := s.Write("switch/2020/06/11/2020-06-11T18:13:30-switch.jsonl", []byte("data"))
+	rtx.Must(err, "Failed to write to ObjectStoreSink")
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("Expected method PUT, but got: %v", gotMethod)
+	}
+	expectPath := "/disco-bucket/ndt/switch/2020/06/11/2020-06-11T18%3A13%3A30-switch.jsonl"
+	if gotPath != expectPath {
+		t.Errorf("Expected path %v, but got: %v", expectPath, gotPath)
+	}
+	if string(gotBody) != "data" {
+		t.Errorf("Expected body 'data', but got: %s", gotBody)
+	}
+	if gotDate != "20200611T181830Z" {
+		t.Errorf("Unexpected X-Amz-Date header: %v", gotDate)
+	}
+	if gotHash != hashHex([]byte("data")) {
+		t.Errorf("Unexpected X-Amz-Content-Sha256 header: %v", gotHash)
+	}
+	expectAuth := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20200611/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(gotAuth, expectAuth) {
+		t.Errorf("Expected Authorization header to start with %v, but got: %v", expectAuth, gotAuth)
+	}
+}
+
+func Test_ObjectStoreSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	s := NewObjectStoreSink(srv.URL, "disco-bucket", "", "auto", "", "")
+	err := s.Write("test.jsonl", []byte("data"))
+	if err == nil {
+		t.Error("Expected an error but did not get one")
+	}
+}
+
+func Test_signingKey(t *testing.T) {
+	// Example values from the AWS Signature Version 4 documentation.
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830", "us-east-1", "iam")
+	expect := "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"
+	if hex.EncodeToString(key) != expect {
+		t.Errorf("Expected signing key %v, but got: %x", expect, key)
+	}
+}
@@ -1,41 +1,383 @@
 package config
 
 import (
-	"io/ioutil"
-	"log"
+	"bytes"
+	"crypto/sha256"
+	_ "embed" // For the default config.
+	"encoding/hex"
+	"errors"
+	"fmt"
 
-	"gopkg.in/yaml.v2"
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/mib"
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
 )
 
-// Config represents a collection of Metrics.
+// defaultYAML is the built-in metrics config, the IF-MIB counters of
+// metrics.yaml.sample.
+//
+//go:embed default.yaml
+var defaultYAML []byte
+
+// builtinFile is the file name reported for problems with the built-in config.
+const builtinFile = "<builtin>"
+
+// Config represents a collection of Metrics, and the switch interfaces they
+// are collected from.
+//
+// A YAML config file is either a mapping with "interfaces" and "metrics" keys,
+// or, in the legacy format, just the list of metrics, in which case the
+// DefaultInterfaces are used. The metrics of a mapping are merged with the
+// built-in Default() metrics unless ReplaceDefaults is set, while a legacy
+// list always replaces them.
 type Config struct {
-	Metrics []Metric
+	Interfaces []Interface `yaml:"interfaces,omitempty"`
+	Metrics    []Metric    `yaml:"metrics"`
+	// ReplaceDefaults uses only the metrics in the file, instead of merging
+	// them with the built-in metrics.
+	ReplaceDefaults bool `yaml:"replaceDefaults,omitempty"`
+	// MIBs are paths of MIB files, relative to the config file, which define
+	// symbolic names for Metric.Oid in addition to the bundled MIBs.
+	MIBs []string `yaml:"mibs,omitempty"`
+	// Include lists config files, or glob patterns, relative to the config
+	// file. They are merged in order, followed by the including file; see
+	// Load().
+	Include []string `yaml:"include,omitempty"`
+	// Overrides change the interfaces and metrics for matching switches or
+	// nodes. They are applied by ForTarget().
+	Overrides []Override `yaml:"overrides,omitempty"`
+	// Profiles change the interfaces and metrics for switch models or
+	// vendors. The first matching profile is applied by ForDevice().
+	Profiles []Profile `yaml:"profiles,omitempty"`
+
+	// file is the path of the YAML file the Config was read from.
+	file string
+	// positions holds the location in file of each element of Metrics.
+	positions []position
+	// interfacePositions holds the location in file of each element of
+	// Interfaces.
+	interfacePositions []position
+	// legacy is true if file is in the legacy format.
+	legacy bool
+	// resolver resolves symbolic OIDs using the bundled MIBs and MIBs.
+	resolver *mib.Resolver
+	// secrets are the values interpolated into the files; see Redacted().
+	secrets []string
 }
 
 // Metric represents all the information needed for an SNMP metric.
 type Metric struct {
-	Name            string `yaml:"name"`
-	Description     string `yaml:"description"`
-	OidStub         string `yaml:"oidStub"`
-	MlabUplinkName  string `yaml:"mlabUplinkName"`
-	MlabMachineName string `yaml:"mlabMachineName"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	OidStub     string `yaml:"oidStub,omitempty"`
+	// Oid is the symbolic name of the OID, such as IF-MIB::ifHCInOctets,
+	// which may be given instead of OidStub. Load() sets OidStub to the
+	// resolved OID.
+	Oid string `yaml:"oid,omitempty"`
+	// ArchiveNames maps the name of each Interface the metric is collected
+	// from to the metric name used in archives. The metric is not collected
+	// from interfaces without an archive name.
+	ArchiveNames map[string]string `yaml:"archiveNames,omitempty"`
+	// Type is "counter" (the default) for monotonically increasing counters,
+	// whose increase over each interval is archived and exported, or "gauge"
+	// for values such as temperatures, which are archived and exported as-is.
+	Type string `yaml:"type,omitempty"`
+	// Scale and Offset convert raw values to Unit as raw*Scale+Offset e.g.,
+	// scale: 8 converts octets to bits. Scale defaults to 1. Offset may only
+	// be used with gauges, since it cancels out of counter increases.
+	Scale  float64 `yaml:"scale,omitempty"`
+	Offset float64 `yaml:"offset,omitempty"`
+	// Unit is the unit of the converted values e.g., bits, dBm or celsius.
+	Unit string `yaml:"unit,omitempty"`
+	// Deprecated: MlabUplinkName is equivalent to ArchiveNames["uplink"].
+	MlabUplinkName string `yaml:"mlabUplinkName,omitempty"`
+	// Deprecated: MlabMachineName is equivalent to ArchiveNames["machine"].
+	MlabMachineName string `yaml:"mlabMachineName,omitempty"`
+}
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// IsGauge returns true if the Metric is a gauge rather than a counter.
+func (m Metric) IsGauge() bool {
+	return m.Type == Gauge
+}
+
+// Scaled returns true if the Metric converts raw values using Scale or
+// Offset.
+func (m Metric) Scaled() bool {
+	return m.Scale != 0 || m.Offset != 0
+}
+
+// Convert returns the raw value converted using Scale and Offset.
+func (m Metric) Convert(raw float64) float64 {
+	scale := m.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return raw*scale + m.Offset
+}
+
+// ArchiveName returns the archive metric name of the Metric for the Interface
+// named iface, or an empty string if the metric isn't collected from it.
+func (m Metric) ArchiveName(iface string) string {
+	if name, ok := m.ArchiveNames[iface]; ok {
+		return name
+	}
+	switch iface {
+	case "machine":
+		return m.MlabMachineName
+	case "uplink":
+		return m.MlabUplinkName
+	}
+	return ""
+}
+
+// Resolver returns the mib.Resolver for the symbolic OIDs of the Config.
+func (c Config) Resolver() *mib.Resolver {
+	if c.resolver == nil {
+		return mib.NewResolver()
+	}
+	return c.resolver
 }
 
-// New returns a new Config struct.
+// resolve sets the OidStub of each Metric, including those of Overrides and
+// Profiles, with
+// a symbolic Oid, using the bundled MIBs and the MIBs of the Config. Metrics
+// whose Oid cannot be resolved are left unchanged, and reported by Validate().
+func (c *Config) resolve() error {
+	c.resolver = mib.NewResolver()
+	// The MIB paths were already made relative to their files by load().
+	if err := c.resolver.LoadFiles(c.MIBs...); err != nil {
+		return err
+	}
+	c.resolveMetrics(c.Metrics)
+	for i := range c.Overrides {
+		c.resolveMetrics(c.Overrides[i].Metrics)
+	}
+	for i := range c.Profiles {
+		c.resolveMetrics(c.Profiles[i].Metrics)
+	}
+	return nil
+}
+
+// resolveMetrics sets the OidStub of each of metrics with a symbolic Oid.
+func (c *Config) resolveMetrics(metrics []Metric) {
+	for i, m := range metrics {
+		if m.Oid == "" || m.OidStub != "" {
+			continue
+		}
+		if oid, err := c.resolver.Resolve(m.Oid); err == nil {
+			metrics[i].OidStub = oid
+		}
+	}
+}
+
+// InterfaceSelectors returns the configured Interfaces, or the
+// DefaultInterfaces if there are none.
+func (c Config) InterfaceSelectors() []Interface {
+	if len(c.Interfaces) == 0 {
+		return DefaultInterfaces
+	}
+	return c.Interfaces
+}
+
+// position is the location of a YAML mapping in a file.
+type position struct {
+	// file is the file containing the mapping, which may be included by the
+	// file of the Config.
+	file string
+	line int
+	// fields maps YAML keys to the lines on which they appear.
+	fields map[string]int
+	// builtin is true for metrics merged from the built-in config.
+	builtin bool
+}
+
+// Default returns the built-in Config, which is used when no metrics file is
+// given.
+func Default() Config {
+	c, err := parse(builtinFile, defaultYAML)
+	rtx.Must(err, "Failed to parse the built-in metrics config. This should never happen")
+	rtx.Must(c.resolve(), "Failed to resolve the built-in metrics config. This should never happen")
+	for i := range c.positions {
+		c.positions[i].builtin = true
+	}
+	return c
+}
+
+// merge returns the metrics of c followed by those metrics of defaults which
+// c does not override with a metric of the same name.
+func (c Config) merge(defaults Config) Config {
+	names := map[string]bool{}
+	for _, m := range c.Metrics {
+		names[m.Name] = true
+	}
+	merged := c
+	merged.Metrics = append([]Metric{}, c.Metrics...)
+	merged.positions = make([]position, len(c.Metrics))
+	copy(merged.positions, c.positions)
+	for i, m := range defaults.Metrics {
+		if names[m.Name] {
+			continue
+		}
+		merged.Metrics = append(merged.Metrics, m)
+		merged.positions = append(merged.positions, defaults.positions[i])
+	}
+	return merged
+}
+
+// New returns a new Config struct read from yamlFile. The Config is validated,
+// and a *ValidationError listing all problems is returned if it is invalid.
+// Each problem, and each of the Warnings(), is also logged.
 func New(yamlFile string) (Config, error) {
-	var c Config
+	c, err := Load(yamlFile)
+	if err != nil {
+		slog.Error("failed to load YAML metrics config", "file", yamlFile, logging.Err(err))
+		return c, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			for _, p := range verr.Problems {
+				slog.Error("invalid YAML metrics config", p.attrs()...)
+			}
+		}
+		return c, err
+	}
+	for _, p := range c.Warnings() {
+		slog.Warn("suspicious YAML metrics config", p.attrs()...)
+	}
+
+	return c, nil
+}
+
+// Load reads and strictly decodes yamlFile without validating it. References
+// to environment variables and files are interpolated first; see Interpolate().
+//
+// The files listed by Include are loaded first, in order, and the including
+// file last. Each file's interfaces and metrics replace those of earlier
+// files with the same name, or are appended, while MIBs, Overrides and
+// Profiles are concatenated. The metrics are then merged with the built-in metrics as
+// described for Config. If yamlFile is empty, the built-in Default() config
+// is returned.
+func Load(yamlFile string) (Config, error) {
+	if yamlFile == "" {
+		return Default(), nil
+	}
+	c, err := load(yamlFile, nil)
+	if err != nil {
+		return c, err
+	}
+	if err := c.resolve(); err != nil {
+		return c, fmt.Errorf("failed to load MIBs of YAML metrics config '%v': %v", yamlFile, err)
+	}
+	if c.legacy || c.ReplaceDefaults {
+		return c, nil
+	}
+	return c.merge(Default()), nil
+}
+
+// parse decodes yamlData strictly, recording the position of each Metric and
+// Interface.
+func parse(yamlFile string, yamlData []byte) (Config, error) {
+	c := Config{file: yamlFile}
 
-	yamlData, err := ioutil.ReadFile(yamlFile)
+	var doc yaml.Node
+	err := yaml.Unmarshal(yamlData, &doc)
 	if err != nil {
-		log.Printf("ERROR: failed to read YAML metrics config file '%v': %v", yamlFile, err)
 		return c, err
 	}
+	if len(doc.Content) == 0 {
+		// An empty document has no metrics, which Validate() reports.
+		return c, nil
+	}
+	root := doc.Content[0]
 
-	err = yaml.UnmarshalStrict(yamlData, &c.Metrics)
+	// Node.Decode() does not support rejecting unknown fields, so the data is
+	// decoded again from the start.
+	dec := yaml.NewDecoder(bytes.NewReader(yamlData))
+	dec.KnownFields(true)
+	switch root.Kind {
+	case yaml.SequenceNode:
+		err = dec.Decode(&c.Metrics)
+		c.positions = positions(yamlFile, root)
+		c.legacy = true
+	default:
+		err = dec.Decode(&c)
+		c.positions = positions(yamlFile, mappingValue(root, "metrics"))
+		c.interfacePositions = positions(yamlFile, mappingValue(root, "interfaces"))
+	}
 	if err != nil {
-		log.Printf("ERROR: failed to unmarshal YAML metrics config: %v", err)
 		return c, err
 	}
 
-	return c, err
+	overrides := positions(yamlFile, mappingValue(root, "overrides"))
+	for i := range c.Overrides {
+		if i >= len(overrides) {
+			break
+		}
+		node := mappingValue(root, "overrides").Content[i]
+		c.Overrides[i].pos = overrides[i]
+		c.Overrides[i].positions = positions(yamlFile, mappingValue(node, "metrics"))
+		c.Overrides[i].interfacePositions = positions(yamlFile, mappingValue(node, "interfaces"))
+	}
+	profiles := positions(yamlFile, mappingValue(root, "profiles"))
+	for i := range c.Profiles {
+		if i >= len(profiles) {
+			break
+		}
+		node := mappingValue(root, "profiles").Content[i]
+		c.Profiles[i].pos = profiles[i]
+		c.Profiles[i].positions = positions(yamlFile, mappingValue(node, "metrics"))
+		c.Profiles[i].interfacePositions = positions(yamlFile, mappingValue(node, "interfaces"))
+	}
+
+	return c, nil
+}
+
+// mappingValue returns the value of key in the mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// positions returns the position in file of each mapping in a sequence node.
+func positions(file string, seq *yaml.Node) []position {
+	if seq == nil {
+		return nil
+	}
+	var p []position
+	for _, item := range seq.Content {
+		fields := map[string]int{}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			fields[item.Content[i].Value] = item.Content[i].Line
+		}
+		p = append(p, position{file: file, line: item.Line, fields: fields})
+	}
+	return p
+}
+
+// Hash returns the hex-encoded SHA-256 digest of the configuration, which
+// identifies it in archive manifests. The interpolated values are redacted
+// first, so that the published hash can't be used to guess them.
+func (c Config) Hash() string {
+	data, err := yaml.Marshal(c.Redacted())
+	rtx.Must(err, "Failed to marshal config.Config to YAML. This should never happen")
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
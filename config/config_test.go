@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/m-lab/go/rtx"
@@ -70,3 +73,191 @@ func TestGoodYamlFile(t *testing.T) {
 		t.Errorf("Expected Metric '%v' but got: %v", goodYamlStruct, m)
 	}
 }
+
+func TestHash(t *testing.T) {
+	c1 := Config{Metrics: []Metric{goodYamlStruct}}
+	c2 := Config{Metrics: []Metric{goodYamlStruct}}
+	if c1.Hash() != c2.Hash() {
+		t.Error("Expected identical configs to have the same hash")
+	}
+
+	c2.Metrics[0].OidStub = ".1.3.6.1.2.1.31.1.1.1.7"
+	if c1.Hash() == c2.Hash() {
+		t.Error("Expected different configs to have different hashes")
+	}
+}
+
+var goodInterfacesYaml = `
+interfaces:
+  - name: machine
+    ifAlias: "{{.Machine}}"
+  - name: bmc
+    ifDescrRegex: "^ge-0/0/4[67]$"
+    optional: true
+metrics:
+  - name: ifHCOutUcastPkts
+    description: Test
+    oidStub: .1.3.6.1.2.1.31.1.1.1.11
+    archiveNames:
+      machine: switch.unicast.local.tx
+      bmc: switch.unicast.bmc.tx
+`
+
+func TestGoodInterfacesYamlFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGoodInterfacesYamlFile")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/metrics.yaml", []byte(goodInterfacesYaml), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(dir + "/metrics.yaml")
+	rtx.Must(err, "Could not load valid config")
+
+	expect := []Interface{
+		{Name: "machine", IfAlias: "{{.Machine}}"},
+		{Name: "bmc", IfDescrRegex: "^ge-0/0/4[67]$", Optional: true},
+	}
+	if !reflect.DeepEqual(c.InterfaceSelectors(), expect) {
+		t.Errorf("Expected interfaces %v, but got: %v", expect, c.InterfaceSelectors())
+	}
+	if name := c.Metrics[0].ArchiveName("bmc"); name != "switch.unicast.bmc.tx" {
+		t.Errorf("Expected archive name switch.unicast.bmc.tx, but got: %v", name)
+	}
+	if name := c.Metrics[0].ArchiveName("uplink"); name != "" {
+		t.Errorf("Expected no archive name for uplink, but got: %v", name)
+	}
+}
+
+func TestLegacyArchiveNames(t *testing.T) {
+	c := Config{Metrics: []Metric{goodYamlStruct}}
+	if !reflect.DeepEqual(c.InterfaceSelectors(), DefaultInterfaces) {
+		t.Errorf("Expected the default interfaces, but got: %v", c.InterfaceSelectors())
+	}
+	if name := c.Metrics[0].ArchiveName("uplink"); name != goodYamlStruct.MlabUplinkName {
+		t.Errorf("Expected archive name %v, but got: %v", goodYamlStruct.MlabUplinkName, name)
+	}
+	if name := c.Metrics[0].ArchiveName("machine"); name != goodYamlStruct.MlabMachineName {
+		t.Errorf("Expected archive name %v, but got: %v", goodYamlStruct.MlabMachineName, name)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	rtx.Must(c.Validate(), "Invalid built-in config")
+
+	sample, err := Load("../metrics.yaml.sample")
+	rtx.Must(err, "Could not load metrics.yaml.sample")
+	if !reflect.DeepEqual(c.Metrics, sample.Metrics) {
+		t.Errorf("Expected the built-in metrics to match metrics.yaml.sample, but got: %v", c.Metrics)
+	}
+
+	c, err = New("")
+	rtx.Must(err, "Could not load the built-in config")
+	if len(c.Metrics) != len(sample.Metrics) {
+		t.Errorf("Expected %d built-in metrics, but got: %d", len(sample.Metrics), len(c.Metrics))
+	}
+}
+
+var mergeYaml = `
+interfaces:
+  - name: machine
+    ifAlias: "{{.Machine}}"
+metrics:
+  - name: ifHCInOctets
+    description: Overridden.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx
+  - name: ifHCInMulticastPkts
+    description: Ingress multicast packets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.8
+    archiveNames:
+      machine: switch.multicast.local.rx
+`
+
+func TestLoadMerge(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/metrics.yaml"
+	rtx.Must(ioutil.WriteFile(file, []byte(mergeYaml), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(file)
+	rtx.Must(err, "Could not load merged config")
+	defaults := Default()
+	if len(c.Metrics) != len(defaults.Metrics)+1 {
+		t.Fatalf("Expected %d metrics, but got: %d", len(defaults.Metrics)+1, len(c.Metrics))
+	}
+	if c.Metrics[0].Description != "Overridden." || c.Metrics[1].Name != "ifHCInMulticastPkts" {
+		t.Errorf("Expected the file's metrics first, but got: %v", c.Metrics[:2])
+	}
+	for _, m := range c.Metrics[2:] {
+		if m.Name == "ifHCInOctets" {
+			t.Errorf("Expected ifHCInOctets to be overridden, but got the built-in metric too")
+		}
+	}
+
+	rtx.Must(ioutil.WriteFile(file, []byte("replaceDefaults: true\n"+mergeYaml), 0644), "Could not write YAML to tempfile")
+	c, err = New(file)
+	rtx.Must(err, "Could not load config replacing the defaults")
+	if len(c.Metrics) != 2 {
+		t.Errorf("Expected only the file's 2 metrics, but got: %d", len(c.Metrics))
+	}
+}
+
+var symbolicYaml = `
+replaceDefaults: true
+mibs:
+  - %v
+metrics:
+  - name: ifHCInMulticastPkts
+    description: Ingress multicast packets.
+    oid: IF-MIB::ifHCInMulticastPkts
+    mlabUplinkName: switch.multicast.uplink.rx
+    mlabMachineName: switch.multicast.local.rx
+  - name: exampleDomRxPower
+    description: Receive power.
+    oid: EXAMPLE-DOM-MIB::exampleDomRxPower
+    archiveNames:
+      uplink: switch.rxpower.uplink
+`
+
+func TestSymbolicOids(t *testing.T) {
+	mibFile, err := filepath.Abs("../mib/testdata/EXAMPLE-DOM-MIB.txt")
+	rtx.Must(err, "Could not find test MIB")
+	file := t.TempDir() + "/metrics.yaml"
+	rtx.Must(ioutil.WriteFile(file, []byte(fmt.Sprintf(symbolicYaml, mibFile)), 0644), "Could not write YAML to tempfile")
+
+	c, err := New(file)
+	rtx.Must(err, "Could not load config with symbolic OIDs")
+	expect := []string{".1.3.6.1.2.1.31.1.1.1.8", ".1.3.6.1.4.1.99999.3.60.1.1.5"}
+	for i, m := range c.Metrics {
+		if m.OidStub != expect[i] {
+			t.Errorf("Expected %v to resolve to %v, but got: %v", m.Oid, expect[i], m.OidStub)
+		}
+	}
+	if name := c.Resolver().Name(".1.3.6.1.4.1.99999.3.60.1.1.5.568"); name != "EXAMPLE-DOM-MIB::exampleDomRxPower.568" {
+		t.Errorf("Unexpected symbolic name: %v", name)
+	}
+
+	// A relative MIB path is relative to the config file, even if the config
+	// file is itself given by a relative path with a directory.
+	mibData, err := ioutil.ReadFile(mibFile)
+	rtx.Must(err, "Could not read test MIB")
+	dir := writeConfigs(t, map[string]string{
+		"conf/metrics.yaml":             fmt.Sprintf(symbolicYaml, "mibs/EXAMPLE-DOM-MIB.txt"),
+		"conf/mibs/EXAMPLE-DOM-MIB.txt": string(mibData),
+	})
+	wd, err := os.Getwd()
+	rtx.Must(err, "Could not get the working directory")
+	rtx.Must(os.Chdir(dir), "Could not change to the config directory")
+	defer os.Chdir(wd)
+	_, err = New(filepath.Join("conf", "metrics.yaml"))
+	rtx.Must(err, "Could not load config with a relative MIB path")
+	rtx.Must(os.Chdir(wd), "Could not change back to the working directory")
+
+	// Without the MIB file, the vendor OID cannot be resolved.
+	withoutMIBs := strings.Replace(symbolicYaml, "mibs:\n  - %v\n", "", 1)
+	rtx.Must(ioutil.WriteFile(file, []byte(withoutMIBs), 0644), "Could not write YAML to tempfile")
+	_, err = New(file)
+	if err == nil || !strings.Contains(err.Error(), "unknown MIB object 'EXAMPLE-DOM-MIB::exampleDomRxPower'") {
+		t.Errorf("Expected an unknown MIB object error, but got: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// load reads yamlFile and the files it includes, merging them as described
+// for Load(). stack holds the files including yamlFile, to detect cycles.
+func load(yamlFile string, stack []string) (Config, error) {
+	for _, f := range stack {
+		if f == yamlFile {
+			return Config{file: yamlFile}, fmt.Errorf("YAML metrics config '%v' includes itself", yamlFile)
+		}
+	}
+
+	yamlData, err := ioutil.ReadFile(yamlFile)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read YAML metrics config file '%v': %v", yamlFile, err)
+	}
+	yamlData, secrets, err := interpolate(yamlData)
+	if err != nil {
+		return Config{file: yamlFile}, fmt.Errorf("failed to interpolate YAML metrics config '%v': %v", yamlFile, err)
+	}
+	c, err := parse(yamlFile, yamlData)
+	if err != nil {
+		return c, fmt.Errorf("failed to unmarshal YAML metrics config '%v': %v", yamlFile, err)
+	}
+	c.secrets = secrets
+
+	// MIB paths are relative to the file that lists them.
+	for i, f := range c.MIBs {
+		c.MIBs[i] = relativeTo(yamlFile, f)
+	}
+	if len(c.Include) == 0 {
+		return c, nil
+	}
+
+	merged := Config{file: yamlFile}
+	for _, pattern := range c.Include {
+		files, err := filepath.Glob(relativeTo(yamlFile, pattern))
+		if err != nil {
+			return c, fmt.Errorf("invalid include '%v' in YAML metrics config '%v': %v", pattern, yamlFile, err)
+		}
+		if len(files) == 0 {
+			return c, fmt.Errorf("include '%v' in YAML metrics config '%v' matches no files", pattern, yamlFile)
+		}
+		// Glob returns files in lexical order, so merging is deterministic.
+		for _, f := range files {
+			included, err := load(f, append(stack, yamlFile))
+			if err != nil {
+				return c, err
+			}
+			merged = merged.overlay(included)
+		}
+	}
+	merged = merged.overlay(c)
+	merged.Include = nil
+	return merged, nil
+}
+
+// relativeTo returns path relative to the directory of file, unless it is
+// absolute.
+func relativeTo(file, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(file), path)
+}
+
+// overlay returns c with the interfaces and metrics of o replacing those with
+// the same name, or appended, and the MIBs, Overrides and Profiles of o
+// appended.
+func (c Config) overlay(o Config) Config {
+	c.Interfaces, c.interfacePositions = overlayInterfaces(
+		c.Interfaces, c.interfacePositions, o.Interfaces, o.interfacePositions)
+	c.Metrics, c.positions = overlayMetrics(c.Metrics, c.positions, o.Metrics, o.positions)
+	c.MIBs = append(append([]string{}, c.MIBs...), o.MIBs...)
+	c.Overrides = append(append([]Override{}, c.Overrides...), o.Overrides...)
+	c.Profiles = append(append([]Profile{}, c.Profiles...), o.Profiles...)
+	c.ReplaceDefaults = c.ReplaceDefaults || o.ReplaceDefaults
+	c.secrets = append(append([]string{}, c.secrets...), o.secrets...)
+	return c
+}
+
+// overlayMetrics returns base with each of add replacing the metric with the
+// same name, or appended, along with their positions.
+func overlayMetrics(base []Metric, basePos []position, add []Metric, addPos []position) ([]Metric, []position) {
+	metrics := append([]Metric{}, base...)
+	pos := padPositions(basePos, len(base))
+	index := map[string]int{}
+	for i, m := range metrics {
+		index[m.Name] = i
+	}
+	for i, m := range add {
+		p := positionAt(addPos, i)
+		if j, ok := index[m.Name]; ok {
+			metrics[j], pos[j] = m, p
+			continue
+		}
+		index[m.Name] = len(metrics)
+		metrics = append(metrics, m)
+		pos = append(pos, p)
+	}
+	return metrics, pos
+}
+
+// overlayInterfaces returns base with each of add replacing the interface with
+// the same name, or appended, along with their positions.
+func overlayInterfaces(base []Interface, basePos []position, add []Interface, addPos []position) ([]Interface, []position) {
+	ifaces := append([]Interface{}, base...)
+	pos := padPositions(basePos, len(base))
+	index := map[string]int{}
+	for i, iface := range ifaces {
+		index[iface.Name] = i
+	}
+	for i, iface := range add {
+		p := positionAt(addPos, i)
+		if j, ok := index[iface.Name]; ok {
+			ifaces[j], pos[j] = iface, p
+			continue
+		}
+		index[iface.Name] = len(ifaces)
+		ifaces = append(ifaces, iface)
+		pos = append(pos, p)
+	}
+	return ifaces, pos
+}
+
+// padPositions returns a copy of pos with n elements, since Configs created
+// in code have no positions.
+func padPositions(pos []position, n int) []position {
+	padded := make([]position, n)
+	copy(padded, pos)
+	return padded
+}
+
+// positionAt returns the i'th position, or an unknown position.
+func positionAt(pos []position, i int) position {
+	if i < len(pos) {
+		return pos[i]
+	}
+	return position{}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// writeConfigs writes files, keyed by path relative to a new temporary
+// directory, and returns the directory.
+func writeConfigs(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		rtx.Must(os.MkdirAll(filepath.Dir(path), 0755), "Could not create directory for %v", name)
+		rtx.Must(ioutil.WriteFile(path, []byte(data), 0644), "Could not write %v", name)
+	}
+	return dir
+}
+
+var includeConfigs = map[string]string{
+	"base.yaml": `
+replaceDefaults: true
+interfaces:
+  - name: machine
+    ifAlias: "{{.Machine}}"
+metrics:
+  - name: ifHCInOctets
+    description: Ingress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx
+  - name: ifHCOutOctets
+    description: Egress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.10
+    archiveNames:
+      machine: switch.octets.local.tx
+`,
+	"vendor/b-juniper.yaml": `
+metrics:
+  - name: jnxDomCurrentRxLaserPower
+    description: Receive power.
+    oid: JUNIPER-DOM-MIB::jnxDomCurrentRxLaserPower
+    type: gauge
+    archiveNames:
+      machine: switch.rxpower.local
+`,
+	"vendor/a-cisco.yaml": `
+metrics:
+  - name: entSensorValue
+    description: Sensor value.
+    oid: CISCO-ENTITY-SENSOR-MIB::entSensorValue
+    type: gauge
+    archiveNames:
+      machine: switch.sensor.local
+`,
+	"metrics.yaml": `
+include:
+  - base.yaml
+  - vendor/*.yaml
+metrics:
+  - name: ifHCInOctets
+    description: Overridden.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx
+`,
+}
+
+func TestLoadInclude(t *testing.T) {
+	dir := writeConfigs(t, includeConfigs)
+
+	c, err := New(filepath.Join(dir, "metrics.yaml"))
+	rtx.Must(err, "Could not load config with includes")
+
+	names := []string{}
+	for _, m := range c.Metrics {
+		names = append(names, m.Name)
+	}
+	expect := []string{"ifHCInOctets", "ifHCOutOctets", "entSensorValue", "jnxDomCurrentRxLaserPower"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("Expected metrics %v, but got: %v", expect, names)
+	}
+	if c.Metrics[0].Description != "Overridden." {
+		t.Errorf("Expected the including file to override ifHCInOctets, but got: %v", c.Metrics[0])
+	}
+	if len(c.Interfaces) != 1 || c.Include != nil {
+		t.Errorf("Expected the included interfaces and no includes, but got: %v, %v", c.Interfaces, c.Include)
+	}
+}
+
+func TestLoadIncludeProblems(t *testing.T) {
+	files := map[string]string{}
+	for k, v := range includeConfigs {
+		files[k] = v
+	}
+	files["vendor/a-cisco.yaml"] = strings.Replace(files["vendor/a-cisco.yaml"], "type: gauge", "type: meter", 1)
+	dir := writeConfigs(t, files)
+
+	_, err := New(filepath.Join(dir, "metrics.yaml"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 {
+		t.Fatalf("Expected one problem, but got: %v", err)
+	}
+	expect := Problem{File: filepath.Join(dir, "vendor/a-cisco.yaml"), Line: 6, Metric: "entSensorValue",
+		Message: "type 'meter' must be counter or gauge"}
+	if verr.Problems[0] != expect {
+		t.Errorf("Expected problem %v, but got: %v", expect, verr.Problems[0])
+	}
+}
+
+func TestLoadIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"metrics.yaml": "include: [a.yaml]\n",
+				"a.yaml":       "include: [metrics.yaml]\n",
+			},
+			wantErr: "includes itself",
+		},
+		{
+			name:    "no-match",
+			files:   map[string]string{"metrics.yaml": "include: [vendor/*.yaml]\n"},
+			wantErr: "matches no files",
+		},
+		{
+			name:    "bad-pattern",
+			files:   map[string]string{"metrics.yaml": "include: ['[']\n"},
+			wantErr: "invalid include",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigs(t, tt.files)
+			_, err := Load(filepath.Join(dir, "metrics.yaml"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, expected it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+// Interface is a named selector for a switch interface. An interface is
+// selected if it matches all of the criteria that are set. The name of the
+// Interface is used as the "scope" of the metrics collected from it.
+type Interface struct {
+	Name string `yaml:"name"`
+	// IfAlias must equal the interface's ifAlias. It is a Go text/template
+	// with the fields .Machine, .Hostname and .Target e.g., "{{.Machine}}".
+	IfAlias string `yaml:"ifAlias,omitempty"`
+	// IfAliasRegex, IfDescrRegex are regular expressions which must match
+	// the interface's ifAlias and ifDescr respectively.
+	IfAliasRegex string `yaml:"ifAliasRegex,omitempty"`
+	IfDescrRegex string `yaml:"ifDescrRegex,omitempty"`
+	// IfName must equal the interface's ifName.
+	IfName string `yaml:"ifName,omitempty"`
+	// IfIndex must equal the interface's logical interface number.
+	IfIndex int `yaml:"ifIndex,omitempty"`
+	// IfType must equal the interface's IANAifType e.g., 6 for
+	// ethernetCsmacd or 161 for ieee8023adLag.
+	IfType int `yaml:"ifType,omitempty"`
+	// Optional interfaces are skipped if no interface matches, instead of
+	// causing a fatal error.
+	Optional bool `yaml:"optional,omitempty"`
+	// Multiple collects every matching interface instead of only the first.
+	// If more than one interface matches, the ifDescr of each is appended
+	// to its archive names e.g., switch.octets.uplink.rx.xe-0_0_45.
+	Multiple bool `yaml:"multiple,omitempty"`
+	// Aggregate additionally archives the sum of all interfaces matched by a
+	// Multiple selector under the unsuffixed archive names.
+	Aggregate bool `yaml:"aggregate,omitempty"`
+	// LagMembers additionally collects the member ports of each matching
+	// interface which is a LAG (ifType ieee8023adLag), with the ifDescr of
+	// the member appended to its archive names.
+	LagMembers bool `yaml:"lagMembers,omitempty"`
+}
+
+// DefaultInterfaces are used by configs which do not define any interfaces.
+// They select the machine's interface, whose ifAlias is the machine name,
+// and the switch's uplinks, whose ifAlias starts with "uplink". If there is
+// more than one uplink, each is archived separately as well as in aggregate.
+var DefaultInterfaces = []Interface{
+	{
+		Name:    "machine",
+		IfAlias: "{{.Machine}}",
+	},
+	{
+		Name:         "uplink",
+		IfAliasRegex: "^uplink",
+		Multiple:     true,
+		Aggregate:    true,
+	},
+}
+
+// HasCriteria returns true if at least one selection criterion is set.
+func (i Interface) HasCriteria() bool {
+	return i.IfAlias != "" || i.IfAliasRegex != "" || i.IfDescrRegex != "" ||
+		i.IfName != "" || i.IfIndex != 0 || i.IfType != 0
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolateRegexp matches ${NAME} and ${file:PATH} references, and their
+// escaped form $${...}.
+var interpolateRegexp = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// Interpolate replaces each ${NAME} in the scalars of the YAML document data
+// with the value of the environment variable NAME, and each ${file:PATH} with
+// the contents of the file PATH, without a trailing newline. $${...} is
+// replaced with a literal ${...}.
+//
+// Interpolated scalars are quoted if necessary, so that values containing
+// e.g., " #" or ": " cannot change the structure of the document, and lines
+// are kept so that problems are reported on the right line. References must
+// be in single-line scalars of a valid YAML document.
+//
+// Since interpolated values are usually secrets, errors never include them,
+// and values containing newlines are rejected.
+func Interpolate(data []byte) ([]byte, error) {
+	result, _, err := interpolate(data)
+	return result, err
+}
+
+// interpolate is Interpolate(), which also returns the interpolated values.
+func interpolate(data []byte) ([]byte, []string, error) {
+	if !interpolateRegexp.Match(data) {
+		return data, nil, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		// The data doesn't contain secrets before interpolation, so the
+		// error can't include them.
+		return nil, nil, fmt.Errorf("references must be in the scalars of a valid YAML document e.g., quoted in flow collections: %v", err)
+	}
+
+	// Scalars are replaced from the end, so that replacing one doesn't move
+	// the others.
+	var scalars []scalar
+	collectScalars(&doc, false, &scalars)
+	sort.Slice(scalars, func(i, j int) bool {
+		a, b := scalars[i].node, scalars[j].node
+		return a.Line > b.Line || a.Line == b.Line && a.Column > b.Column
+	})
+
+	lines := strings.Split(string(data), "\n")
+	var values []string
+	for _, s := range scalars {
+		var err error
+		var value string
+		value, values, err = interpolateString(s.node.Value, values)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s.replace(lines, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), values, nil
+}
+
+// interpolateString returns s with its references replaced, and the values
+// of its references appended to values.
+func interpolateString(s string, values []string) (string, []string, error) {
+	var err error
+	result := interpolateRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		if match[1] == '$' {
+			return match[1:]
+		}
+		ref := interpolateRegexp.FindStringSubmatch(match)[1]
+		var value string
+		value, err = lookup(ref)
+		if err == nil && strings.ContainsAny(value, "\r\n") {
+			err = fmt.Errorf("the value of ${%v} must not contain newlines", ref)
+		}
+		values = append(values, value)
+		return value
+	})
+	return result, values, err
+}
+
+// scalar is a scalar node with references, and whether it is in a flow
+// collection, where more characters must be quoted.
+type scalar struct {
+	node *yaml.Node
+	flow bool
+}
+
+// collectScalars appends the scalars with references under n to scalars.
+func collectScalars(n *yaml.Node, flow bool, scalars *[]scalar) {
+	if n.Kind == yaml.ScalarNode && interpolateRegexp.MatchString(n.Value) {
+		*scalars = append(*scalars, scalar{node: n, flow: flow})
+	}
+	flow = flow || n.Style&yaml.FlowStyle != 0
+	for _, c := range n.Content {
+		collectScalars(c, flow, scalars)
+	}
+}
+
+// replace replaces the source of the scalar in lines with value, as a plain
+// scalar if the scalar was plain and value is the same as a plain scalar, or
+// else as a double-quoted scalar.
+func (s scalar) replace(lines []string, value string) error {
+	n := s.node
+	if n.Line < 1 || n.Line > len(lines) {
+		return fmt.Errorf("line %d: references must be in single-line scalars", n.Line)
+	}
+	line := []rune(lines[n.Line-1])
+	start := n.Column - 1
+	end := -1
+	switch n.Style &^ yaml.FlowStyle {
+	case 0:
+		end = start + len([]rune(n.Value))
+		if end > len(line) || string(line[start:end]) != n.Value {
+			end = -1
+		}
+	case yaml.SingleQuotedStyle:
+		src := "'" + strings.ReplaceAll(n.Value, "'", "''") + "'"
+		end = start + len([]rune(src))
+		if end > len(line) || string(line[start:end]) != src {
+			end = -1
+		}
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	}
+	if start < 0 || start >= len(line) || end < 0 {
+		return fmt.Errorf("line %d: references must be in single-line scalars", n.Line)
+	}
+
+	src := value
+	if n.Style != 0 || !isPlain(value, s.flow) {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		// JSON strings are valid YAML double-quoted scalars.
+		src = string(b)
+	}
+	lines[n.Line-1] = string(line[:start]) + src + string(line[end:])
+	return nil
+}
+
+// isPlain returns whether value is decoded as itself when written as a plain
+// scalar, in a flow collection if flow is true.
+func isPlain(value string, flow bool) bool {
+	doc := "k: " + value
+	if flow {
+		doc = "k: [" + value + "]"
+	}
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte(doc), &n); err != nil || len(n.Content) != 1 {
+		return false
+	}
+	v := mappingValue(n.Content[0], "k")
+	if flow && v != nil {
+		if len(v.Content) != 1 {
+			return false
+		}
+		v = v.Content[0]
+	}
+	return v != nil && v.Kind == yaml.ScalarNode && v.Style == 0 && v.Value == value &&
+		v.LineComment == "" && v.Anchor == ""
+}
+
+// lookup returns the value referred to by ref, either an environment variable
+// name or "file:" followed by a path.
+func lookup(ref string) (string, error) {
+	if path := strings.TrimPrefix(ref, "file:"); path != ref {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			// The error from ReadFile only includes the path.
+			return "", fmt.Errorf("failed to read ${%v}: %v", ref, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if ref == "" {
+		return "", fmt.Errorf("empty reference ${}")
+	}
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %v referenced by ${%v} is not set", ref, ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestInterpolate(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	rtx.Must(ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600), "Failed to write secret file")
+	multiline := filepath.Join(dir, "multiline")
+	rtx.Must(ioutil.WriteFile(multiline, []byte("line1\nline2\n"), 0600), "Failed to write multiline file")
+	t.Setenv("DISCO_TEST_VALUE", "from-env")
+	t.Setenv("DISCO_TEST_SPECIAL", `p@ss #word: {"x"}`)
+	t.Setenv("DISCO_TEST_NUMBER", "568")
+	os.Unsetenv("DISCO_TEST_UNSET")
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{"env", "a: ${DISCO_TEST_VALUE}", "a: from-env", ""},
+		{"file", "a: ${file:" + secretFile + "}", "a: from-file", ""},
+		{"escaped", "a: $${DISCO_TEST_VALUE} $5", "a: ${DISCO_TEST_VALUE} $5", ""},
+		{"none", "a: b", "a: b", ""},
+		// Values which would change the structure of the document are quoted.
+		{"special", "a: ${DISCO_TEST_SPECIAL} # comment\nb: c", `a: "p@ss #word: {\"x\"}" # comment` + "\nb: c", ""},
+		{"special-in-string", `a: "${DISCO_TEST_SPECIAL}!"`, `a: "p@ss #word: {\"x\"}!"`, ""},
+		{"single-quoted", "a: '${DISCO_TEST_VALUE}'", `a: "from-env"`, ""},
+		{"flow", "a: ['${DISCO_TEST_NUMBER}', '${DISCO_TEST_SPECIAL}']\nb: ${DISCO_TEST_NUMBER}",
+			`a: ["568", "p@ss #word: {\"x\"}"]` + "\nb: 568", ""},
+		{"flow-unquoted", "a: [${DISCO_TEST_NUMBER}]", "", "quoted in flow collections"},
+		{"block-scalar", "a: |\n  ${DISCO_TEST_VALUE}\n", "", "single-line scalars"},
+		{"unset", "a: ${DISCO_TEST_UNSET}", "", "DISCO_TEST_UNSET"},
+		{"empty", "a: ${}", "", "empty reference"},
+		{"missing-file", "a: ${file:" + dir + "/missing}", "", "failed to read"},
+		{"newlines", "a: ${file:" + multiline + "}", "", "must not contain newlines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Interpolate([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Interpolate() error = %v, expected it to contain %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "line1") {
+					t.Errorf("Interpolate() error includes the secret value: %v", err)
+				}
+				return
+			}
+			rtx.Must(err, "Failed to interpolate")
+			if string(got) != tt.want {
+				t.Errorf("Interpolate() = %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Override changes the interfaces and metrics of a Config for the switches
+// whose target, and the nodes whose hostname, match. For example, it may
+// enable vendor-specific OIDs only for the switches of some sites.
+type Override struct {
+	// Target and Hostname are regular expressions which must match the whole
+	// switch target and node hostname respectively. At least one is required,
+	// and all that are set must match.
+	Target   string `yaml:"target,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+	// Interfaces and Metrics replace those of the Config with the same name,
+	// or are added.
+	Interfaces []Interface `yaml:"interfaces,omitempty"`
+	Metrics    []Metric    `yaml:"metrics,omitempty"`
+	// Disable lists the names of metrics which are not collected.
+	Disable []string `yaml:"disable,omitempty"`
+
+	// pos is the location of the Override, and positions and
+	// interfacePositions those of its Metrics and Interfaces.
+	pos                position
+	positions          []position
+	interfacePositions []position
+}
+
+// Matches returns true if the Override applies to the switch target and the
+// node hostname.
+func (o Override) Matches(target, hostname string) (bool, error) {
+	for _, p := range []struct {
+		pattern string
+		value   string
+	}{
+		{o.Target, target},
+		{o.Hostname, hostname},
+	} {
+		if p.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + p.pattern + ")$")
+		if err != nil {
+			return false, err
+		}
+		if !re.MatchString(p.value) {
+			return false, nil
+		}
+	}
+	return o.Target != "" || o.Hostname != "", nil
+}
+
+// ForTarget returns the Config for the switch target and the node hostname,
+// with every matching Override applied in order, and without Overrides.
+// Since Validate() only checks each Override and Profile on its own, the
+// result is validated again, and a *ValidationError is returned if e.g., two
+// matching Overrides use the same archive name.
+func (c Config) ForTarget(target, hostname string) (Config, error) {
+	result := c
+	result.Overrides = nil
+	for i, o := range c.Overrides {
+		ok, err := o.Matches(target, hostname)
+		if err != nil {
+			return c, fmt.Errorf("invalid override #%d: %v", i+1, err)
+		}
+		if ok {
+			result = result.apply(o)
+		}
+	}
+	if problems := result.validate(); len(problems) > 0 {
+		return c, &ValidationError{Problems: problems}
+	}
+	return result, nil
+}
+
+// apply returns c with the Override o applied.
+func (c Config) apply(o Override) Config {
+	// Adding interfaces to a Config which uses the DefaultInterfaces must keep
+	// them.
+	if len(c.Interfaces) == 0 && len(o.Interfaces) > 0 {
+		c.Interfaces = append([]Interface{}, DefaultInterfaces...)
+		c.interfacePositions = nil
+	}
+	c.Interfaces, c.interfacePositions = overlayInterfaces(
+		c.Interfaces, c.interfacePositions, o.Interfaces, o.interfacePositions)
+	c.Metrics, c.positions = overlayMetrics(c.Metrics, c.positions, o.Metrics, o.positions)
+
+	if len(o.Disable) == 0 {
+		return c
+	}
+	disabled := map[string]bool{}
+	for _, name := range o.Disable {
+		disabled[name] = true
+	}
+	metrics := []Metric{}
+	positions := []position{}
+	for i, m := range c.Metrics {
+		if !disabled[m.Name] {
+			metrics = append(metrics, m)
+			positions = append(positions, c.positions[i])
+		}
+	}
+	c.Metrics, c.positions = metrics, positions
+	return c
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+var overridesYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    description: Ingress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+  - name: ifInErrors
+    description: Ingress errors.
+    oidStub: .1.3.6.1.2.1.2.2.1.14
+    mlabUplinkName: switch.errors.uplink.rx
+    mlabMachineName: switch.errors.local.rx
+overrides:
+  - target: s1-(lga|nyc)0t\.measurement-lab\.org
+    interfaces:
+      - name: bmc
+        ifAlias: "drac-{{.Machine}}"
+    metrics:
+      - name: jnxDomCurrentRxLaserPower
+        description: Receive power.
+        oid: jnxDomCurrentRxLaserPower
+        type: gauge
+        archiveNames:
+          uplink: switch.rxpower.uplink
+          bmc: switch.rxpower.bmc
+  - hostname: mlab4-.*
+    disable: [ifInErrors]
+`
+
+func TestForTarget(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{"metrics.yaml": overridesYaml})
+	c, err := New(filepath.Join(dir, "metrics.yaml"))
+	rtx.Must(err, "Could not load config with overrides")
+
+	tests := []struct {
+		target     string
+		hostname   string
+		metrics    []string
+		interfaces []string
+	}{
+		{
+			target:     "s1-abc0t.measurement-lab.org",
+			hostname:   "mlab1-abc0t.mlab-oti.measurement-lab.org",
+			metrics:    []string{"ifHCInOctets", "ifInErrors"},
+			interfaces: []string{"machine", "uplink"},
+		},
+		{
+			target:     "s1-lga0t.measurement-lab.org",
+			hostname:   "mlab1-lga0t.mlab-oti.measurement-lab.org",
+			metrics:    []string{"ifHCInOctets", "ifInErrors", "jnxDomCurrentRxLaserPower"},
+			interfaces: []string{"machine", "uplink", "bmc"},
+		},
+		{
+			target:     "s1-nyc0t.measurement-lab.org",
+			hostname:   "mlab4-nyc0t.mlab-oti.measurement-lab.org",
+			metrics:    []string{"ifHCInOctets", "jnxDomCurrentRxLaserPower"},
+			interfaces: []string{"machine", "uplink", "bmc"},
+		},
+		{
+			// The target must match completely.
+			target:     "s1-lga0t.measurement-lab.org.example.net",
+			hostname:   "xmlab4-lga0t",
+			metrics:    []string{"ifHCInOctets", "ifInErrors"},
+			interfaces: []string{"machine", "uplink"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+"/"+tt.hostname, func(t *testing.T) {
+			got, err := c.ForTarget(tt.target, tt.hostname)
+			rtx.Must(err, "ForTarget() failed")
+			rtx.Must(got.Validate(), "Invalid config for target")
+			metrics, interfaces := []string{}, []string{}
+			for _, m := range got.Metrics {
+				metrics = append(metrics, m.Name)
+			}
+			for _, i := range got.InterfaceSelectors() {
+				interfaces = append(interfaces, i.Name)
+			}
+			if !reflect.DeepEqual(metrics, tt.metrics) || !reflect.DeepEqual(interfaces, tt.interfaces) {
+				t.Errorf("ForTarget() = %v, %v, expected %v, %v", metrics, interfaces, tt.metrics, tt.interfaces)
+			}
+			if got.Overrides != nil {
+				t.Errorf("Expected no overrides, but got: %v", got.Overrides)
+			}
+		})
+	}
+
+	lga, err := c.ForTarget("s1-lga0t.measurement-lab.org", "mlab1-lga0t")
+	rtx.Must(err, "ForTarget() failed")
+	if oid := lga.Metrics[2].OidStub; oid != ".1.3.6.1.4.1.2636.3.60.1.1.1.1.5" {
+		t.Errorf("Expected the override's symbolic OID to be resolved, but got: %v", oid)
+	}
+}
+
+var invalidOverridesYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+overrides:
+  - disable: [ifHCOutOctets]
+  - target: "s1-("
+    metrics:
+      - name: ifHCInOctetsCopy
+        oidStub: .1.3.6.1.2.1.31.1.1.1.6
+        mlabUplinkName: switch.octets.uplink.rx
+        mlabMachineName: switch.octets.local.rx
+`
+
+func TestValidateOverrides(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(invalidOverridesYaml))
+	rtx.Must(err, "parse() failed")
+
+	err = c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 9, Message: "override #1: target or hostname is required"},
+		{File: "metrics.yaml", Line: 9, Message: "override #1: disable refers to undefined metric 'ifHCOutOctets'"},
+		{File: "metrics.yaml", Line: 10, Message: "override #2: invalid regular expression: error parsing regexp: missing closing ): `s1-(`"},
+		{File: "metrics.yaml", Line: 15, Metric: "ifHCInOctetsCopy",
+			Message: "archive name 'switch.octets.local.rx' is already used by metric #1"},
+		{File: "metrics.yaml", Line: 14, Metric: "ifHCInOctetsCopy",
+			Message: "archive name 'switch.octets.uplink.rx' is already used by metric #1"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+}
+
+var conflictingOverridesYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+overrides:
+  - target: s1-lga0t\.measurement-lab\.org
+    metrics:
+      - name: ifInErrors
+        oidStub: .1.3.6.1.2.1.2.2.1.14
+        mlabUplinkName: switch.errors.uplink.rx
+        mlabMachineName: switch.errors.local.rx
+  - hostname: mlab1-.*
+    metrics:
+      - name: ifInDiscards
+        oidStub: .1.3.6.1.2.1.2.2.1.13
+        mlabUplinkName: switch.errors.uplink.rx
+        mlabMachineName: switch.discards.local.rx
+`
+
+func TestForTargetValidates(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(conflictingOverridesYaml))
+	rtx.Must(err, "parse() failed")
+	// Each override is valid on its own.
+	rtx.Must(c.Validate(), "Invalid config")
+
+	_, err = c.ForTarget("s1-nyc0t.measurement-lab.org", "mlab1-nyc0t")
+	rtx.Must(err, "ForTarget() failed")
+
+	_, err = c.ForTarget("s1-lga0t.measurement-lab.org", "mlab1-lga0t")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 19, Metric: "ifInDiscards",
+			Message: "archive name 'switch.errors.uplink.rx' is already used by metric #2"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GenericProfile is the name of the profile used for switches which match no
+// Profile, which collects only the metrics of the Config itself, typically
+// the IF-MIB counters.
+const GenericProfile = "generic"
+
+// Profile changes the interfaces and metrics of a Config for a switch model or
+// vendor, detected from the sysObjectID and sysDescr of the switch. For
+// example, a profile may add the optical sensors of Juniper switches.
+type Profile struct {
+	// Name identifies the profile in logs, metrics and archive manifests.
+	Name string `yaml:"name"`
+	// SysObjectID lists numeric or symbolic OIDs e.g., enterprises.2636 for
+	// Juniper. The profile matches if the sysObjectID of the switch is one of
+	// them, or is below one of them.
+	SysObjectID []string `yaml:"sysObjectID,omitempty"`
+	// SysDescr is a regular expression which must match part of the
+	// sysDescr of the switch. If both SysObjectID and SysDescr are set, both
+	// must match.
+	SysDescr string `yaml:"sysDescr,omitempty"`
+	// Interfaces, Metrics and Disable change the Config as for an Override.
+	Interfaces []Interface `yaml:"interfaces,omitempty"`
+	Metrics    []Metric    `yaml:"metrics,omitempty"`
+	Disable    []string    `yaml:"disable,omitempty"`
+
+	// pos is the location of the Profile, and positions and
+	// interfacePositions those of its Metrics and Interfaces.
+	pos                position
+	positions          []position
+	interfacePositions []position
+}
+
+// override returns the changes of the Profile as an Override.
+func (p Profile) override() Override {
+	return Override{
+		Interfaces:         p.Interfaces,
+		Metrics:            p.Metrics,
+		Disable:            p.Disable,
+		pos:                p.pos,
+		positions:          p.positions,
+		interfacePositions: p.interfacePositions,
+	}
+}
+
+// matches returns true if the Profile p applies to a switch with the given
+// sysObjectID and sysDescr. Symbolic OIDs are resolved with the Resolver of
+// c.
+func (c Config) matches(p Profile, sysObjectID, sysDescr string) (bool, error) {
+	if len(p.SysObjectID) > 0 {
+		found := false
+		for _, s := range p.SysObjectID {
+			prefix, err := c.Resolver().Resolve(s)
+			if err != nil {
+				return false, err
+			}
+			if sysObjectID == prefix || strings.HasPrefix(sysObjectID, prefix+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if p.SysDescr != "" {
+		re, err := regexp.Compile(p.SysDescr)
+		if err != nil {
+			return false, err
+		}
+		if !re.MatchString(sysDescr) {
+			return false, nil
+		}
+	}
+	return len(p.SysObjectID) > 0 || p.SysDescr != "", nil
+}
+
+// ForDevice returns the Config for a switch with the given sysObjectID and
+// sysDescr, with the first matching Profile applied and without Profiles,
+// along with the name of the profile. If no Profile matches, or both are
+// empty because the switch could not be identified, the Config is returned
+// unchanged with the GenericProfile name.
+func (c Config) ForDevice(sysObjectID, sysDescr string) (Config, string, error) {
+	result := c
+	result.Profiles = nil
+	if sysObjectID == "" && sysDescr == "" {
+		return result, GenericProfile, nil
+	}
+	for i, p := range c.Profiles {
+		ok, err := c.matches(p, sysObjectID, sysDescr)
+		if err != nil {
+			return c, "", fmt.Errorf("invalid profile #%d: %v", i+1, err)
+		}
+		if ok {
+			return result.apply(p.override()), p.Name, nil
+		}
+	}
+	return result, GenericProfile, nil
+}
+
+// ForProfile returns the Config with the Profile called name applied, and
+// without Profiles. The GenericProfile applies no Profile.
+func (c Config) ForProfile(name string) (Config, error) {
+	result := c
+	result.Profiles = nil
+	if name == GenericProfile {
+		return result, nil
+	}
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return result.apply(p.override()), nil
+		}
+	}
+	return c, fmt.Errorf("unknown profile '%v'", name)
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+var profilesYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    description: Ingress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+  - name: ifInErrors
+    description: Ingress errors.
+    oidStub: .1.3.6.1.2.1.2.2.1.14
+    mlabUplinkName: switch.errors.uplink.rx
+    mlabMachineName: switch.errors.local.rx
+profiles:
+  - name: juniper-qfx
+    sysObjectID: [enterprises.2636]
+    sysDescr: qfx
+    metrics:
+      - name: jnxDomCurrentRxLaserPower
+        description: Receive power.
+        oid: jnxDomCurrentRxLaserPower
+        type: gauge
+        archiveNames:
+          uplink: switch.rxpower.uplink
+  - name: juniper
+    sysObjectID: [.1.3.6.1.4.1.2636]
+  - name: arista
+    sysDescr: Arista Networks
+    disable: [ifInErrors]
+`
+
+func TestForDevice(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{"metrics.yaml": profilesYaml})
+	c, err := New(filepath.Join(dir, "metrics.yaml"))
+	rtx.Must(err, "Could not load config with profiles")
+
+	tests := []struct {
+		sysObjectID string
+		sysDescr    string
+		profile     string
+		metrics     []string
+	}{
+		{
+			sysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82",
+			sysDescr:    "Juniper Networks, Inc. qfx5100-48s-6q Ethernet Switch",
+			profile:     "juniper-qfx",
+			metrics:     []string{"ifHCInOctets", "ifInErrors", "jnxDomCurrentRxLaserPower"},
+		},
+		{
+			sysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.29",
+			sysDescr:    "Juniper Networks, Inc. mx240 internet router",
+			profile:     "juniper",
+			metrics:     []string{"ifHCInOctets", "ifInErrors"},
+		},
+		{
+			// The sysObjectID must be below a profile's OID.
+			sysObjectID: ".1.3.6.1.4.1.26360.1",
+			sysDescr:    "qfx",
+			profile:     GenericProfile,
+			metrics:     []string{"ifHCInOctets", "ifInErrors"},
+		},
+		{
+			sysObjectID: ".1.3.6.1.4.1.30065.1.3011.7050.3741.64",
+			sysDescr:    "Arista Networks EOS version 4.20.1F running on an Arista Networks DCS-7050SX-64",
+			profile:     "arista",
+			metrics:     []string{"ifHCInOctets"},
+		},
+		{
+			profile: GenericProfile,
+			metrics: []string{"ifHCInOctets", "ifInErrors"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile+"/"+tt.sysObjectID, func(t *testing.T) {
+			got, profile, err := c.ForDevice(tt.sysObjectID, tt.sysDescr)
+			rtx.Must(err, "ForDevice() failed")
+			metrics := []string{}
+			for _, m := range got.Metrics {
+				metrics = append(metrics, m.Name)
+			}
+			if profile != tt.profile || !reflect.DeepEqual(metrics, tt.metrics) {
+				t.Errorf("ForDevice() = %v, %v, expected %v, %v", profile, metrics, tt.profile, tt.metrics)
+			}
+			if got.Profiles != nil {
+				t.Errorf("Expected no profiles, but got: %v", got.Profiles)
+			}
+		})
+	}
+}
+
+func TestForProfile(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{"metrics.yaml": profilesYaml})
+	c, err := New(filepath.Join(dir, "metrics.yaml"))
+	rtx.Must(err, "Could not load config with profiles")
+
+	tests := []struct {
+		name    string
+		metrics []string
+		wantErr bool
+	}{
+		{name: "juniper-qfx", metrics: []string{"ifHCInOctets", "ifInErrors", "jnxDomCurrentRxLaserPower"}},
+		{name: "arista", metrics: []string{"ifHCInOctets"}},
+		{name: GenericProfile, metrics: []string{"ifHCInOctets", "ifInErrors"}},
+		{name: "cisco", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.ForProfile(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ForProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			metrics := []string{}
+			for _, m := range got.Metrics {
+				metrics = append(metrics, m.Name)
+			}
+			if !reflect.DeepEqual(metrics, tt.metrics) || got.Profiles != nil {
+				t.Errorf("ForProfile() = %v, %v, expected %v and no profiles", metrics, got.Profiles, tt.metrics)
+			}
+		})
+	}
+}
+
+var invalidProfilesYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+profiles:
+  - name: generic
+    sysDescr: "("
+  - name: juniper
+    sysObjectID: [JUNIPER-SMI::juniperMIB]
+    disable: [ifHCOutOctets]
+  - name: juniper
+`
+
+func TestValidateProfiles(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(invalidProfilesYaml))
+	rtx.Must(err, "parse() failed")
+
+	err = c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 9, Message: "profile #1: name 'generic' is reserved for switches without a profile"},
+		{File: "metrics.yaml", Line: 10, Message: "profile #1: invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{File: "metrics.yaml", Line: 12, Message: "profile #2: unknown MIB object 'JUNIPER-SMI::juniperMIB'"},
+		{File: "metrics.yaml", Line: 13, Message: "profile #2: disable refers to undefined metric 'ifHCOutOctets'"},
+		{File: "metrics.yaml", Line: 14, Message: "profile #3: name is already used by profile #2"},
+		{File: "metrics.yaml", Line: 14, Message: "profile #3: sysObjectID or sysDescr is required"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// redacted replaces the interpolated values in Redacted().
+const redacted = "<redacted>"
+
+// Redacted returns a copy of the Config in which every value interpolated
+// into its files is replaced by "<redacted>", since they are usually secrets.
+// It is meant for displaying the Config e.g., by print-config.
+func (c Config) Redacted() Config {
+	secrets := []string{}
+	for _, s := range c.secrets {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	if len(secrets) == 0 {
+		return c
+	}
+	// The longest values are replaced first, in case one contains another.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, redacted)
+	}
+	return redact(reflect.ValueOf(c), strings.NewReplacer(pairs...)).Interface().(Config)
+}
+
+// redact returns a copy of v in which the secrets are replaced in every
+// string of its exported fields, slices and maps.
+func redact(v reflect.Value, r *strings.Replacer) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(r.Replace(v.String())).Convert(v.Type())
+	case reflect.Struct:
+		result := reflect.New(v.Type()).Elem()
+		result.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				result.Field(i).Set(redact(v.Field(i), r))
+			}
+		}
+		return result
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(redact(v.Index(i), r))
+		}
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		result := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result.SetMapIndex(redact(iter.Key(), r), redact(iter.Value(), r))
+		}
+		return result
+	default:
+		return v
+	}
+}
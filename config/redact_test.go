@@ -0,0 +1,55 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRedacted(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DISCO_TEST_SECRET", "hunter2")
+	t.Setenv("DISCO_TEST_EMPTY", "")
+	included := filepath.Join(dir, "included.yaml")
+	rtx.Must(ioutil.WriteFile(included, []byte(`metrics:
+  - name: ifHCInOctets
+    description: Ingress octets, token ${DISCO_TEST_SECRET}.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx${DISCO_TEST_EMPTY}
+`), 0644), "Failed to write included file")
+	file := filepath.Join(dir, "metrics.yaml")
+	rtx.Must(ioutil.WriteFile(file, []byte(`include: [included.yaml]
+overrides:
+  - target: ${DISCO_TEST_SECRET}
+    disable: [ifHCInOctets]
+`), 0644), "Failed to write metrics file")
+
+	c, err := Load(file)
+	rtx.Must(err, "Failed to load %v", file)
+	data, err := yaml.Marshal(c.Redacted())
+	rtx.Must(err, "Failed to marshal the redacted config")
+	got := string(data)
+	if strings.Contains(got, "hunter2") || strings.Count(got, "<redacted>") != 2 {
+		t.Errorf("Expected both interpolated values to be redacted, but got:\n%v", got)
+	}
+	if !strings.Contains(got, "switch.octets.local.rx") {
+		t.Errorf("Expected the archive name to be kept, but got:\n%v", got)
+	}
+	if c.Metrics[0].Description != "Ingress octets, token hunter2." || c.Overrides[0].Target != "hunter2" {
+		t.Errorf("Expected Redacted() to leave the Config unchanged, but got: %+v", c)
+	}
+
+	// The hash doesn't depend on the secrets, so it can't be used to guess
+	// them.
+	t.Setenv("DISCO_TEST_SECRET", "hunter3")
+	other, err := Load(file)
+	rtx.Must(err, "Failed to load %v", file)
+	if other.Hash() != c.Hash() {
+		t.Errorf("Expected the hash not to depend on interpolated values, but got: %v and %v", c.Hash(), other.Hash())
+	}
+}
@@ -0,0 +1,439 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/mib"
+)
+
+var (
+	// oidStubRegexp matches numeric OIDs with a leading dot, which is how
+	// gosnmp names the OIDs in responses.
+	oidStubRegexp = regexp.MustCompile(`^(\.[0-9]+)+$`)
+	// promNameRegexp matches valid Prometheus metric names.
+	promNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	// legacyFields are the deprecated Metric fields holding the archive names
+	// for the DefaultInterfaces.
+	legacyFields = map[string]string{
+		"machine": "mlabMachineName",
+		"uplink":  "mlabUplinkName",
+	}
+)
+
+// reservedPrefix starts the names of DISCO's own metrics, such as
+// disco_collect_duration_seconds, which switch metrics must not collide with.
+const reservedPrefix = "disco_"
+
+// Problem is a single problem found in a Config by Validate().
+type Problem struct {
+	File string
+	// Line is the line of File on which the problem occurs, or 0 if unknown.
+	Line int
+	// Metric and Interface are the names of the metric or interface with the
+	// problem, if any.
+	Metric    string
+	Interface string
+	Message   string
+}
+
+// String formats the Problem as "file:line: metric 'name': message".
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		if p.Line > 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+		}
+		b.WriteString(": ")
+	}
+	if p.Metric != "" {
+		fmt.Fprintf(&b, "metric '%v': ", p.Metric)
+	}
+	if p.Interface != "" {
+		fmt.Fprintf(&b, "interface '%v': ", p.Interface)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// attrs returns the log attributes of the Problem, without the empty ones.
+func (p Problem) attrs() []any {
+	attrs := []any{"problem", p.Message}
+	if p.File != "" {
+		attrs = append(attrs, "file", p.File)
+	}
+	if p.Line > 0 {
+		attrs = append(attrs, "line", p.Line)
+	}
+	if p.Metric != "" {
+		attrs = append(attrs, logging.MetricKey, p.Metric)
+	}
+	if p.Interface != "" {
+		attrs = append(attrs, "interface", p.Interface)
+	}
+	return attrs
+}
+
+// ValidationError is returned by Validate() and lists every problem found.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error returns all problems, one per line.
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+	return fmt.Sprintf("%d problem(s) found:\n%v", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// validator accumulates the problems found in a Config.
+type validator struct {
+	c        Config
+	problems []Problem
+}
+
+// add records a problem with the key field of the mapping at pos. If pos is
+// nil, the problem applies to the whole Config.
+func (v *validator) add(p Problem, pos *position, field string, format string, args ...interface{}) {
+	p.File = v.c.file
+	p.Message = fmt.Sprintf(format, args...)
+	if pos != nil {
+		if pos.file != "" {
+			p.File = pos.file
+		}
+		p.Line = pos.line
+		if line, ok := pos.fields[field]; ok {
+			p.Line = line
+		}
+	}
+	v.problems = append(v.problems, p)
+}
+
+// metric records a problem with the key field of the i'th Metric.
+func (v *validator) metric(i int, field string, format string, args ...interface{}) {
+	var pos *position
+	if i < len(v.c.positions) {
+		pos = &v.c.positions[i]
+	}
+	v.add(Problem{Metric: v.c.Metrics[i].Name}, pos, field, format, args...)
+}
+
+// iface records a problem with the key field of the i'th Interface.
+func (v *validator) iface(i int, field string, format string, args ...interface{}) {
+	var pos *position
+	if i < len(v.c.interfacePositions) {
+		pos = &v.c.interfacePositions[i]
+	}
+	v.add(Problem{Interface: v.c.Interfaces[i].Name}, pos, field, format, args...)
+}
+
+// Validate checks that every Metric and Interface has the required fields,
+// that OIDs, regular expressions and templates are well-formed, that names
+// are valid Prometheus metric names and that no metric, interface or archive
+// name is used twice. The Config is also checked with each Override and each
+// Profile applied.
+// If any problems are found, a *ValidationError listing all of them is
+// returned.
+func (c Config) Validate() error {
+	problems := c.validate()
+
+	seen := map[Problem]bool{}
+	for _, p := range problems {
+		seen[p] = true
+	}
+	for i, o := range c.Overrides {
+		v := &validator{c: c}
+		v.override(i, o)
+		// Problems of the Config itself are only reported once.
+		for _, p := range append(v.problems, c.apply(o).validate()...) {
+			if !seen[p] {
+				seen[p] = true
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	profileNames := map[string]int{}
+	for i, p := range c.Profiles {
+		v := &validator{c: c}
+		v.profile(i, p, profileNames)
+		for _, problem := range append(v.problems, c.apply(p.override()).validate()...) {
+			if !seen[problem] {
+				seen[problem] = true
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Warnings returns the likely mistakes in the Config which don't make it
+// invalid: interfaces from which no metric is collected, such as a custom
+// interface missing from the archiveNames of the built-in metrics.
+func (c Config) Warnings() []Problem {
+	metrics := append([]Metric{}, c.Metrics...)
+	for _, o := range c.Overrides {
+		metrics = append(metrics, o.Metrics...)
+	}
+	for _, p := range c.Profiles {
+		metrics = append(metrics, p.Metrics...)
+	}
+	v := &validator{c: c}
+	for i, iface := range c.Interfaces {
+		collected := false
+		for _, m := range metrics {
+			if m.ArchiveName(iface.Name) != "" {
+				collected = true
+				break
+			}
+		}
+		if !collected {
+			v.iface(i, "name", "no metric has an archive name for this interface, so nothing is collected from it "+
+				"(built-in metrics are only collected from the interfaces in their archiveNames)")
+		}
+	}
+	return v.problems
+}
+
+// override checks the match criteria and Disable list of the i'th Override.
+func (v *validator) override(i int, o Override) {
+	prefix := fmt.Sprintf("override #%d: ", i+1)
+	add := func(field, format string, args ...interface{}) {
+		v.add(Problem{}, &o.pos, field, prefix+format, args...)
+	}
+	if o.Target == "" && o.Hostname == "" {
+		add("target", "target or hostname is required")
+	}
+	for _, field := range []struct {
+		key   string
+		value string
+	}{
+		{"target", o.Target},
+		{"hostname", o.Hostname},
+	} {
+		if _, err := regexp.Compile(field.value); err != nil {
+			add(field.key, "invalid regular expression: %v", err)
+		}
+	}
+
+	v.disable(o, add)
+}
+
+// profile checks the name, match criteria and Disable list of the i'th
+// Profile. names maps the names of earlier profiles to their index.
+func (v *validator) profile(i int, p Profile, names map[string]int) {
+	prefix := fmt.Sprintf("profile #%d: ", i+1)
+	add := func(field, format string, args ...interface{}) {
+		v.add(Problem{}, &p.pos, field, prefix+format, args...)
+	}
+	switch {
+	case p.Name == "":
+		add("name", "name is required")
+	case p.Name == GenericProfile:
+		add("name", "name '%v' is reserved for switches without a profile", p.Name)
+	default:
+		if j, ok := names[p.Name]; ok {
+			add("name", "name is already used by profile #%d", j+1)
+		} else {
+			names[p.Name] = i
+		}
+	}
+	if len(p.SysObjectID) == 0 && p.SysDescr == "" {
+		add("sysObjectID", "sysObjectID or sysDescr is required")
+	}
+	for _, s := range p.SysObjectID {
+		if _, err := v.c.Resolver().Resolve(s); err != nil {
+			add("sysObjectID", "%v", err)
+		}
+	}
+	if _, err := regexp.Compile(p.SysDescr); err != nil {
+		add("sysDescr", "invalid regular expression: %v", err)
+	}
+	v.disable(p.override(), add)
+}
+
+// disable checks that the Disable list of o refers to metrics of the Config
+// or of o, reporting problems with add.
+func (v *validator) disable(o Override, add func(field, format string, args ...interface{})) {
+	names := map[string]bool{}
+	for _, m := range v.c.Metrics {
+		names[m.Name] = true
+	}
+	for _, m := range o.Metrics {
+		names[m.Name] = true
+	}
+	for _, name := range o.Disable {
+		if !names[name] {
+			add("disable", "disable refers to undefined metric '%v'", name)
+		}
+	}
+}
+
+// validate returns the problems found in the Config, without Overrides.
+func (c Config) validate() []Problem {
+	v := &validator{c: c}
+
+	if len(c.Metrics) == 0 {
+		v.add(Problem{}, nil, "", "no metrics are defined")
+	}
+
+	ifaceNames := map[string]bool{}
+	for i, iface := range c.Interfaces {
+		switch {
+		case iface.Name == "":
+			v.iface(i, "name", "name is required")
+		case ifaceNames[iface.Name]:
+			v.iface(i, "name", "name is already used by another interface")
+		default:
+			ifaceNames[iface.Name] = true
+		}
+		if !iface.HasCriteria() {
+			v.iface(i, "name", "at least one of ifAlias, ifAliasRegex, ifDescrRegex, ifName, ifIndex or ifType is required")
+		}
+		if _, err := template.New("ifAlias").Parse(iface.IfAlias); err != nil {
+			v.iface(i, "ifAlias", "invalid template: %v", err)
+		}
+		for _, field := range []struct {
+			key   string
+			value string
+		}{
+			{"ifAliasRegex", iface.IfAliasRegex},
+			{"ifDescrRegex", iface.IfDescrRegex},
+		} {
+			if _, err := regexp.Compile(field.value); err != nil {
+				v.iface(i, field.key, "invalid regular expression: %v", err)
+			}
+		}
+		if iface.IfIndex < 0 {
+			v.iface(i, "ifIndex", "ifIndex must be positive")
+		}
+		if iface.IfType < 0 {
+			v.iface(i, "ifType", "ifType must be positive")
+		}
+		if iface.Aggregate && !iface.Multiple {
+			v.iface(i, "aggregate", "aggregate requires multiple")
+		}
+	}
+
+	resolver := c.Resolver()
+	names := map[string]int{}
+	archiveNames := map[string]int{}
+	for i, m := range c.Metrics {
+		switch {
+		case m.Name == "":
+			v.metric(i, "name", "name is required")
+		case !promNameRegexp.MatchString(m.Name):
+			v.metric(i, "name", "name '%v' is not a valid Prometheus metric name (must match %v)", m.Name, promNameRegexp)
+		case strings.HasPrefix(m.Name, reservedPrefix):
+			v.metric(i, "name", "names starting with '%v' are reserved for DISCO's own metrics e.g., disco_switch_info", reservedPrefix)
+		default:
+			if j, ok := names[m.Name]; ok {
+				v.metric(i, "name", "name is already used by metric #%d", j+1)
+			} else {
+				names[m.Name] = i
+			}
+		}
+
+		v.oid(i, resolver)
+
+		switch m.Type {
+		case "", Counter:
+			if m.Scale < 0 {
+				v.metric(i, "scale", "scale must be positive for counters")
+			}
+			if m.Offset != 0 {
+				v.metric(i, "offset", "offset can only be used with gauges")
+			}
+		case Gauge:
+		default:
+			v.metric(i, "type", "type '%v' must be %v or %v", m.Type, Counter, Gauge)
+		}
+
+		v.archiveNames(i, archiveNames)
+	}
+
+	return v.problems
+}
+
+// oid checks the oidStub and symbolic oid of the i'th Metric.
+func (v *validator) oid(i int, resolver *mib.Resolver) {
+	m := v.c.Metrics[i]
+	if m.Oid != "" {
+		oid, err := resolver.Resolve(m.Oid)
+		switch {
+		case err != nil:
+			v.metric(i, "oid", "%v", err)
+		case m.OidStub != "" && m.OidStub != oid:
+			v.metric(i, "oidStub", "oidStub '%v' does not match oid '%v' (%v)", m.OidStub, m.Oid, oid)
+		}
+		return
+	}
+	switch {
+	case m.OidStub == "":
+		v.metric(i, "oidStub", "oidStub or oid is required")
+	case !oidStubRegexp.MatchString(m.OidStub):
+		v.metric(i, "oidStub", "oidStub '%v' must be a numeric OID with a leading dot, e.g. .1.3.6.1.2.1.31.1.1.1.6", m.OidStub)
+	}
+}
+
+// archiveNames checks the archive names of the i'th Metric, recording each
+// name in seen.
+func (v *validator) archiveNames(i int, seen map[string]int) {
+	m := v.c.Metrics[i]
+	legacy := len(v.c.Interfaces) == 0 && len(m.ArchiveNames) == 0
+
+	selectors := map[string]bool{}
+	found := 0
+	for _, iface := range v.c.InterfaceSelectors() {
+		if selectors[iface.Name] {
+			// Duplicate interface names are reported separately.
+			continue
+		}
+		selectors[iface.Name] = true
+		name := m.ArchiveName(iface.Name)
+		field := "archiveNames"
+		if _, ok := m.ArchiveNames[iface.Name]; !ok && legacyFields[iface.Name] != "" {
+			field = legacyFields[iface.Name]
+		}
+		if name == "" {
+			if legacy {
+				v.metric(i, field, "%v is required", field)
+			}
+			continue
+		}
+		found++
+		if j, ok := seen[name]; ok {
+			v.metric(i, field, "archive name '%v' is already used by metric #%d", name, j+1)
+			continue
+		}
+		seen[name] = i
+	}
+
+	undefined := []string{}
+	for iface := range m.ArchiveNames {
+		if !selectors[iface] {
+			undefined = append(undefined, iface)
+		}
+	}
+	sort.Strings(undefined)
+	for _, iface := range undefined {
+		v.metric(i, "archiveNames", "archiveNames refers to undefined interface '%v'", iface)
+	}
+	// Built-in metrics are simply not collected from configured interfaces
+	// they have no archive names for.
+	builtin := i < len(v.c.positions) && v.c.positions[i].builtin
+	if found == 0 && !legacy && !builtin {
+		v.metric(i, "archiveNames", "archiveNames is required, otherwise the metric is never collected")
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+var invalidYaml = `- name: ifHCInOctets
+  description: Ingress octets.
+  oidStub: 1.3.6.1.2.1.31.1.1.1.6
+  mlabUplinkName: switch.octets.uplink.rx
+  mlabMachineName: switch.octets.local.rx
+- name: ifHCInOctets
+  description: Duplicate.
+  oidStub: .1.3.6.1.2.1.31.1.1.1.6
+  mlabUplinkName: switch.octets.uplink.rx
+- name: if-out-octets
+  oidStub: .1.3.6.1.2.1.31.1.1.1.10
+  mlabUplinkName: switch.octets.uplink.tx
+  mlabMachineName: switch.octets.local.tx
+- name: disco_switch_info
+  oidStub: .1.3.6.1.2.1.1.2
+  mlabUplinkName: switch.info.uplink
+  mlabMachineName: switch.info.local
+`
+
+func TestValidate(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(invalidYaml))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+
+	err = c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 3, Metric: "ifHCInOctets",
+			Message: "oidStub '1.3.6.1.2.1.31.1.1.1.6' must be a numeric OID with a leading dot, e.g. .1.3.6.1.2.1.31.1.1.1.6"},
+		{File: "metrics.yaml", Line: 6, Metric: "ifHCInOctets",
+			Message: "name is already used by metric #1"},
+		{File: "metrics.yaml", Line: 6, Metric: "ifHCInOctets",
+			Message: "mlabMachineName is required"},
+		{File: "metrics.yaml", Line: 9, Metric: "ifHCInOctets",
+			Message: "archive name 'switch.octets.uplink.rx' is already used by metric #1"},
+		{File: "metrics.yaml", Line: 10, Metric: "if-out-octets",
+			Message: "name 'if-out-octets' is not a valid Prometheus metric name (must match ^[a-zA-Z_:][a-zA-Z0-9_:]*$)"},
+		{File: "metrics.yaml", Line: 14, Metric: "disco_switch_info",
+			Message: "names starting with 'disco_' are reserved for DISCO's own metrics e.g., disco_switch_info"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+}
+
+func TestValidateGood(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(goodYaml))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected no error, but got: %v", err)
+	}
+}
+
+func TestValidateEmpty(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(""))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+	if err := c.Validate(); err == nil {
+		t.Error("Expected an error for a config without metrics, but did not get one")
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	tests := []struct {
+		p      Problem
+		expect string
+	}{
+		{Problem{File: "m.yaml", Line: 4, Metric: "x", Message: "bad"}, "m.yaml:4: metric 'x': bad"},
+		{Problem{File: "m.yaml", Message: "bad"}, "m.yaml: bad"},
+		{Problem{Message: "bad"}, "bad"},
+	}
+	for _, tt := range tests {
+		if tt.p.String() != tt.expect {
+			t.Errorf("Expected %q, but got: %q", tt.expect, tt.p.String())
+		}
+	}
+}
+
+var invalidInterfacesYaml = `interfaces:
+  - name: machine
+    ifAlias: "{{.Machine}}"
+  - name: machine
+    ifAliasRegex: "^(uplink"
+  - name: bmc
+metrics:
+  - name: ifHCInOctets
+    description: Ingress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx
+      drac: switch.octets.drac.rx
+  - name: ifHCOutOctets
+    description: Egress octets.
+    oidStub: .1.3.6.1.2.1.31.1.1.1.10
+`
+
+func TestValidateInterfaces(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(invalidInterfacesYaml))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+
+	err = c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 4, Interface: "machine",
+			Message: "name is already used by another interface"},
+		{File: "metrics.yaml", Line: 5, Interface: "machine",
+			Message: "invalid regular expression: error parsing regexp: missing closing ): `^(uplink`"},
+		{File: "metrics.yaml", Line: 6, Interface: "bmc",
+			Message: "at least one of ifAlias, ifAliasRegex, ifDescrRegex, ifName, ifIndex or ifType is required"},
+		{File: "metrics.yaml", Line: 11, Metric: "ifHCInOctets",
+			Message: "archiveNames refers to undefined interface 'drac'"},
+		{File: "metrics.yaml", Line: 14, Metric: "ifHCOutOctets",
+			Message: "archiveNames is required, otherwise the metric is never collected"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+}
+
+var invalidConversionsYaml = `- name: ifHCInBits
+  oidStub: .1.3.6.1.2.1.31.1.1.1.6
+  scale: -8
+  offset: 1
+  mlabUplinkName: switch.bits.uplink.rx
+  mlabMachineName: switch.bits.local.rx
+- name: rxPower
+  oidStub: .1.3.6.1.4.1.2636.3.60.1.1.1.1.5
+  type: meter
+  mlabUplinkName: switch.rxpower.uplink
+  mlabMachineName: switch.rxpower.local
+- name: temperature
+  oidStub: .1.3.6.1.4.1.2636.3.60.1.1.1.1.8
+  type: gauge
+  scale: 0.1
+  offset: -40
+  unit: celsius
+  mlabUplinkName: switch.temperature.uplink
+  mlabMachineName: switch.temperature.local
+`
+
+func TestValidateConversions(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(invalidConversionsYaml))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+
+	err = c.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected a *ValidationError, but got: %v", err)
+	}
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 3, Metric: "ifHCInBits", Message: "scale must be positive for counters"},
+		{File: "metrics.yaml", Line: 4, Metric: "ifHCInBits", Message: "offset can only be used with gauges"},
+		{File: "metrics.yaml", Line: 9, Metric: "rxPower", Message: "type 'meter' must be counter or gauge"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expect) {
+		t.Errorf("Unexpected problems.\nGot:\n%v\nExpected:\n%v", verr.Problems, expect)
+	}
+
+	temperature := c.Metrics[2]
+	if got := temperature.Convert(650); got != 25 {
+		t.Errorf("Expected 650 to convert to 25 celsius, but got: %v", got)
+	}
+	if !temperature.IsGauge() || !temperature.Scaled() {
+		t.Errorf("Expected a scaled gauge, but got: %+v", temperature)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	c, err := parse("metrics.yaml", []byte(`interfaces:
+  - name: machine
+    ifAlias: "{{.Machine}}"
+  - name: bmc
+    ifDescrRegex: "^mgmt"
+  - name: uplink
+    ifAliasRegex: "^uplink"
+metrics:
+  - name: ifHCInOctets
+    oidStub: .1.3.6.1.2.1.31.1.1.1.6
+    archiveNames:
+      machine: switch.octets.local.rx
+profiles:
+  - name: juniper
+    sysObjectID: [.1.3.6.1.4.1.2636]
+    metrics:
+      - name: ifHCOutOctets
+        oidStub: .1.3.6.1.2.1.31.1.1.1.10
+        archiveNames:
+          uplink: switch.octets.uplink.tx
+`))
+	if err != nil {
+		t.Fatalf("parse() returned an error: %v", err)
+	}
+	rtx.Must(c.Validate(), "Expected a valid config")
+
+	expect := []Problem{
+		{File: "metrics.yaml", Line: 4, Interface: "bmc",
+			Message: "no metric has an archive name for this interface, so nothing is collected from it " +
+				"(built-in metrics are only collected from the interfaces in their archiveNames)"},
+	}
+	if got := c.Warnings(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Unexpected warnings.\nGot:\n%v\nExpected:\n%v", got, expect)
+	}
+}
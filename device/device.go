@@ -0,0 +1,104 @@
+// Package device identifies the vendor and model of a switch from the sysDescr
+// and sysObjectID of the SNMPv2-MIB system group.
+package device
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/disco/snmp"
+)
+
+const (
+	sysDescrOid    = ".1.3.6.1.2.1.1.1.0"
+	sysObjectIDOid = ".1.3.6.1.2.1.1.2.0"
+	// enterprisesOid is the parent of the OIDs assigned to each vendor, under
+	// which vendors define the sysObjectID of their products.
+	enterprisesOid = ".1.3.6.1.4.1"
+)
+
+// Info describes a switch.
+type Info struct {
+	SysObjectID string
+	SysDescr    string
+	// Vendor is derived from the enterprise number of the SysObjectID e.g.,
+	// "juniper", and is empty if the vendor is unknown.
+	Vendor string
+	// Model is parsed from the SysDescr of known vendors e.g., "qfx5100-48s-6q",
+	// and is empty if it is unknown.
+	Model string
+}
+
+// vendor is a switch vendor, identified by its IANA enterprise number.
+type vendor struct {
+	name string
+	// model matches the SysDescr of the vendor's switches, with the model as
+	// the first non-empty submatch.
+	model *regexp.Regexp
+}
+
+// vendors maps IANA enterprise numbers to vendors.
+var vendors = map[string]vendor{
+	"9":     {"cisco", regexp.MustCompile(`Cisco NX-OS\(tm\) ([^,\s]+)|Cisco IOS Software, (\S+) Software`)},
+	"11":    {"hpe", nil},
+	"674":   {"dell", nil},
+	"1916":  {"extreme", nil},
+	"2636":  {"juniper", regexp.MustCompile(`^Juniper Networks, Inc\. (\S+)`)},
+	"6027":  {"dell", nil},
+	"30065": {"arista", regexp.MustCompile(`running on an Arista Networks (\S+)`)},
+	"33049": {"mellanox", nil},
+}
+
+// Identify returns the Info of a switch with the given sysObjectID and
+// sysDescr.
+func Identify(sysObjectID, sysDescr string) Info {
+	info := Info{SysObjectID: sysObjectID, SysDescr: sysDescr}
+	if !strings.HasPrefix(sysObjectID, enterprisesOid+".") {
+		return info
+	}
+	number := strings.SplitN(strings.TrimPrefix(sysObjectID, enterprisesOid+"."), ".", 2)[0]
+	v, ok := vendors[number]
+	if !ok {
+		return info
+	}
+	info.Vendor = v.name
+	if v.model == nil {
+		return info
+	}
+	match := v.model.FindStringSubmatch(sysDescr)
+	if match == nil {
+		return info
+	}
+	for _, m := range match[1:] {
+		if m != "" {
+			info.Model = m
+			break
+		}
+	}
+	return info
+}
+
+// Detect gets the sysObjectID and sysDescr of the switch and returns its
+// Info.
+func Detect(client snmp.Client) (Info, error) {
+	result, err := client.Get([]string{sysObjectIDOid, sysDescrOid})
+	if err != nil {
+		return Info{}, err
+	}
+	var sysObjectID, sysDescr string
+	for _, pdu := range result.Variables {
+		switch {
+		case pdu.Name == sysObjectIDOid && pdu.Type == gosnmp.ObjectIdentifier:
+			sysObjectID, _ = pdu.Value.(string)
+		case pdu.Name == sysDescrOid && pdu.Type == gosnmp.OctetString:
+			b, _ := pdu.Value.([]byte)
+			sysDescr = string(b)
+		}
+	}
+	if sysObjectID == "" {
+		return Info{}, fmt.Errorf("switch did not return sysObjectID")
+	}
+	return Identify(sysObjectID, sysDescr), nil
+}
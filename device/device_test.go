@@ -0,0 +1,104 @@
+package device
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name        string
+		sysObjectID string
+		sysDescr    string
+		want        Info
+	}{
+		{
+			name:        "juniper",
+			sysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82",
+			sysDescr:    "Juniper Networks, Inc. qfx5100-48s-6q Ethernet Switch, kernel JUNOS 14.1X53-D46.7",
+			want:        Info{Vendor: "juniper", Model: "qfx5100-48s-6q"},
+		},
+		{
+			name:        "cisco-nxos",
+			sysObjectID: ".1.3.6.1.4.1.9.12.3.1.3.1812",
+			sysDescr:    "Cisco NX-OS(tm) n9000, Software (n9000-dk9), Version 7.0(3)I7(6)",
+			want:        Info{Vendor: "cisco", Model: "n9000"},
+		},
+		{
+			name:        "cisco-ios",
+			sysObjectID: ".1.3.6.1.4.1.9.1.1227",
+			sysDescr:    "Cisco IOS Software, C3750E Software (C3750E-UNIVERSALK9-M), Version 15.0(2)SE",
+			want:        Info{Vendor: "cisco", Model: "C3750E"},
+		},
+		{
+			name:        "arista",
+			sysObjectID: ".1.3.6.1.4.1.30065.1.3011.7050.3741.64",
+			sysDescr:    "Arista Networks EOS version 4.20.1F running on an Arista Networks DCS-7050SX-64",
+			want:        Info{Vendor: "arista", Model: "DCS-7050SX-64"},
+		},
+		{
+			name:        "unknown-model",
+			sysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82",
+			sysDescr:    "Something else",
+			want:        Info{Vendor: "juniper"},
+		},
+		{
+			name:        "unknown-vendor",
+			sysObjectID: ".1.3.6.1.4.1.99999.1",
+			sysDescr:    "Juniper Networks, Inc. qfx5100-48s-6q",
+		},
+		{
+			name:        "not-enterprise",
+			sysObjectID: ".1.3.6.1.2.1.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.SysObjectID, tt.want.SysDescr = tt.sysObjectID, tt.sysDescr
+			if got := Identify(tt.sysObjectID, tt.sysDescr); got != tt.want {
+				t.Errorf("Identify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeClient struct {
+	vars []gosnmp.SnmpPDU
+	err  error
+}
+
+func (c *fakeClient) BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeClient) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	return &gosnmp.SnmpPacket{Variables: c.vars}, c.err
+}
+
+func TestDetect(t *testing.T) {
+	client := &fakeClient{
+		vars: []gosnmp.SnmpPDU{
+			{Name: sysObjectIDOid, Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.2636.1.1.1.2.82"},
+			{Name: sysDescrOid, Type: gosnmp.OctetString, Value: []byte("Juniper Networks, Inc. qfx5100-48s-6q Ethernet Switch")},
+		},
+	}
+	info, err := Detect(client)
+	if err != nil {
+		t.Fatalf("Detect() failed: %v", err)
+	}
+	if info.Vendor != "juniper" || info.Model != "qfx5100-48s-6q" {
+		t.Errorf("Detect() = %+v, expected a juniper qfx5100-48s-6q", info)
+	}
+
+	client.vars[0] = gosnmp.SnmpPDU{Name: sysObjectIDOid, Type: gosnmp.NoSuchObject}
+	if _, err := Detect(client); err == nil {
+		t.Errorf("Detect() expected an error without sysObjectID")
+	}
+
+	client.err = errors.New("timeout")
+	if _, err := Detect(client); err == nil {
+		t.Errorf("Detect() expected an error")
+	}
+}
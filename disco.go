@@ -3,68 +3,365 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/m-lab/disco/archive"
 	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/device"
+	"github.com/m-lab/disco/health"
+	"github.com/m-lab/disco/influx"
+	"github.com/m-lab/disco/logging"
 	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/disco/naming"
+	"github.com/m-lab/disco/otlp"
+	"github.com/m-lab/disco/probe"
+	"github.com/m-lab/disco/remotewrite"
 	"github.com/m-lab/disco/snmp"
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
-	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/slog"
 )
 
 var (
-	fCommunity          = flag.String("community", "", "The SNMP community string for the switch.")
+	fArchiveAccessKey   = flag.String("archive-access-key", "", "HMAC access key for the object store archive sink.")
+	fArchiveBucket      = flag.String("archive-bucket", "", "Bucket to write archives to when -archive-sink=objectstore.")
+	fArchiveEndpoint    = flag.String("archive-endpoint", "https://storage.googleapis.com", "Base URL of the S3-compatible object store.")
+	fArchiveFormats     flagx.StringArray
+	fArchiveManifests   = flag.Bool("archive-manifests", false, "Write a sidecar manifest with the SHA-256 digest, size and sample count of each archive.")
+	fArchiveNameTmpl    = flag.String("archive-name-template", archive.DefaultNameTemplate, "Go text/template for archive names. Fields: .Hostname, .Target, .Start, .End, .Sequence, .Codec.")
+	fArchivePrefix      = flag.String("archive-prefix", "", "Optional path prefix for archives written to the object store.")
+	fArchiveRegion      = flag.String("archive-region", "auto", "Region used to sign object store requests.")
+	fArchiveSecretKey   = flag.String("archive-secret-key-file", "", "Path to a file containing the HMAC secret key for the object store archive sink. Default: the "+archiveSecretKeyEnv+" env variable.")
+	fArchiveSink        = flagx.Enum{Options: []string{"file", "objectstore", "stdout"}, Value: "file"}
+	fArchiveTimezone    = flag.String("archive-timezone", "UTC", "Time zone of the times in archive names e.g., UTC or Local.")
+	fCommunity          = flag.String("community", "", "The SNMP community string for the switch. Prefer -community-file, since flags and env variables may be visible to other users.")
+	fCommunityFile      = flag.String("community-file", "", "Path to a file containing the SNMP community string. Re-read every -credentials-reload-interval.")
+	fCredentialsFile    = flag.String("credentials-file", "", "Path to a YAML file with SNMPv2c or SNMPv3 credentials. Re-read every -credentials-reload-interval.")
+	fCredentialsReload  = flag.Duration("credentials-reload-interval", time.Minute, "Interval to re-read -community-file or -credentials-file, so that rotated secrets take effect.")
 	fDataDir            = flag.String("datadir", "/var/spool/disco", "Base directory where metrics files will be written.")
+	fHealthStall        = flag.Duration("health-stall-timeout", health.DefaultStallTimeout, "/healthz fails if no collection was attempted for this long, since the collection loop is stuck.")
 	fHostname           = flag.String("hostname", "", "The FQDN of the node.")
-	fMetricsFile        = flag.String("metrics", "", "Path to YAML file defining metrics to scrape.")
+	fHostnameRegex      = flag.String("hostname-regex", naming.DefaultHostnameRegex, "Regular expression with named groups which parses -hostname e.g., (?P<site>...).")
+	fInfluxBatch        = flag.Int("influx-batch-size", influx.DefaultBatchSize, "Maximum number of lines in an InfluxDB write.")
+	fInfluxOutput       = flag.String("influx-output", "", "InfluxDB write endpoint to push samples to in the line protocol e.g., http://influxdb:8086/api/v2/write?org=mlab&bucket=disco, or a file to append them to. Default: disabled.")
+	fInfluxToken        = flag.String("influx-token-file", "", "Path to a file containing the InfluxDB API token.")
+	fLogFormat          = flagx.Enum{Options: logging.Formats, Value: logging.Logfmt}
+	fLogLevel           = slog.LevelInfo
+	fMachineTemplate    = flag.String("machine-template", naming.DefaultMachineTemplate, "Go text/template for the machine name. Fields: .Hostname and the named groups of -hostname-regex.")
+	fMetricsFile        = flag.String("metrics", "", "Path to YAML file defining metrics to scrape, merged with the built-in metrics. Default: the built-in metrics.")
+	fOTLPEndpoint       = flag.String("otlp-endpoint", "", "OpenTelemetry collector to push metrics to with OTLP: host:port for -otlp-protocol=grpc, or a URL for http/protobuf. Default: disabled.")
+	fOTLPHeaders        flagx.KeyValue
+	fOTLPInsecure       = flag.Bool("otlp-insecure", false, "Connect to the OTLP/gRPC endpoint without TLS.")
+	fOTLPProtocol       = flagx.Enum{Options: otlp.Protocols, Value: otlp.GRPC}
+	fProbeTargets       = flag.String("probe-target-regex", "", "Regular expression matching the switches which may be scraped through /probe?target=<switch>. Default: /probe is disabled.")
+	fPromLabels         = flag.String("prometheus-labels", metrics.DefaultLabels, "Comma-separated optional labels of the exported metrics: scope, target, machine and site.")
+	fPromLegacyLabels   = flag.Bool("prometheus-legacy-labels", false, "Export metrics with the labels of earlier releases, ignoring -prometheus-labels. Deprecated, will be removed in the next release.")
+	fReadyFailures      = flag.Duration("ready-failure-threshold", health.DefaultFailureThreshold, "/readyz fails if collections have been failing for this long.")
+	fRemoteWriteBatch   = flag.Int("remote-write-batch-size", remotewrite.DefaultBatchSize, "Maximum number of samples in a remote write request.")
+	fRemoteWriteQueue   = flag.String("remote-write-queue-dir", "/var/lib/disco/remote-write", "Directory of the queue of remote write requests waiting to be sent. Must not be under -datadir, whose files are uploaded.")
+	fRemoteWriteSize    = flag.Int64("remote-write-queue-bytes", 256<<20, "Maximum size of the remote write queue. The oldest requests are dropped when it is full.")
+	fRemoteWriteToken   = flag.String("remote-write-bearer-token-file", "", "Path to a file containing the bearer token of remote write requests.")
+	fRemoteWriteURL     = flag.String("remote-write-url", "", "URL of a Prometheus remote write endpoint to push samples to e.g., https://prometheus.example.org/api/v1/write. Default: disabled.")
 	fWriteInterval      = flag.Duration("write-interval", 300*time.Second, "Interval to write out JSON files e.g, 300s, 10m.")
-	fTarget             = flag.String("target", "", "Switch FQDN to scrape metrics from.")
+	fTarget             = flag.String("target", "", "Switch FQDN to scrape metrics from. Derived from -hostname using -target-template if empty.")
+	fTargetTemplate     = flag.String("target-template", naming.DefaultTargetTemplate, "Go text/template for the switch FQDN. Fields: .Hostname and the named groups of -hostname-regex.")
 	mainCtx, mainCancel = context.WithCancel(context.Background())
 )
 
+// archiveSecretKeyEnv is the env variable of the object store secret key, if
+// -archive-secret-key-file is not set.
+const archiveSecretKeyEnv = "ARCHIVE_SECRET_KEY"
+
+func init() {
+	flag.Var(&fArchiveSink, "archive-sink", "Where to write archives: file (under -datadir), objectstore or stdout.")
+	flag.Var(&fArchiveFormats, "archive-format", "Archive format to write: jsonl or parquet. May be repeated. Default: jsonl.")
+	flag.Var(&fOTLPProtocol, "otlp-protocol", "OTLP transport: grpc or http/protobuf.")
+	flag.Var(&fOTLPHeaders, "otlp-header", "Header of OTLP requests as name=value e.g., Authorization=Bearer <token>. May be repeated.")
+	flag.Var(&fLogFormat, "log-format", "Format of the logs: logfmt or json.")
+	flag.TextVar(&fLogLevel, "log-level", fLogLevel, "Minimum level of the logs: DEBUG, INFO, WARN or ERROR.")
+}
+
+// mustNewProbeHandler returns the handler of /probe, which collects the
+// metrics of c from the switches matching -probe-target-regex.
+func mustNewProbeHandler(c config.Config, credentials *snmp.CredentialsSource) http.Handler {
+	h, err := probe.NewHandler(c, *fProbeTargets, func(target string) (snmp.Client, io.Closer, error) {
+		g, err := snmp.Connect(target, credentials.Credentials())
+		if err != nil {
+			return nil, nil, err
+		}
+		return snmp.New(g), g.Conn, nil
+	})
+	rtx.Must(err, "Invalid -probe-target-regex")
+	return h
+}
+
+// exporter is a metrics.Exporter which pushes the exported points until its
+// context is cancelled, and has Prometheus metrics of its own.
+type exporter interface {
+	metrics.Exporter
+	prometheus.Collector
+	Run(ctx context.Context)
+}
+
+// mustGetExporters returns the exporters enabled by the -remote-write-url,
+// -otlp-endpoint and -influx-output flags, for the switch d.
+func mustGetExporters(d archive.Device) []exporter {
+	exporters := []exporter{}
+	if *fRemoteWriteURL != "" {
+		exporters = append(exporters, mustNewRemoteWriter())
+	}
+	if *fOTLPEndpoint != "" {
+		exporters = append(exporters, mustNewOTLPExporter(d))
+	}
+	if *fInfluxOutput != "" {
+		exporters = append(exporters, mustNewInfluxWriter())
+	}
+	return exporters
+}
+
+// mustNewRemoteWriter returns a remotewrite.Writer for the -remote-write-*
+// flags.
+func mustNewRemoteWriter() *remotewrite.Writer {
+	queue, err := remotewrite.NewQueue(*fRemoteWriteQueue, *fRemoteWriteSize)
+	rtx.Must(err, "Failed to open the remote write queue")
+	w := remotewrite.NewWriter(*fRemoteWriteURL, queue)
+	w.BatchSize = *fRemoteWriteBatch
+	w.BearerTokenFile = *fRemoteWriteToken
+	return w
+}
+
+// mustNewOTLPExporter returns an otlp.Exporter for the -otlp-* flags. The
+// resource describes the switch d.
+func mustNewOTLPExporter(d archive.Device) *otlp.Exporter {
+	e, err := otlp.New(fOTLPProtocol.Value, *fOTLPEndpoint, *fOTLPInsecure, fOTLPHeaders.Get(),
+		otlp.Resource(*fHostname, *fTarget, d))
+	rtx.Must(err, "Invalid -otlp-endpoint")
+	return e
+}
+
+// mustNewInfluxWriter returns an influx.Writer for the -influx-* flags, which
+// tags lines with the hostname and target.
+func mustNewInfluxWriter() *influx.Writer {
+	w, err := influx.New(*fInfluxOutput, *fInfluxToken, map[string]string{
+		"hostname": *fHostname,
+		"target":   *fTarget,
+	})
+	rtx.Must(err, "Invalid -influx-output")
+	w.BatchSize = *fInfluxBatch
+	return w
+}
+
+// mustGetLabels returns the metrics.Labels for the -prometheus-labels and
+// -prometheus-legacy-labels flags. The site is a named group of
+// -hostname-regex.
+func mustGetLabels(names naming.Names) metrics.Labels {
+	labelNames, err := metrics.ParseLabelNames(*fPromLabels)
+	rtx.Must(err, "Invalid -prometheus-labels")
+	if *fPromLegacyLabels {
+		slog.Warn("-prometheus-legacy-labels is deprecated and will be removed in the next release")
+	}
+	return metrics.Labels{
+		Names:  labelNames,
+		Site:   names.Groups["site"],
+		Legacy: *fPromLegacyLabels,
+	}
+}
+
+// mustGetNamer returns an archive.Namer for the -archive-name-template and
+// -archive-timezone flags.
+func mustGetNamer() *archive.Namer {
+	location, err := time.LoadLocation(*fArchiveTimezone)
+	rtx.Must(err, "Invalid -archive-timezone")
+	namer, err := archive.NewNamer(*fArchiveNameTmpl, location)
+	rtx.Must(err, "Invalid -archive-name-template")
+	return namer
+}
+
+// mustGetFormats returns the archive formats selected by the -archive-format flag.
+func mustGetFormats() []archive.Format {
+	if len(fArchiveFormats) == 0 {
+		return []archive.Format{archive.JSONL}
+	}
+	formats := []archive.Format{}
+	for _, f := range fArchiveFormats {
+		format, err := archive.ParseFormat(f)
+		rtx.Must(err, "Invalid -archive-format")
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// newSink returns the archive.Sink selected by the -archive-sink flag.
+func newSink() archive.Sink {
+	switch fArchiveSink.Value {
+	case "objectstore":
+		if len(*fArchiveBucket) <= 0 {
+			logging.Fatal(slog.Default(), "-archive-bucket must be set when -archive-sink=objectstore")
+		}
+		return archive.NewObjectStoreSink(*fArchiveEndpoint, *fArchiveBucket, *fArchivePrefix,
+			*fArchiveRegion, *fArchiveAccessKey, mustGetArchiveSecretKey())
+	case "stdout":
+		return archive.NewStdoutSink()
+	default:
+		return archive.NewFileSink(*fDataDir)
+	}
+}
+
+// mustGetArchiveSecretKey returns the object store secret key read from the
+// -archive-secret-key-file, or else the ARCHIVE_SECRET_KEY env variable. It is
+// never passed on the command line, where it would be visible to other users.
+func mustGetArchiveSecretKey() string {
+	if *fArchiveSecretKey == "" {
+		return strings.TrimSpace(os.Getenv(archiveSecretKeyEnv))
+	}
+	b, err := ioutil.ReadFile(*fArchiveSecretKey)
+	rtx.Must(err, "Failed to read -archive-secret-key-file")
+	return strings.TrimSpace(string(b))
+}
+
+// mustGetCredentials returns a CredentialsSource for the -credentials-file,
+// -community-file or -community flag, in that order of preference.
+func mustGetCredentials() *snmp.CredentialsSource {
+	var load func() (snmp.Credentials, error)
+	switch {
+	case *fCredentialsFile != "":
+		load = func() (snmp.Credentials, error) { return snmp.ReadCredentialsFile(*fCredentialsFile) }
+	case *fCommunityFile != "":
+		load = func() (snmp.Credentials, error) { return snmp.ReadCommunityFile(*fCommunityFile) }
+	case len(*fCommunity) > 0:
+		c := snmp.Credentials{Version: "2c", Community: strings.TrimSpace(*fCommunity)}
+		load = func() (snmp.Credentials, error) { return c, nil }
+	default:
+		logging.Fatal(slog.Default(), "SNMP credentials must be passed using -credentials-file, -community-file or -community (or their env variables)")
+	}
+	source, err := snmp.NewCredentialsSource(load)
+	rtx.Must(err, "Failed to load SNMP credentials")
+	return source
+}
+
+// mustSetupLogging makes the logger of the -log-format and -log-level flags
+// the default logger.
+func mustSetupLogging() {
+	logger, err := logging.New(os.Stderr, fLogFormat.Value, fLogLevel)
+	rtx.Must(err, "Invalid -log-format")
+	logging.SetDefault(logger)
+}
+
+// commands are the subcommands that may be given as the first argument to
+// disco. Without a command, disco collects metrics from a switch.
+var commands = map[string]func(args []string) error{
+	"export":          runExport,
+	"print-config":    runPrintConfig,
+	"validate-config": runValidateConfig,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil && err != flag.ErrHelp {
+				logging.Fatal(slog.Default(), "command failed", "command", os.Args[1], logging.Err(err))
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not parse env args")
+	mustSetupLogging()
 
-	if len(*fCommunity) <= 0 {
-		log.Fatal("SNMP community string must be passed as arg or env variable.")
-	}
+	credentials := mustGetCredentials()
 
 	if len(*fHostname) <= 0 {
-		log.Fatal("Node's FQDN must be passed as an arg or env variable.")
+		logging.Fatal(slog.Default(), "Node's FQDN must be passed as an arg or env variable")
 	}
 
-	// If the -target flag is empty, then attempt to construct it using the hostname.
+	deriver, err := naming.NewDeriver(*fHostnameRegex, *fMachineTemplate, *fTargetTemplate)
+	rtx.Must(err, "Invalid -hostname-regex, -machine-template or -target-template")
+	names, err := deriver.Derive(*fHostname)
+	rtx.Must(err, "Failed to derive the machine name and target from -hostname")
+
+	// If the -target flag is empty, then use the one derived from the hostname.
 	if len(*fTarget) <= 0 {
-		h := *fHostname
-		*fTarget = fmt.Sprintf("s1-%s.measurement-lab.org", h[6:11])
+		*fTarget = names.Target
 	}
 
-	goSNMP := &gosnmp.GoSNMP{
-		Target:    *fTarget,
-		Port:      uint16(161),
-		Community: strings.TrimSpace(*fCommunity),
-		Version:   gosnmp.Version2c,
-		Timeout:   time.Duration(5) * time.Second,
-		Retries:   1,
-	}
-	err := goSNMP.Connect()
+	// logger adds the target and hostname to the records of main, like the
+	// Metrics do to theirs.
+	logger := slog.Default().With(logging.TargetKey, *fTarget, logging.HostnameKey, *fHostname)
+	logger.Info("using SNMP credentials", "credentials", credentials.Credentials().String())
+	goSNMP, err := snmp.Connect(*fTarget, credentials.Credentials())
 	rtx.Must(err, "Failed to connect to the SNMP server")
 
 	config, err := config.New(*fMetricsFile)
 	rtx.Must(err, "Could not create new metrics configuration")
+	handlers := map[string]http.Handler{}
+	if *fProbeTargets != "" {
+		handlers["/probe"] = mustNewProbeHandler(config, credentials)
+	}
 	client := snmp.New(goSNMP)
-	metrics := metrics.New(client, config, *fTarget, *fHostname)
 
-	promSrv := prometheusx.MustServeMetrics()
+	// Switches which can't be identified use the generic metrics.
+	info, err := device.Detect(client)
+	if err != nil {
+		logger.Error("failed to detect the switch model", logging.Err(err))
+	}
+	config, profile, err := config.ForDevice(info.SysObjectID, info.SysDescr)
+	rtx.Must(err, "Could not select metrics configuration profile")
+	logger.Info("detected switch", "vendor", info.Vendor, "model", info.Model,
+		"sysObjectID", info.SysObjectID, "profile", profile)
+	config, err = config.ForTarget(*fTarget, *fHostname)
+	rtx.Must(err, "Invalid metrics configuration for the switch, with its profile and overrides applied")
+	sink := newSink()
+	metrics := metrics.New(client, config, *fTarget, *fHostname, names.Machine, mustGetLabels(names))
+	dev := archive.Device{
+		Vendor:      info.Vendor,
+		Model:       info.Model,
+		SysObjectID: info.SysObjectID,
+		SysDescr:    info.SysDescr,
+		Profile:     profile,
+	}
+	metrics.SetDevice(dev)
+
+	tracker := health.New(prometheusx.GitShortCommit, *fTarget, config.Hash())
+	tracker.StallTimeout = *fHealthStall
+	tracker.FailureThreshold = *fReadyFailures
+	tracker.Discovered(dev, metrics.Interfaces())
+	for path, h := range tracker.Handlers() {
+		handlers[path] = h
+	}
+	handlers["/api/v1/state"] = metrics.StateHandler()
+	metrics.Formats = mustGetFormats()
+	metrics.Namer = mustGetNamer()
+	metrics.Manifests = *fArchiveManifests
+	metrics.SpeedInterval = *fWriteInterval
+
+	// The handler serves the default registry, which also has the Go runtime
+	// and process metrics.
+	prometheus.MustRegister(metrics.Collector())
+	promSrv := mustServeHTTP(handlers)
+
+	// background are the goroutines which must finish before exiting.
+	var background sync.WaitGroup
+	for _, e := range mustGetExporters(dev) {
+		metrics.Exporters = append(metrics.Exporters, e)
+		prometheus.MustRegister(e)
+		background.Add(1)
+		go func(e exporter) {
+			defer background.Done()
+			e.Run(mainCtx)
+		}(e)
+	}
 
 	go func() {
 		<-mainCtx.Done()
@@ -84,31 +381,43 @@ func main() {
 
 	collectTicker := time.NewTicker(10 * time.Second)
 	defer collectTicker.Stop()
+
+	credentialsTicker := time.NewTicker(*fCredentialsReload)
+	defer credentialsTicker.Stop()
 	// Tickers wait for the configured duration before their first tick. We want
 	// Collect() to run immedately, so manually kick off Collect() once
 	// immediately after the ticker is created.
-	metrics.Collect(client, config)
+	tracker.Collected(metrics.Collect(client, config))
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGTERM)
 
-	for {
-		select {
-		case <-mainCtx.Done():
-			return
-		case <-writeTicker.C:
-			metrics.Write(*fDataDir)
-		case <-collectTicker.C:
+	l := &loop{
+		collect: func() {
 			// NOTE: The value of CollectStart is used as the sample Timestamp
 			// for all metrics from a given collection. The current code relies
 			// this timestamp always being the same, if this changes, then the
 			// code in metrics.Collect() will need to be modified.
 			metrics.CollectStart = time.Now()
-			metrics.Collect(client, config)
-		case <-sigterm:
-			metrics.Write(*fDataDir)
-			mainCancel()
-			return
-		}
+			tracker.Collected(metrics.Collect(client, config))
+		},
+		write: func() { tracker.Wrote(metrics.Write(sink)) },
+		reload: func() {
+			changed, err := credentials.Reload()
+			if err != nil {
+				logger.Error("failed to reload SNMP credentials, keeping the current ones", logging.Err(err))
+				return
+			}
+			if changed {
+				rtx.Must(credentials.Credentials().Apply(goSNMP), "Invalid SNMP credentials")
+				logger.Info("reloaded SNMP credentials", "credentials", credentials.Credentials().String())
+			}
+		},
+		collectTicks: collectTicker.C,
+		writeTicks:   writeTicker.C,
+		reloadTicks:  credentialsTicker.C,
 	}
+	l.run(mainCtx, sigterm)
+	mainCancel()
+	background.Wait()
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/disco/export"
+	"github.com/m-lab/go/flagx"
+)
+
+// runExport implements the "export" command, which writes the samples found in
+// archive files as CSV or TSV.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "/var/spool/disco", "Base directory containing JSONL archives.")
+	hostname := fs.String("hostname", "", "Only export archives for this node FQDN.")
+	output := fs.String("output", "", "File to write to. Default: stdout.")
+	rates := fs.Bool("rates", false, "Add interval_seconds and rate_per_second columns.")
+	format := flagx.Enum{Options: []string{"csv", "tsv"}, Value: "csv"}
+	var start, end flagx.DateTime
+	fs.Var(&format, "format", "Output format: csv or tsv.")
+	fs.Var(&start, "start", "Only export samples at or after this time (UTC unless specified).")
+	fs.Var(&end, "end", "Only export samples at or before this time (UTC unless specified).")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := export.Options{
+		Comma:    ',',
+		Hostname: *hostname,
+		Start:    start.Time,
+		End:      end.Time,
+		Rates:    *rates,
+	}
+	if format.Value == "tsv" {
+		opts.Comma = '\t'
+	}
+
+	models, err := export.ReadDir(*dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to read archives: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return export.Write(w, models, opts)
+}
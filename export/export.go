@@ -0,0 +1,181 @@
+// Package export converts DISCO archives into tabular formats, such as CSV,
+// which can be loaded into spreadsheets.
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+)
+
+// Options controls which samples are exported and how.
+type Options struct {
+	// Comma is the field delimiter e.g., ',' for CSV or '\t' for TSV.
+	Comma rune
+	// Hostname, if not empty, limits the export to archives for that host.
+	Hostname string
+	// Start and End, if not zero, limit the export to samples with a
+	// Timestamp in the range [Start, End].
+	Start time.Time
+	End   time.Time
+	// Rates adds columns with the per-second rate of each sample and the
+	// interval over which it was computed.
+	Rates bool
+}
+
+// row is a single exported sample.
+type row struct {
+	timestamp time.Time
+	hostname  string
+	metric    string
+	sample    archive.Sample
+	// interval is the number of seconds since the previous sample of the
+	// same host and metric, or zero if there is no previous sample.
+	interval float64
+}
+
+// ReadDir reads all JSONL archives found under dataDir.
+func ReadDir(dataDir string) ([]archive.Model, error) {
+	models := []archive.Model{}
+	err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, "."+string(archive.JSONL)) {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m, err := archive.ReadJSONL(f)
+		if err != nil {
+			return fmt.Errorf("failed to read archive '%v': %v", path, err)
+		}
+		models = append(models, m...)
+		return nil
+	})
+	return models, err
+}
+
+// Write writes one row per (timestamp, hostname, metric) sample in models to w.
+// Rows are sorted by timestamp, then by hostname and metric name.
+func Write(w io.Writer, models []archive.Model, opts Options) error {
+	rows := getRows(models, opts)
+
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+
+	header := []string{"timestamp", "hostname", "metric", "value", "counter"}
+	if opts.Rates {
+		header = append(header, "interval_seconds", "rate_per_second")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		record := []string{
+			r.timestamp.UTC().Format(time.RFC3339),
+			r.hostname,
+			r.metric,
+			strconv.FormatUint(r.sample.Value, 10),
+			strconv.FormatUint(r.sample.Counter, 10),
+		}
+		if r.sample.Gauge != nil {
+			// Gauges have a value, but no counter or rate.
+			record[3], record[4] = strconv.FormatInt(*r.sample.Gauge, 10), ""
+		}
+		if opts.Rates {
+			if r.interval > 0 && r.sample.Gauge == nil {
+				record = append(record,
+					strconv.FormatFloat(r.interval, 'f', 3, 64),
+					strconv.FormatFloat(float64(r.sample.Value)/r.interval, 'f', 3, 64),
+				)
+			} else {
+				record = append(record, "", "")
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// series identifies the samples of a metric of a host.
+type series struct {
+	hostname string
+	metric   string
+}
+
+// getRows merges the samples of all models for the same host and metric,
+// computes the interval between consecutive samples and then filters them by
+// opts.
+func getRows(models []archive.Model, opts Options) []row {
+	samples := map[series][]archive.Sample{}
+	for _, m := range models {
+		if opts.Hostname != "" && m.Hostname != opts.Hostname {
+			continue
+		}
+		key := series{hostname: m.Hostname, metric: m.Metric}
+		samples[key] = append(samples[key], m.Samples...)
+	}
+
+	rows := []row{}
+	for key, s := range samples {
+		sort.Slice(s, func(i, j int) bool {
+			return s[i].CollectStart < s[j].CollectStart
+		})
+		for i, sample := range s {
+			r := row{
+				timestamp: time.Unix(sample.Timestamp, 0),
+				hostname:  key.hostname,
+				metric:    key.metric,
+				sample:    sample,
+			}
+			// The value of a sample is the increase since the previous
+			// collection, so the rate is computed over the interval
+			// between the midpoints of the two collections.
+			if i > 0 {
+				r.interval = float64(midpoint(sample)-midpoint(s[i-1])) / float64(time.Second)
+			}
+			if !opts.Start.IsZero() && r.timestamp.Before(opts.Start) {
+				continue
+			}
+			if !opts.End.IsZero() && r.timestamp.After(opts.End) {
+				continue
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		switch {
+		case !rows[i].timestamp.Equal(rows[j].timestamp):
+			return rows[i].timestamp.Before(rows[j].timestamp)
+		case rows[i].hostname != rows[j].hostname:
+			return rows[i].hostname < rows[j].hostname
+		}
+		return rows[i].metric < rows[j].metric
+	})
+	return rows
+}
+
+// midpoint returns the time, in nanoseconds, halfway through a collection.
+func midpoint(s archive.Sample) int64 {
+	return s.CollectStart + (s.CollectEnd-s.CollectStart)/2
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/go/rtx"
+)
+
+var testModels = []archive.Model{
+	{
+		Experiment: "s1-abc0t.measurement-lab.org",
+		Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+		Metric:     "switch.octets.uplink.rx",
+		Samples: []archive.Sample{
+			{
+				Timestamp:    1591845350,
+				CollectStart: 1591845350000000000,
+				CollectEnd:   1591845350200000000,
+				Value:        1000,
+				Counter:      5000,
+			},
+			{
+				Timestamp:    1591845360,
+				CollectStart: 1591845360000000000,
+				CollectEnd:   1591845360200000000,
+				Value:        2000,
+				Counter:      7000,
+			},
+		},
+	},
+	{
+		Experiment: "s1-abc0t.measurement-lab.org",
+		Hostname:   "mlab3-abc0t.mlab-sandbox.measurement-lab.org",
+		Metric:     "switch.octets.uplink.rx",
+		Samples: []archive.Sample{
+			{
+				Timestamp:    1591845350,
+				CollectStart: 1591845350000000000,
+				CollectEnd:   1591845350200000000,
+				Value:        3,
+				Counter:      3,
+			},
+		},
+	},
+}
+
+func Test_Write(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		expect string
+	}{
+		{
+			name: "csv",
+			opts: Options{
+				Hostname: "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+			},
+			expect: "timestamp,hostname,metric,value,counter\n" +
+				"2020-06-11T03:15:50Z,mlab2-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,1000,5000\n" +
+				"2020-06-11T03:16:00Z,mlab2-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,2000,7000\n",
+		},
+		{
+			name: "tsv-with-rates",
+			opts: Options{
+				Comma:    '\t',
+				Hostname: "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+				Rates:    true,
+			},
+			expect: "timestamp\thostname\tmetric\tvalue\tcounter\tinterval_seconds\trate_per_second\n" +
+				"2020-06-11T03:15:50Z\tmlab2-abc0t.mlab-sandbox.measurement-lab.org\tswitch.octets.uplink.rx\t1000\t5000\t\t\n" +
+				"2020-06-11T03:16:00Z\tmlab2-abc0t.mlab-sandbox.measurement-lab.org\tswitch.octets.uplink.rx\t2000\t7000\t10.000\t200.000\n",
+		},
+		{
+			name: "time-range",
+			opts: Options{
+				Hostname: "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
+				Start:    time.Unix(1591845355, 0),
+				Rates:    true,
+			},
+			expect: "timestamp,hostname,metric,value,counter,interval_seconds,rate_per_second\n" +
+				"2020-06-11T03:16:00Z,mlab2-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,2000,7000,10.000,200.000\n",
+		},
+		{
+			// The samples of each host are separate series, even for the
+			// same metric.
+			name: "two-hosts",
+			opts: Options{
+				Rates: true,
+			},
+			expect: "timestamp,hostname,metric,value,counter,interval_seconds,rate_per_second\n" +
+				"2020-06-11T03:15:50Z,mlab2-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,1000,5000,,\n" +
+				"2020-06-11T03:15:50Z,mlab3-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,3,3,,\n" +
+				"2020-06-11T03:16:00Z,mlab2-abc0t.mlab-sandbox.measurement-lab.org,switch.octets.uplink.rx,2000,7000,10.000,200.000\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			rtx.Must(Write(buf, testModels, tt.opts), "Failed to export")
+			if buf.String() != tt.expect {
+				t.Errorf("Expected:\n%v\nGot:\n%v", tt.expect, buf.String())
+			}
+		})
+	}
+}
+
+func Test_ReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestReadDir")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	sink := archive.NewFileSink(dir)
+	rtx.Must(sink.Write("switch/a.jsonl", archive.MarshalJSONL(testModels[:1])), "Failed to write archive")
+	rtx.Must(sink.Write("switch/b.jsonl", archive.MarshalJSONL(testModels[1:])), "Failed to write archive")
+	rtx.Must(sink.Write("switch/c.parquet", []byte("ignored")), "Failed to write archive")
+
+	models, err := ReadDir(dir)
+	rtx.Must(err, "Failed to read archives")
+	if len(models) != 2 {
+		t.Errorf("Expected 2 models, but got: %v", len(models))
+	}
+}
@@ -0,0 +1,232 @@
+// Package health tracks the state of DISCO's collection loop, and serves it
+// on /healthz and /readyz for Kubernetes liveness and readiness probes, and as
+// JSON on /status.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/metrics"
+)
+
+const (
+	// DefaultStallTimeout is the default time without collection attempts
+	// after which DISCO is not healthy, six collection intervals. Archive
+	// writes run outside the collection loop, so slow uploads, which may take
+	// longer, don't count.
+	DefaultStallTimeout = time.Minute
+	// DefaultFailureThreshold is the default time for which collections may
+	// fail before DISCO is not ready.
+	DefaultFailureThreshold = 5 * time.Minute
+)
+
+// Discovery is the switch and the interfaces discovered at startup.
+type Discovery struct {
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	SysObjectID string `json:"sysObjectID,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	// Interfaces is the number of interfaces selected by each
+	// config.Interface, by name.
+	Interfaces map[string]int `json:"interfaces"`
+}
+
+// Status is the state of DISCO served on /status. Times are omitted until
+// the event first happens.
+type Status struct {
+	Version    string     `json:"version"`
+	ConfigHash string     `json:"configHash"`
+	Target     string     `json:"target"`
+	Started    time.Time  `json:"started"`
+	Discovery  *Discovery `json:"discovery,omitempty"`
+	// LastCollect is the end of the last successful collection, and
+	// LastCollectAttempt of the last collection.
+	LastCollect        *time.Time `json:"lastCollect,omitempty"`
+	LastCollectAttempt *time.Time `json:"lastCollectAttempt,omitempty"`
+	LastCollectError   string     `json:"lastCollectError,omitempty"`
+	// FailingSince is the first of the consecutive failed collections, if
+	// the last collection failed.
+	FailingSince        *time.Time `json:"failingSince,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastWrite           *time.Time `json:"lastWrite,omitempty"`
+	LastWriteError      string     `json:"lastWriteError,omitempty"`
+	// SNMPReachable is true if the last collection got a response from the
+	// switch's SNMP agent.
+	SNMPReachable bool `json:"snmpReachable"`
+	// Healthy and Ready are the results of /healthz and /readyz, and
+	// Problems explain why they fail.
+	Healthy  bool     `json:"healthy"`
+	Ready    bool     `json:"ready"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Tracker records the collections and archive writes of DISCO. It is safe
+// for concurrent use.
+type Tracker struct {
+	// StallTimeout is the time without collection attempts after which
+	// DISCO is not healthy, since its collection loop is stuck.
+	StallTimeout time.Duration
+	// FailureThreshold is the time for which collections may fail before
+	// DISCO is not ready.
+	FailureThreshold time.Duration
+
+	now   func() time.Time
+	mutex sync.Mutex
+	// status holds the recorded fields of the Status.
+	status             Status
+	lastCollect        time.Time
+	lastCollectAttempt time.Time
+	failingSince       time.Time
+	lastWrite          time.Time
+}
+
+// New returns a Tracker for DISCO at version, collecting metrics from target
+// with the configuration whose config.Hash() is configHash.
+func New(version, target, configHash string) *Tracker {
+	t := &Tracker{
+		StallTimeout:     DefaultStallTimeout,
+		FailureThreshold: DefaultFailureThreshold,
+		now:              time.Now,
+	}
+	t.status = Status{
+		Version:    version,
+		ConfigHash: configHash,
+		Target:     target,
+		Started:    t.now(),
+	}
+	return t
+}
+
+// Discovered records the switch d and its interfaces selected at startup.
+func (t *Tracker) Discovered(d archive.Device, interfaces []metrics.Interface) {
+	counts := map[string]int{}
+	for _, i := range interfaces {
+		counts[i.Scope]++
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.status.Discovery = &Discovery{
+		Vendor:      d.Vendor,
+		Model:       d.Model,
+		SysObjectID: d.SysObjectID,
+		Profile:     d.Profile,
+		Interfaces:  counts,
+	}
+}
+
+// Collected records the end of a collection, which failed if err is not nil.
+// Collections only fail if the switch's SNMP agent doesn't respond
+// correctly.
+func (t *Tracker) Collected(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.lastCollectAttempt = now
+	t.status.SNMPReachable = err == nil
+	if err == nil {
+		t.lastCollect = now
+		t.failingSince = time.Time{}
+		t.status.LastCollectError = ""
+		t.status.ConsecutiveFailures = 0
+		return
+	}
+	if t.failingSince.IsZero() {
+		t.failingSince = now
+	}
+	t.status.LastCollectError = err.Error()
+	t.status.ConsecutiveFailures++
+}
+
+// Wrote records an archive write, which failed if err is not nil.
+func (t *Tracker) Wrote(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err != nil {
+		t.status.LastWriteError = err.Error()
+		return
+	}
+	t.lastWrite = t.now()
+	t.status.LastWriteError = ""
+}
+
+// Status returns the current Status.
+func (t *Tracker) Status() Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	s := t.status
+	s.LastCollect = timeOrNil(t.lastCollect)
+	s.LastCollectAttempt = timeOrNil(t.lastCollectAttempt)
+	s.FailingSince = timeOrNil(t.failingSince)
+	s.LastWrite = timeOrNil(t.lastWrite)
+
+	// Before the first collection, the collection loop is measured from the
+	// start.
+	lastAttempt := t.lastCollectAttempt
+	if lastAttempt.IsZero() {
+		lastAttempt = s.Started
+	}
+	s.Healthy, s.Ready, s.Problems = true, true, nil
+	if stalled := now.Sub(lastAttempt); stalled > t.StallTimeout {
+		s.Healthy, s.Ready = false, false
+		s.Problems = append(s.Problems, fmt.Sprintf("no collection attempted for %v", stalled.Round(time.Second)))
+	}
+	if !t.failingSince.IsZero() {
+		if failing := now.Sub(t.failingSince); failing > t.FailureThreshold {
+			s.Ready = false
+			s.Problems = append(s.Problems, fmt.Sprintf("collections failing for %v: %v",
+				failing.Round(time.Second), s.LastCollectError))
+		}
+	}
+	return s
+}
+
+// timeOrNil returns a pointer to t, or nil if t is zero.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Handlers returns the handlers of /healthz, /readyz and /status, keyed by
+// path. /healthz fails if no collection was attempted for StallTimeout, and
+// /readyz also fails if collections have been failing for FailureThreshold.
+func (t *Tracker) Handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/healthz": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := t.Status()
+			writeProbe(w, s.Healthy, s.Problems)
+		}),
+		"/readyz": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := t.Status()
+			writeProbe(w, s.Ready, s.Problems)
+		}),
+		"/status": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(t.Status())
+		}),
+	}
+}
+
+// writeProbe writes the result of a probe, with the problems if it failed.
+func writeProbe(w http.ResponseWriter, ok bool, problems []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
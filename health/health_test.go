@@ -0,0 +1,172 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/go/rtx"
+)
+
+// clock is a fake time.Now.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTracker() (*Tracker, *clock) {
+	c := &clock{t: time.Date(2020, 6, 11, 0, 0, 0, 0, time.UTC)}
+	t := New("v1.2.3", "s1-abc0t.measurement-lab.org", "abc123")
+	t.now = c.now
+	t.status.Started = c.t
+	return t, c
+}
+
+// get returns the status code of path.
+func get(t *testing.T, tracker *Tracker, path string) int {
+	rec := httptest.NewRecorder()
+	tracker.Handlers()[path].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestTracker(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  func(t *Tracker, c *clock)
+		healthy bool
+		ready   bool
+	}{
+		{
+			name:    "starting",
+			events:  func(t *Tracker, c *clock) { c.t = c.t.Add(30 * time.Second) },
+			healthy: true,
+			ready:   true,
+		},
+		{
+			name:   "never-collected",
+			events: func(t *Tracker, c *clock) { c.t = c.t.Add(2 * time.Minute) },
+		},
+		{
+			name: "collecting",
+			events: func(t *Tracker, c *clock) {
+				for i := 0; i < 30; i++ {
+					c.t = c.t.Add(10 * time.Second)
+					t.Collected(nil)
+				}
+			},
+			healthy: true,
+			ready:   true,
+		},
+		{
+			name: "stuck",
+			events: func(t *Tracker, c *clock) {
+				t.Collected(nil)
+				c.t = c.t.Add(61 * time.Second)
+			},
+		},
+		{
+			name: "failing-briefly",
+			events: func(t *Tracker, c *clock) {
+				t.Collected(nil)
+				for i := 0; i < 6; i++ {
+					c.t = c.t.Add(10 * time.Second)
+					t.Collected(errors.New("request timeout"))
+				}
+			},
+			healthy: true,
+			ready:   true,
+		},
+		{
+			name: "failing",
+			events: func(t *Tracker, c *clock) {
+				for i := 0; i < 32; i++ {
+					c.t = c.t.Add(10 * time.Second)
+					t.Collected(errors.New("request timeout"))
+				}
+			},
+			healthy: true,
+		},
+		{
+			name: "recovered",
+			events: func(t *Tracker, c *clock) {
+				for i := 0; i < 32; i++ {
+					c.t = c.t.Add(10 * time.Second)
+					t.Collected(errors.New("request timeout"))
+				}
+				t.Collected(nil)
+			},
+			healthy: true,
+			ready:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, c := newTracker()
+			tt.events(tracker, c)
+			s := tracker.Status()
+			if s.Healthy != tt.healthy || s.Ready != tt.ready {
+				t.Errorf("Expected healthy %v and ready %v, but got: %+v", tt.healthy, tt.ready, s)
+			}
+			if (len(s.Problems) == 0) != (tt.healthy && tt.ready) {
+				t.Errorf("Expected problems only if not healthy or ready, but got: %v", s.Problems)
+			}
+			for path, ok := range map[string]bool{"/healthz": tt.healthy, "/readyz": tt.ready} {
+				want := http.StatusOK
+				if !ok {
+					want = http.StatusServiceUnavailable
+				}
+				if got := get(t, tracker, path); got != want {
+					t.Errorf("Expected %v to return %d, but got: %d", path, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tracker, c := newTracker()
+	tracker.Discovered(archive.Device{Vendor: "juniper", Model: "qfx5100", Profile: "juniper"}, []metrics.Interface{
+		{Scope: "machine", IfIndex: "502", IfAlias: "mlab1", IfDescr: "xe-0/0/11"},
+		{Scope: "uplink", IfIndex: "568", IfAlias: "uplink-1", IfDescr: "xe-0/0/45"},
+		{Scope: "uplink", IfIndex: "569", IfAlias: "uplink-2", IfDescr: "xe-0/0/46"},
+	})
+	c.t = c.t.Add(10 * time.Second)
+	tracker.Collected(nil)
+	collected := c.t
+	c.t = c.t.Add(10 * time.Second)
+	tracker.Collected(errors.New("request timeout"))
+	tracker.Wrote(nil)
+
+	rec := httptest.NewRecorder()
+	tracker.Handlers()["/status"].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var s Status
+	rtx.Must(json.Unmarshal(rec.Body.Bytes(), &s), "Failed to decode /status")
+
+	if s.Version != "v1.2.3" || s.ConfigHash != "abc123" || s.Target != "s1-abc0t.measurement-lab.org" {
+		t.Errorf("Unexpected version, config hash or target: %+v", s)
+	}
+	if s.Discovery == nil || s.Discovery.Model != "qfx5100" || s.Discovery.Interfaces["uplink"] != 2 {
+		t.Errorf("Unexpected discovery: %+v", s.Discovery)
+	}
+	if s.LastCollect == nil || !s.LastCollect.Equal(collected) || s.LastCollectAttempt == nil ||
+		!s.LastCollectAttempt.Equal(c.t) || s.FailingSince == nil || !s.FailingSince.Equal(c.t) {
+		t.Errorf("Unexpected collection times: %+v", s)
+	}
+	if s.SNMPReachable || s.LastCollectError != "request timeout" || s.ConsecutiveFailures != 1 {
+		t.Errorf("Expected the SNMP agent to be unreachable, but got: %+v", s)
+	}
+	if s.LastWrite == nil || !s.LastWrite.Equal(c.t) || s.LastWriteError != "" {
+		t.Errorf("Unexpected write: %+v", s)
+	}
+	if !s.Healthy || !s.Ready {
+		t.Errorf("Expected DISCO to be healthy and ready, but got: %+v", s)
+	}
+}
@@ -0,0 +1,241 @@
+// Package influx writes the points collected by DISCO in the InfluxDB line
+// protocol, to a file or to the write endpoint of an InfluxDB server.
+//
+// Each point is a line whose measurement is the metric name, whose tags are
+// the hostname, target, scope, ifAlias and interface, and whose fields are
+// the archived sample: the raw value (the increase of a counter, or the value
+// of a gauge), the raw counter, and the scaled value if the metric is scaled.
+// The timestamp is the start of the collection, in nanoseconds.
+package influx
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// DefaultBatchSize is the default maximum number of lines in a batch.
+	DefaultBatchSize = 5000
+	// DefaultFlushInterval is the default maximum time before incomplete
+	// batches are written.
+	DefaultFlushInterval = 10 * time.Second
+	// queueSize is the number of full batches which may wait to be written.
+	queueSize = 100
+)
+
+// errorLog limits the logging of failed writes, which may fail for every
+// batch while the endpoint is down.
+var errorLog = logging.NewLimiter(time.Minute)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// Line returns p in the line protocol, with the given tags in addition to the
+// scope, ifAlias and interface of the point. Tags with empty values are
+// omitted, since InfluxDB rejects them.
+func Line(p metrics.Point, tags map[string]string) string {
+	all := map[string]string{
+		"scope":     p.Scope,
+		"ifAlias":   p.IfAlias,
+		"interface": p.IfDescr,
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+	keys := make([]string, 0, len(all))
+	for k, v := range all {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Name))
+	for _, k := range keys {
+		b.WriteString("," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(all[k]))
+	}
+
+	// Fields are signed integers, since InfluxDB 1.x doesn't support
+	// unsigned ones by default.
+	s := p.Sample
+	if s.Gauge != nil {
+		b.WriteString(" value=" + strconv.FormatInt(*s.Gauge, 10) + "i")
+	} else {
+		b.WriteString(" value=" + strconv.FormatInt(int64(s.Value), 10) + "i")
+		b.WriteString(",counter=" + strconv.FormatInt(int64(s.Counter), 10) + "i")
+	}
+	if s.Scaled != nil {
+		b.WriteString(",scaled=" + strconv.FormatFloat(*s.Scaled, 'g', -1, 64))
+	}
+	b.WriteString(" " + strconv.FormatInt(s.CollectStart, 10) + "\n")
+	return b.String()
+}
+
+// Writer is a metrics.Exporter which writes points in the line protocol to an
+// output, in batches. Batches are written by Run().
+type Writer struct {
+	output output
+	tags   map[string]string
+
+	// BatchSize is the maximum number of lines in a batch.
+	BatchSize int
+	// FlushInterval is the maximum time before incomplete batches are
+	// written.
+	FlushInterval time.Duration
+	// Retries is the number of times a batch failing with a temporary error
+	// is retried, with an exponential backoff starting at MinBackoff.
+	Retries    int
+	MinBackoff time.Duration
+
+	mutex   sync.Mutex
+	pending strings.Builder
+	lines   int
+	batches chan []byte
+
+	writes *prometheus.CounterVec
+}
+
+// New returns a Writer adding tags to every line, and writing to dest: the
+// URL of a write endpoint e.g., http://influxdb:8086/api/v2/write?bucket=disco,
+// or else the path of a file which lines are appended to. Requests to write
+// endpoints are authenticated with the token in tokenFile, if set.
+func New(dest, tokenFile string, tags map[string]string) (*Writer, error) {
+	out, err := newOutput(dest, tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		output:        out,
+		tags:          tags,
+		BatchSize:     DefaultBatchSize,
+		FlushInterval: DefaultFlushInterval,
+		Retries:       3,
+		MinBackoff:    time.Second,
+		batches:       make(chan []byte, queueSize),
+		writes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "disco_influx_batches_total",
+				Help: "Total number of InfluxDB line protocol batches, by result: written, failed or dropped.",
+			},
+			[]string{"result"},
+		),
+	}, nil
+}
+
+// Export adds the lines of points to the pending batch, which is queued once
+// it has BatchSize lines.
+func (w *Writer) Export(points []metrics.Point) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, p := range points {
+		w.pending.WriteString(Line(p, w.tags))
+		w.lines++
+		if w.lines >= w.BatchSize {
+			w.flushLocked()
+		}
+	}
+}
+
+// Flush queues the pending batch, if any.
+func (w *Writer) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.flushLocked()
+}
+
+// flushLocked queues the pending batch. If the queue is full, the batch is
+// dropped. The caller must hold the mutex.
+func (w *Writer) flushLocked() {
+	if w.lines == 0 {
+		return
+	}
+	select {
+	case w.batches <- []byte(w.pending.String()):
+	default:
+		errorLog.Log(slog.Default(), slog.LevelError, "queue", "InfluxDB write queue is full, dropping lines",
+			"lines", w.lines)
+		w.writes.WithLabelValues("dropped").Inc()
+	}
+	w.pending.Reset()
+	w.lines = 0
+}
+
+// Run writes the queued batches until ctx is cancelled, and queues the
+// pending batch every FlushInterval. The batches queued when ctx is cancelled
+// are written before Run returns.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			w.Flush()
+			w.drain()
+			return
+		case <-ticker.C:
+			w.Flush()
+		case batch := <-w.batches:
+			w.write(ctx, batch)
+		}
+	}
+}
+
+// drain writes the queued batches, giving up after 10s.
+func (w *Writer) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		select {
+		case batch := <-w.batches:
+			w.write(ctx, batch)
+		default:
+			return
+		}
+	}
+}
+
+// write writes batch, retrying temporary failures until ctx is cancelled.
+func (w *Writer) write(ctx context.Context, batch []byte) {
+	backoff := w.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := w.output.write(ctx, batch)
+		if err == nil {
+			w.writes.WithLabelValues("written").Inc()
+			return
+		}
+		if attempt >= w.Retries || !w.output.temporary(err) || ctx.Err() != nil {
+			errorLog.Log(slog.Default(), slog.LevelError, "write", "failed to write InfluxDB batch, dropping it",
+				logging.Err(err))
+			w.writes.WithLabelValues("failed").Inc()
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Describe sends the descriptors of the Writer's metrics.
+func (w *Writer) Describe(ch chan<- *prometheus.Desc) {
+	w.writes.Describe(ch)
+}
+
+// Collect sends the Writer's metrics.
+func (w *Writer) Collect(ch chan<- prometheus.Metric) {
+	w.writes.Collect(ch)
+}
@@ -0,0 +1,154 @@
+package influx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/go/rtx"
+)
+
+var tags = map[string]string{
+	"hostname": "mlab1-abc0t.mlab-oti.measurement-lab.org",
+	"target":   "s1-abc0t.measurement-lab.org",
+}
+
+func counter(name string, increase, value uint64) metrics.Point {
+	return metrics.Point{
+		Name:    name,
+		Scope:   "machine",
+		IfAlias: "mlab1",
+		IfDescr: "xe-0/0/11",
+		Sample:  archive.Sample{Value: increase, Counter: value, CollectStart: 1600000000123456789},
+	}
+}
+
+func TestLine(t *testing.T) {
+	gauge := int64(-250)
+	scaled := -2.5
+	tests := []struct {
+		name  string
+		point metrics.Point
+		want  string
+	}{
+		{
+			name:  "counter",
+			point: counter("ifHCInOctets", 100, 1100),
+			want: "ifHCInOctets,hostname=mlab1-abc0t.mlab-oti.measurement-lab.org,ifAlias=mlab1,interface=xe-0/0/11," +
+				"scope=machine,target=s1-abc0t.measurement-lab.org value=100i,counter=1100i 1600000000123456789\n",
+		},
+		{
+			name: "scaled-gauge-escaped",
+			point: metrics.Point{
+				Name:    "rx power",
+				Scope:   "uplink",
+				IfAlias: "uplink=1, a",
+				Gauge:   true,
+				Sample:  archive.Sample{Gauge: &gauge, Scaled: &scaled, CollectStart: 1600000000000000000},
+			},
+			want: `rx\ power,hostname=mlab1-abc0t.mlab-oti.measurement-lab.org,ifAlias=uplink\=1\,\ a,` +
+				"scope=uplink,target=s1-abc0t.measurement-lab.org value=-250i,scaled=-2.5 1600000000000000000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Line(tt.point, tags); got != tt.want {
+				t.Errorf("Line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// run runs w until all the points are written, which is signalled by done.
+func run(t *testing.T, w *Writer, done <-chan struct{}, points ...metrics.Point) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+	w.Export(points)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for the points to be written")
+	}
+	cancel()
+	<-stopped
+}
+
+func TestWriterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disco.lp")
+	w, err := New(path, "", tags)
+	rtx.Must(err, "Failed to create writer")
+	w.BatchSize = 2
+
+	// The first two points are a full batch, and the last one is written
+	// when the Writer stops.
+	done := make(chan struct{})
+	close(done)
+	run(t, w, done, counter("a", 1, 1), counter("b", 2, 2), counter("c", 3, 3))
+
+	data, err := ioutil.ReadFile(path)
+	rtx.Must(err, "Failed to read output")
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "a,") || !strings.HasPrefix(lines[2], "c,") {
+		t.Errorf("Expected lines for a, b and c, but got: %q", lines)
+	}
+}
+
+func TestWriterHTTP(t *testing.T) {
+	var mutex sync.Mutex
+	var requests int
+	var body string
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Token secret" || r.URL.Query().Get("bucket") != "disco" {
+			t.Errorf("Unexpected request: %v %v", r.URL, r.Header)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+		close(done)
+	}))
+	defer srv.Close()
+
+	token := filepath.Join(t.TempDir(), "token")
+	rtx.Must(ioutil.WriteFile(token, []byte("secret\n"), 0600), "Failed to write token")
+	w, err := New(srv.URL+"/api/v2/write?bucket=disco&precision=ns", token, tags)
+	rtx.Must(err, "Failed to create writer")
+	w.BatchSize = 2
+	w.MinBackoff = time.Millisecond
+
+	run(t, w, done, counter("a", 1, 1), counter("b", 2, 2))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if requests != 2 || strings.Count(body, "\n") != 2 {
+		t.Errorf("Expected a retried request with 2 lines, but got %d requests: %q", requests, body)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("", "", nil); err == nil {
+		t.Errorf("Expected an error without an output")
+	}
+	if _, err := New("/var/spool/disco.lp", "token", nil); err == nil {
+		t.Errorf("Expected an error for a token with a file output")
+	}
+}
@@ -0,0 +1,110 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// output writes batches of lines.
+type output interface {
+	write(ctx context.Context, batch []byte) error
+	// temporary returns true if a write which failed with err may succeed
+	// if it is retried.
+	temporary(err error) bool
+}
+
+// newOutput returns an httpOutput if dest is an HTTP(S) URL, or else a
+// fileOutput.
+func newOutput(dest, tokenFile string) (output, error) {
+	if dest == "" {
+		return nil, fmt.Errorf("an InfluxDB output is required")
+	}
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		return &httpOutput{url: dest, tokenFile: tokenFile, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	if tokenFile != "" {
+		return nil, fmt.Errorf("a token may only be used with an HTTP write endpoint")
+	}
+	return &fileOutput{path: dest}, nil
+}
+
+// fileOutput appends batches to a file. The file is reopened for every batch,
+// so that it may be rotated.
+type fileOutput struct {
+	path string
+}
+
+func (f *fileOutput) write(ctx context.Context, batch []byte) error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(batch)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *fileOutput) temporary(err error) bool {
+	return false
+}
+
+// httpError is the response of a failed write request.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e httpError) Error() string {
+	return e.msg
+}
+
+// httpOutput posts batches to the write endpoint of InfluxDB 1.x (/write) or
+// 2.x (/api/v2/write).
+type httpOutput struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+}
+
+func (h *httpOutput) write(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if h.tokenFile != "" {
+		// The token is read for every request, so that it may be rotated.
+		token, err := ioutil.ReadFile(h.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Token "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return httpError{status: resp.StatusCode, msg: fmt.Sprintf("%v: %s", resp.Status, bytes.TrimSpace(msg))}
+}
+
+// temporary returns true for network errors, rate limiting and server errors.
+func (h *httpOutput) temporary(err error) bool {
+	e, ok := err.(httpError)
+	return !ok || e.status == http.StatusTooManyRequests || e.status/100 == 5
+}
@@ -0,0 +1,127 @@
+// Package logging configures DISCO's structured, leveled logs, which are
+// written with slog as logfmt or JSON records.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Formats of the logs.
+const (
+	Logfmt = "logfmt"
+	JSON   = "json"
+)
+
+// Formats are the supported formats, the default first.
+var Formats = []string{Logfmt, JSON}
+
+// Keys of the attributes shared by the records of all packages.
+const (
+	TargetKey   = "target"
+	HostnameKey = "hostname"
+	OIDKey      = "oid"
+	MetricKey   = "metric"
+	ErrorKey    = "err"
+	// SuppressedKey is the number of records suppressed by a Limiter since
+	// the last one with the same key was logged.
+	SuppressedKey = "suppressed"
+)
+
+// New returns a logger writing the records of at least level to w in format.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case Logfmt:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case JSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be one of %v", format, Formats)
+	}
+}
+
+// SetDefault makes logger the default logger. The messages of the standard
+// log package, such as the failures of rtx.Must, become records of the error
+// level.
+func SetDefault(logger *slog.Logger) {
+	slog.SetDefault(logger)
+	log.SetOutput(slog.NewLogLogger(logger.Handler(), slog.LevelError).Writer())
+	log.SetFlags(0)
+}
+
+// Err returns the attribute of err.
+func Err(err error) slog.Attr {
+	return slog.Any(ErrorKey, err)
+}
+
+// Fatal logs msg and args at the error level with logger, and exits.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Limiter limits repetitive records, such as the timeouts of every
+// collection while a switch is unreachable, to one per interval for each
+// key. It is safe for concurrent use.
+type Limiter struct {
+	interval time.Duration
+	now      func() time.Time
+	mutex    sync.Mutex
+	keys     map[string]*limit
+}
+
+// limit is the state of a key of a Limiter.
+type limit struct {
+	last       time.Time
+	suppressed int
+}
+
+// NewLimiter returns a Limiter logging one record per interval for each key.
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		now:      time.Now,
+		keys:     map[string]*limit{},
+	}
+}
+
+// Log logs msg and args at level with logger, unless a record with the same
+// key was logged less than the interval ago. The record logged after some
+// were suppressed has their number as the SuppressedKey attribute.
+func (l *Limiter) Log(logger *slog.Logger, level slog.Level, key, msg string, args ...any) {
+	l.mutex.Lock()
+	now := l.now()
+	k, ok := l.keys[key]
+	if ok && now.Sub(k.last) < l.interval {
+		k.suppressed++
+		l.mutex.Unlock()
+		return
+	}
+	if !ok {
+		// Keys without suppressed records are forgotten once they expire,
+		// so that varying messages don't accumulate.
+		for other, o := range l.keys {
+			if o.suppressed == 0 && now.Sub(o.last) >= l.interval {
+				delete(l.keys, other)
+			}
+		}
+		k = &limit{}
+		l.keys[key] = k
+	}
+	suppressed := k.suppressed
+	k.last, k.suppressed = now, 0
+	l.mutex.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, SuppressedKey, suppressed)
+	}
+	logger.Log(context.Background(), level, msg, args...)
+}
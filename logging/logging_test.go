@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{format: Logfmt, want: `level=ERROR msg="failed to collect" target=s1-abc0t.measurement-lab.org err=timeout`},
+		{format: JSON, want: `"level":"ERROR","msg":"failed to collect","target":"s1-abc0t.measurement-lab.org","err":"timeout"}`},
+		{format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(&buf, tt.format, slog.LevelWarn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			logger.Info("ignored")
+			logger.Error("failed to collect", TargetKey, "s1-abc0t.measurement-lab.org", Err(errors.New("timeout")))
+			got := strings.TrimSpace(buf.String())
+			if strings.Contains(got, "ignored") || !strings.HasSuffix(got, tt.want) {
+				t.Errorf("Expected a record ending with %v, but got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	var buf bytes.Buffer
+	logger, err := New(&buf, JSON, slog.LevelInfo)
+	rtx.Must(err, "Failed to create logger")
+	SetDefault(logger)
+
+	log.Printf("Failed to read the interface table: %v", "timeout")
+	var record map[string]interface{}
+	rtx.Must(json.Unmarshal(buf.Bytes(), &record), "Failed to decode %q", buf.String())
+	if record["level"] != "ERROR" || record["msg"] != "Failed to read the interface table: timeout" {
+		t.Errorf("Expected an error record of the log message, but got: %v", record)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Logfmt, slog.LevelInfo)
+	rtx.Must(err, "Failed to create logger")
+	now := time.Date(2020, 6, 11, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(time.Minute)
+	l.now = func() time.Time { return now }
+
+	// A switch times out for 2 minutes, and another error occurs once.
+	for i := 0; i < 13; i++ {
+		l.Log(logger, slog.LevelError, "timeout", "failed to collect")
+		if i == 3 {
+			l.Log(logger, slog.LevelError, "type", "unknown type")
+		}
+		now = now.Add(10 * time.Second)
+	}
+	l.Log(logger, slog.LevelError, "write", "failed to write")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`msg="failed to collect"`,
+		`msg="unknown type"`,
+		`msg="failed to collect" suppressed=5`,
+		`msg="failed to collect" suppressed=5`,
+		`msg="failed to write"`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d records, but got: %v", len(want), lines)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("Expected record %d to end with %v, but got: %v", i, want[i], lines[i])
+		}
+	}
+	if _, ok := l.keys["type"]; ok || len(l.keys) != 2 {
+		t.Errorf("Expected the expired key to be forgotten, but got: %v", l.keys)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// loop is the main loop of DISCO, which collects the metrics of the switch
+// and writes them to archives.
+type loop struct {
+	// collect collects the metrics, write writes them to the archive sink and
+	// reload reloads the SNMP credentials.
+	collect func()
+	write   func()
+	reload  func()
+	// collectTicks, writeTicks and reloadTicks trigger collect, write and
+	// reload.
+	collectTicks <-chan time.Time
+	writeTicks   <-chan time.Time
+	reloadTicks  <-chan time.Time
+}
+
+// run calls collect, write and reload on every tick of their tickers, until
+// ctx is done or stop receives a value. Writes run in their own goroutine, so
+// that a slow archive sink doesn't delay collections. A write tick during a
+// write queues at most one more write, since it writes every sample collected
+// until it starts. When stop receives a value, run waits for the current
+// write and writes the remaining samples before returning.
+func (l *loop) run(ctx context.Context, stop <-chan os.Signal) {
+	writes := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range writes {
+			l.write()
+		}
+	}()
+	// finish waits for the queued writes.
+	finish := func() {
+		close(writes)
+		<-done
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			finish()
+			return
+		case <-l.writeTicks:
+			select {
+			case writes <- struct{}{}:
+			default:
+				slog.Warn("archive writes are slower than the write interval, skipping a write")
+			}
+		case <-l.collectTicks:
+			l.collect()
+		case <-l.reloadTicks:
+			l.reload()
+		case <-stop:
+			finish()
+			l.write()
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_loop(t *testing.T) {
+	collectTicks := make(chan time.Time)
+	writeTicks := make(chan time.Time)
+	collected := make(chan struct{})
+	// The first write stalls until unblocked, like a stalled object store.
+	stalled, unblock := make(chan struct{}), make(chan struct{})
+	writes := 0
+	l := &loop{
+		collect: func() { collected <- struct{}{} },
+		write: func() {
+			if writes == 0 {
+				close(stalled)
+				<-unblock
+			}
+			writes++
+		},
+		reload:       func() {},
+		collectTicks: collectTicks,
+		writeTicks:   writeTicks,
+	}
+	stop := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		l.run(context.Background(), stop)
+		close(done)
+	}()
+
+	// Collections continue during the stalled write, and write ticks don't
+	// block the loop either.
+	writeTicks <- time.Now()
+	<-stalled
+	for i := 0; i < 3; i++ {
+		select {
+		case collectTicks <- time.Now():
+		case <-time.After(10 * time.Second):
+			t.Fatal("The loop was blocked by a stalled write")
+		}
+		<-collected
+		writeTicks <- time.Now()
+	}
+
+	// Stopping waits for the stalled write and the queued one, then writes
+	// the remaining samples.
+	stop <- os.Interrupt
+	close(unblock)
+	<-done
+	if writes != 3 {
+		t.Errorf("Expected 3 writes, but got: %d", writes)
+	}
+}
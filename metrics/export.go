@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/m-lab/disco/archive"
+)
+
+// Point is an archive.Sample of an OID produced by Collect(), with the
+// metadata needed to push it to other monitoring systems.
+type Point struct {
+	// Name is the name of the config.Metric, which is also the name of its
+	// Prometheus metric.
+	Name string
+	// Labels are the labels of its Prometheus metric, including the optional
+	// labels and the ifAlias and interface labels.
+	Labels map[string]string
+	// Scope, IfAlias and IfDescr identify the interface of the OID.
+	Scope   string
+	IfAlias string
+	IfDescr string
+	// Unit is the unit of the converted value, if any.
+	Unit string
+	// Gauge is true if the metric is a gauge, rather than a counter.
+	Gauge bool
+	// Value is the value of the Prometheus metric: the converted gauge value,
+	// or the converted sum of the increases of a counter since Start.
+	Value float64
+	// Start is the CollectStart of the first collection, in nanoseconds since
+	// the epoch.
+	Start int64
+	// Sample is the archived sample, whose CollectStart is the time of the
+	// point.
+	Sample archive.Sample
+}
+
+// Exporter pushes the points of each collection to a monitoring system.
+// Export is called by Collect() with the lock of the Metrics held, so it must
+// not block, and must not keep points after it returns.
+type Exporter interface {
+	Export(points []Point)
+}
+
+// point returns the Point for the sample of o.
+func (metrics *Metrics) point(o *oid, value float64, sample archive.Sample) Point {
+	labels := make(map[string]string, len(metrics.switchLabels))
+	for i, name := range metrics.switchLabels {
+		labels[name] = o.labels[i]
+	}
+	return Point{
+		Name:    o.name,
+		Labels:  labels,
+		Scope:   o.scope,
+		IfAlias: o.ifAlias,
+		IfDescr: o.ifDescr,
+		Unit:    o.interval.Unit,
+		Gauge:   o.gauge,
+		Value:   value,
+		Start:   metrics.start,
+		Sample:  sample,
+	}
+}
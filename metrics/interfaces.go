@@ -0,0 +1,338 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/snmp"
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	ifNameOidStub = ".1.3.6.1.2.1.31.1.1.1.1"
+	ifTypeOidStub = ".1.3.6.1.2.1.2.2.1.3"
+	// ifStackStatusOid is the column of the ifStackTable, which is indexed by
+	// the higher and lower layer interfaces e.g., a LAG and its members.
+	ifStackStatusOid = ".1.3.6.1.2.1.31.1.2.1.3"
+	// lagIfType is the IANAifType of ieee8023adLag interfaces.
+	lagIfType = 161
+)
+
+// iface is a switch interface selected by a config.Interface.
+type iface struct {
+	// scope is the name of the config.Interface that selected the interface.
+	scope   string
+	index   string
+	ifAlias string
+	ifDescr string
+	// suffix is appended to the archive names of the interface's metrics. It
+	// is empty unless a selector matches more than one interface.
+	suffix string
+	// aggregate is true if the interface's metrics are also summed into an
+	// aggregate archived under the unsuffixed names.
+	aggregate bool
+}
+
+// Interface is a switch interface selected by a config.Interface.
+type Interface struct {
+	// Scope is the name of the config.Interface that selected the interface.
+	Scope   string `json:"scope"`
+	IfIndex string `json:"ifIndex"`
+	IfAlias string `json:"ifAlias"`
+	IfDescr string `json:"ifDescr"`
+}
+
+// ifaceVars holds the fields available to config.Interface.IfAlias templates.
+type ifaceVars struct {
+	Machine  string
+	Hostname string
+	Target   string
+}
+
+// ifEntry holds the values of the interface table for one interface. Only
+// the columns needed by the selectors are populated.
+type ifEntry struct {
+	index   string
+	ifAlias string
+	ifDescr string
+	ifName  string
+	ifType  int
+	// lowerLayers are the indexes of the interfaces stacked below this one,
+	// such as the members of a LAG.
+	lowerLayers []string
+}
+
+// selector is a config.Interface with its template and regular expressions
+// compiled.
+type selector struct {
+	config.Interface
+	ifAlias      string
+	ifAliasRegex *regexp.Regexp
+	ifDescrRegex *regexp.Regexp
+}
+
+// newSelector compiles a config.Interface, expanding its IfAlias template
+// with vars.
+func newSelector(i config.Interface, vars ifaceVars) (*selector, error) {
+	s := &selector{Interface: i}
+
+	tmpl, err := template.New(i.Name).Option("missingkey=error").Parse(i.IfAlias)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return nil, err
+	}
+	s.ifAlias = buf.String()
+
+	if i.IfAliasRegex != "" {
+		if s.ifAliasRegex, err = regexp.Compile(i.IfAliasRegex); err != nil {
+			return nil, err
+		}
+	}
+	if i.IfDescrRegex != "" {
+		if s.ifDescrRegex, err = regexp.Compile(i.IfDescrRegex); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// matches returns true if the interface e matches all criteria of the selector.
+func (s *selector) matches(e *ifEntry) bool {
+	switch {
+	case s.ifAlias != "" && e.ifAlias != s.ifAlias:
+		return false
+	case s.ifAliasRegex != nil && !s.ifAliasRegex.MatchString(e.ifAlias):
+		return false
+	case s.ifDescrRegex != nil && !s.ifDescrRegex.MatchString(e.ifDescr):
+		return false
+	case s.IfName != "" && e.ifName != s.IfName:
+		return false
+	case s.IfIndex != 0 && e.index != strconv.Itoa(s.IfIndex):
+		return false
+	case s.IfType != 0 && e.ifType != s.IfType:
+		return false
+	}
+	return true
+}
+
+// getIfTable walks the ifAlias column of the interface table, plus any other
+// columns needed by the selectors, returning the entries in walk order.
+func getIfTable(client snmp.Client, selectors []*selector) ([]*ifEntry, error) {
+	var needDescr, needName, needType, needStack bool
+	for _, s := range selectors {
+		needDescr = needDescr || s.ifDescrRegex != nil
+		needName = needName || s.IfName != ""
+		needType = needType || s.IfType != 0 || s.LagMembers
+		needStack = needStack || s.LagMembers
+	}
+
+	pdus, err := client.BulkWalkAll(snmp.IfAliasOidStub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk the ifAlias OID: %v", err)
+	}
+	entries := []*ifEntry{}
+	byIndex := map[string]*ifEntry{}
+	for _, pdu := range pdus {
+		index := lastOidPart(pdu.Name)
+		e := &ifEntry{
+			index:   index,
+			ifAlias: strings.TrimSpace(string(pdu.Value.([]byte))),
+		}
+		entries = append(entries, e)
+		byIndex[index] = e
+	}
+
+	columns := []struct {
+		needed bool
+		oid    string
+		set    func(e *ifEntry, value interface{})
+	}{
+		{needDescr, snmp.IfDescrOidStub, func(e *ifEntry, v interface{}) { e.ifDescr = toString(v) }},
+		{needName, ifNameOidStub, func(e *ifEntry, v interface{}) { e.ifName = toString(v) }},
+		{needType, ifTypeOidStub, func(e *ifEntry, v interface{}) { e.ifType, _ = v.(int) }},
+	}
+	for _, col := range columns {
+		if !col.needed {
+			continue
+		}
+		pdus, err := client.BulkWalkAll(col.oid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk OID %v: %v", col.oid, err)
+		}
+		for _, pdu := range pdus {
+			if e, ok := byIndex[lastOidPart(pdu.Name)]; ok {
+				col.set(e, pdu.Value)
+			}
+		}
+	}
+
+	if needStack {
+		pdus, err := client.BulkWalkAll(ifStackStatusOid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk the ifStackTable: %v", err)
+		}
+		for _, pdu := range pdus {
+			// The OID ends with <higher layer>.<lower layer>, where an index
+			// of 0 means there is no higher or lower layer.
+			parts := strings.Split(strings.TrimPrefix(pdu.Name, ifStackStatusOid+"."), ".")
+			if len(parts) != 2 || parts[0] == "0" || parts[1] == "0" {
+				continue
+			}
+			if e, ok := byIndex[parts[0]]; ok {
+				e.lowerLayers = append(e.lowerLayers, parts[1])
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// mustGetIfaces selects the interfaces of the switch described by the
+// configured interface selectors. It exits if a selector which isn't
+// optional does not match any interface, or if an interface is selected by
+// several selectors.
+func mustGetIfaces(logger *slog.Logger, client snmp.Client, interfaces []config.Interface, vars ifaceVars) []iface {
+	selectors := []*selector{}
+	for _, i := range interfaces {
+		s, err := newSelector(i, vars)
+		rtx.Must(err, "Invalid interface selector %q", i.Name)
+		selectors = append(selectors, s)
+	}
+
+	entries, err := getIfTable(client, selectors)
+	rtx.Must(err, "Failed to read the interface table")
+
+	byIndex := map[string]*ifEntry{}
+	for _, e := range entries {
+		byIndex[e.index] = e
+	}
+
+	ifaces := []iface{}
+	for _, s := range selectors {
+		var matched []*ifEntry
+		for _, e := range entries {
+			if s.matches(e) {
+				matched = append(matched, e)
+			}
+		}
+
+		if len(matched) == 0 {
+			if s.Optional {
+				logger.Warn("no interface matches optional selector", "selector", s.Name)
+				continue
+			}
+			logging.Fatal(logger, "failed to find logical iface number for selector", "selector", s.Name)
+		}
+		if len(matched) > 1 && !s.Multiple {
+			logger.Warn("several interfaces match selector, using the first", "selector", s.Name,
+				"matches", len(matched), "ifIndex", matched[0].index)
+			matched = matched[:1]
+		}
+
+		// Interfaces are only collected once per selector, even if they are
+		// both matched and a member of a matched LAG.
+		seen := map[string]bool{}
+		for _, e := range matched {
+			seen[e.index] = true
+		}
+
+		for _, e := range matched {
+			i := mustNewIface(logger, client, s.Name, e)
+			if len(matched) > 1 {
+				i.suffix = archiveSuffix(i.ifDescr)
+				i.aggregate = s.Aggregate
+			}
+			ifaces = append(ifaces, i)
+
+			if !s.LagMembers || e.ifType != lagIfType {
+				continue
+			}
+			for _, index := range e.lowerLayers {
+				member, ok := byIndex[index]
+				if !ok || seen[index] {
+					continue
+				}
+				seen[index] = true
+				m := mustNewIface(logger, client, s.Name, member)
+				m.suffix = archiveSuffix(m.ifDescr)
+				logger.Info("found LAG member", "selector", s.Name, "lag", i.ifDescr,
+					"interface", m.ifDescr, "ifIndex", m.index)
+				ifaces = append(ifaces, m)
+			}
+		}
+	}
+
+	rtx.Must(checkOverlaps(ifaces), "Interface selectors overlap")
+	return ifaces
+}
+
+// checkOverlaps returns an error if an interface is selected by several
+// selectors, since its OIDs would be collected for only one of them.
+func checkOverlaps(ifaces []iface) error {
+	scopes := map[string]string{}
+	for _, i := range ifaces {
+		if scope, ok := scopes[i.index]; ok {
+			return fmt.Errorf("interface %v (ifIndex %v) is selected by both %q and %q",
+				i.ifDescr, i.index, scope, i.scope)
+		}
+		scopes[i.index] = i.scope
+	}
+	return nil
+}
+
+// mustNewIface returns the iface for e, fetching its ifDescr if the interface
+// table walk did not include it.
+func mustNewIface(logger *slog.Logger, client snmp.Client, scope string, e *ifEntry) iface {
+	ifDescr := e.ifDescr
+	if ifDescr == "" {
+		ifDescrOid := createOID(snmp.IfDescrOidStub, e.index)
+		oidMap, err := getOidsString(client, []string{ifDescrOid})
+		rtx.Must(err, "Failed to determine the %v interface ifDescr", scope)
+		ifDescr = oidMap[ifDescrOid]
+	}
+	if ifDescr == "" {
+		logging.Fatal(logger, "failed to find ifDescr of logical iface", "selector", scope, "ifIndex", e.index)
+	}
+
+	return iface{
+		scope:   scope,
+		index:   e.index,
+		ifAlias: e.ifAlias,
+		ifDescr: ifDescr,
+	}
+}
+
+// archiveSuffix returns the suffix for the archive names of an interface,
+// which is its ifDescr with characters other than letters, digits, '-' and
+// '_' replaced by '_' e.g., ".xe-0_0_45".
+func archiveSuffix(ifDescr string) string {
+	return "." + strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, ifDescr)
+}
+
+// lastOidPart returns the last component of an OID, which for the columns of
+// the interface table is the logical interface number.
+func lastOidPart(oid string) string {
+	parts := strings.Split(oid, ".")
+	return parts[len(parts)-1]
+}
+
+// toString returns the string value of an OctetString PDU value.
+func toString(v interface{}) string {
+	b, _ := v.([]byte)
+	return string(b)
+}
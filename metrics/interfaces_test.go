@@ -0,0 +1,471 @@
+package metrics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/snmp"
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/exp/slog"
+)
+
+// tableClient is an snmp.Client which serves walks of whole OID subtrees and
+// gets of individual OIDs from static tables.
+type tableClient struct {
+	pdus []gosnmp.SnmpPDU
+}
+
+func (c *tableClient) BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	results := []gosnmp.SnmpPDU{}
+	for _, pdu := range c.pdus {
+		if strings.HasPrefix(pdu.Name, rootOid+".") {
+			results = append(results, pdu)
+		}
+	}
+	return results, nil
+}
+
+func (c *tableClient) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	packet := &gosnmp.SnmpPacket{}
+	for _, oid := range oids {
+		for _, pdu := range c.pdus {
+			if pdu.Name == oid {
+				packet.Variables = append(packet.Variables, pdu)
+			}
+		}
+	}
+	return packet, nil
+}
+
+func newTableClient(rows ...[4]string) *tableClient {
+	c := &tableClient{}
+	for _, r := range rows {
+		index, alias, descr, name := r[0], r[1], r[2], r[3]
+		c.pdus = append(c.pdus,
+			gosnmp.SnmpPDU{Name: snmp.IfAliasOidStub + "." + index, Type: gosnmp.OctetString, Value: []byte(alias)},
+			gosnmp.SnmpPDU{Name: snmp.IfDescrOidStub + "." + index, Type: gosnmp.OctetString, Value: []byte(descr)},
+			gosnmp.SnmpPDU{Name: ifNameOidStub + "." + index, Type: gosnmp.OctetString, Value: []byte(name)},
+		)
+		ifType := 6
+		if strings.HasPrefix(name, "ae") {
+			ifType = 161
+		}
+		c.pdus = append(c.pdus, gosnmp.SnmpPDU{Name: ifTypeOidStub + "." + index, Type: gosnmp.Integer, Value: ifType})
+	}
+	return c
+}
+
+var switchTable = withStack(newTableClient(
+	[4]string{"501", "mlab1", "xe-0/0/10", "xe-0/0/10"},
+	[4]string{"502", "mlab2 ", "xe-0/0/11", "xe-0/0/11"},
+	[4]string{"540", "drac-mlab1", "ge-0/0/46", "ge-0/0/46"},
+	[4]string{"568", "uplink-10g", "xe-0/0/45", "xe-0/0/45"},
+	[4]string{"600", "uplink-lag", "ae0", "ae0"},
+	[4]string{"601", "", "xe-0/0/46", "xe-0/0/46"},
+	[4]string{"602", "", "xe-0/0/47", "xe-0/0/47"},
+), [2]string{"600", "601"}, [2]string{"600", "602"}, [2]string{"0", "600"}, [2]string{"601", "0"})
+
+// withStack adds ifStackTable entries for each pair of higher and lower layer
+// interfaces to c.
+func withStack(c *tableClient, layers ...[2]string) *tableClient {
+	for _, l := range layers {
+		c.pdus = append(c.pdus, gosnmp.SnmpPDU{
+			Name:  ifStackStatusOid + "." + l[0] + "." + l[1],
+			Type:  gosnmp.Integer,
+			Value: 1,
+		})
+	}
+	return c
+}
+
+func Test_mustGetIfaces(t *testing.T) {
+	vars := ifaceVars{Machine: "mlab2", Hostname: hostname, Target: target}
+
+	tests := []struct {
+		name       string
+		interfaces []config.Interface
+		expect     []iface
+	}{
+		{
+			name:       "default-interfaces",
+			interfaces: config.DefaultInterfaces,
+			expect: []iface{
+				{scope: "machine", index: "502", ifAlias: "mlab2", ifDescr: "xe-0/0/11"},
+				{scope: "uplink", index: "568", ifAlias: "uplink-10g", ifDescr: "xe-0/0/45", suffix: ".xe-0_0_45", aggregate: true},
+				{scope: "uplink", index: "600", ifAlias: "uplink-lag", ifDescr: "ae0", suffix: ".ae0", aggregate: true},
+			},
+		},
+		{
+			name: "single-match-without-multiple",
+			interfaces: []config.Interface{
+				{Name: "uplink", IfAliasRegex: "^uplink"},
+			},
+			expect: []iface{
+				{scope: "uplink", index: "568", ifAlias: "uplink-10g", ifDescr: "xe-0/0/45"},
+			},
+		},
+		{
+			name: "lag-members",
+			interfaces: []config.Interface{
+				{Name: "uplink", IfAlias: "uplink-lag", LagMembers: true},
+			},
+			expect: []iface{
+				{scope: "uplink", index: "600", ifAlias: "uplink-lag", ifDescr: "ae0"},
+				{scope: "uplink", index: "601", ifAlias: "", ifDescr: "xe-0/0/46", suffix: ".xe-0_0_46"},
+				{scope: "uplink", index: "602", ifAlias: "", ifDescr: "xe-0/0/47", suffix: ".xe-0_0_47"},
+			},
+		},
+		{
+			name: "descr-name-index-and-type",
+			interfaces: []config.Interface{
+				{Name: "drac", IfDescrRegex: "^ge-"},
+				{Name: "first", IfName: "xe-0/0/10"},
+				{Name: "second", IfIndex: 502},
+				{Name: "lag", IfType: 161},
+			},
+			expect: []iface{
+				{scope: "drac", index: "540", ifAlias: "drac-mlab1", ifDescr: "ge-0/0/46"},
+				{scope: "first", index: "501", ifAlias: "mlab1", ifDescr: "xe-0/0/10"},
+				{scope: "second", index: "502", ifAlias: "mlab2", ifDescr: "xe-0/0/11"},
+				{scope: "lag", index: "600", ifAlias: "uplink-lag", ifDescr: "ae0"},
+			},
+		},
+		{
+			name: "combined-criteria-and-optional",
+			interfaces: []config.Interface{
+				{Name: "uplink", IfAliasRegex: "^uplink", IfType: 161},
+				{Name: "bmc", IfAlias: "bmc-{{.Machine}}", Optional: true},
+			},
+			expect: []iface{
+				{scope: "uplink", index: "600", ifAlias: "uplink-lag", ifDescr: "ae0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustGetIfaces(slog.Default(), switchTable, tt.interfaces, vars)
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("Unexpected interfaces.\nGot:\n%v\nExpected:\n%v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func Test_checkOverlaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifaces  []iface
+		wantErr bool
+	}{
+		{
+			name: "distinct",
+			ifaces: []iface{
+				{scope: "machine", index: "502", ifDescr: "xe-0/0/11"},
+				{scope: "uplink", index: "600", ifDescr: "ae0"},
+				{scope: "uplink", index: "601", ifDescr: "xe-0/0/46"},
+			},
+		},
+		{
+			name: "lag-member-selected-twice",
+			ifaces: []iface{
+				{scope: "uplink", index: "600", ifDescr: "ae0"},
+				{scope: "uplink", index: "601", ifDescr: "xe-0/0/46"},
+				{scope: "member", index: "601", ifDescr: "xe-0/0/46"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOverlaps(tt.ifaces)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkOverlaps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// setCounter sets the value of an OID in the table, adding it if necessary.
+func (c *tableClient) setCounter(oid string, value uint64) {
+	c.set(oid, gosnmp.Counter64, value)
+}
+
+// setGauge sets the Integer32 value of an OID in the table, as returned by
+// gosnmp, adding it if necessary.
+func (c *tableClient) setGauge(oid string, value int) {
+	c.set(oid, gosnmp.Integer, value)
+}
+
+func (c *tableClient) set(oid string, typ gosnmp.Asn1BER, value interface{}) {
+	for i := range c.pdus {
+		if c.pdus[i].Name == oid {
+			c.pdus[i].Value = value
+			return
+		}
+	}
+	c.pdus = append(c.pdus, gosnmp.SnmpPDU{Name: oid, Type: typ, Value: value})
+}
+
+func Test_CollectAggregate(t *testing.T) {
+
+	client := newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+		[4]string{"568", "uplink-1", "xe-0/0/45", "xe-0/0/45"},
+		[4]string{"569", "uplink-2", "xe-0/0/46", "xe-0/0/46"},
+	)
+	cfg := config.Config{Metrics: c.Metrics[:1]}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{})
+
+	for run, values := range [][3]uint64{{100, 1000, 2000}, {150, 1300, 2500}} {
+		for i, index := range []string{"502", "568", "569"} {
+			client.setCounter(ifHCInOctetsOidStub+"."+index, values[i])
+		}
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect run %d", run+1)
+	}
+
+	expect := map[string]uint64{
+		"switch.octets.local.rx":            50,
+		"switch.octets.uplink.rx.xe-0_0_45": 300,
+		"switch.octets.uplink.rx.xe-0_0_46": 500,
+	}
+	for _, o := range m.oids {
+		if len(o.interval.Samples) != 1 || o.interval.Samples[0].Value != expect[o.interval.Metric] {
+			t.Errorf("Unexpected samples for %v: %v", o.interval.Metric, o.interval.Samples)
+		}
+	}
+	if len(m.oids) != len(expect) {
+		t.Errorf("Expected %d oids, but got: %d", len(expect), len(m.oids))
+	}
+
+	agg, ok := m.aggregates["switch.octets.uplink.rx"]
+	if !ok {
+		t.Fatalf("Expected an aggregate for switch.octets.uplink.rx, but got: %v", m.aggregates)
+	}
+	expectSample := archive.Sample{Value: 800, Counter: 3800}
+	got := agg.interval.Samples[0]
+	if len(agg.interval.Samples) != 1 || got.Value != expectSample.Value || got.Counter != expectSample.Counter {
+		t.Errorf("Expected aggregate sample %v, but got: %v", expectSample, agg.interval.Samples)
+	}
+}
+
+func Test_CollectScaledAndGauge(t *testing.T) {
+
+	const rxPowerOidStub = ".1.3.6.1.4.1.2636.3.60.1.1.1.1.5"
+	client := newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+		[4]string{"568", "uplink-1", "xe-0/0/45", "xe-0/0/45"},
+	)
+	cfg := config.Config{
+		Interfaces: []config.Interface{{Name: "machine", IfAlias: "{{.Machine}}"}},
+		Metrics: []config.Metric{
+			{
+				Name:         "ifHCInBits",
+				OidStub:      ifHCInOctetsOidStub,
+				Scale:        8,
+				Unit:         "bits",
+				ArchiveNames: map[string]string{"machine": "switch.bits.local.rx"},
+			},
+			{
+				Name:         "rxPower",
+				OidStub:      rxPowerOidStub,
+				Type:         config.Gauge,
+				Scale:        0.01,
+				Unit:         "dBm",
+				ArchiveNames: map[string]string{"machine": "switch.rxpower.local"},
+			},
+		},
+	}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{})
+
+	for run, values := range [][2]int{{100, -250}, {150, -300}} {
+		client.setCounter(ifHCInOctetsOidStub+".502", uint64(values[0]))
+		client.setGauge(rxPowerOidStub+".502", values[1])
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect run %d", run+1)
+	}
+
+	bits := m.oids[ifHCInOctetsOidStub+".502"].interval
+	if len(bits.Samples) != 1 || bits.Unit != "bits" {
+		t.Fatalf("Unexpected ifHCInBits archive: %+v", bits)
+	}
+	if s := bits.Samples[0]; s.Value != 50 || s.Counter != 150 || s.Scaled == nil || *s.Scaled != 400 {
+		t.Errorf("Expected raw increase 50, counter 150 and scaled 400, but got: %+v", s)
+	}
+
+	power := m.oids[rxPowerOidStub+".502"].interval
+	if len(power.Samples) != 1 {
+		t.Fatalf("Expected 1 rxPower sample, but got: %+v", power.Samples)
+	}
+	if s := power.Samples[0]; s.Gauge == nil || *s.Gauge != -300 || s.Scaled == nil || *s.Scaled != -3 {
+		t.Errorf("Expected raw gauge -300 and scaled -3, but got: %+v", s)
+	}
+
+	if got := testutil.ToFloat64(m.gauges["rxPower"].WithLabelValues("mlab2", "xe-0/0/11")); got != -3 {
+		t.Errorf("Expected the rxPower gauge to be -3, but got: %v", got)
+	}
+	if got := testutil.ToFloat64(m.prom["ifHCInBits"].WithLabelValues("mlab2", "xe-0/0/11")); got != 400 {
+		t.Errorf("Expected the ifHCInBits counter to be 400, but got: %v", got)
+	}
+}
+
+func Test_CollectRates(t *testing.T) {
+	const (
+		ifInOctetsOidStub    = ".1.3.6.1.2.1.2.2.1.10"
+		ifHCOutOctetsOidStub = ".1.3.6.1.2.1.31.1.1.1.10"
+	)
+	client := newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+	)
+	client.set(ifHighSpeedOidStub+".502", gosnmp.Gauge32, uint(1000))
+	archiveNames := func(name string) map[string]string {
+		return map[string]string{"machine": name}
+	}
+	cfg := config.Config{
+		Interfaces: []config.Interface{{Name: "machine", IfAlias: "{{.Machine}}"}},
+		Metrics: []config.Metric{
+			// The 64-bit counters are used for rates, even if the 32-bit
+			// counters are also collected.
+			{Name: "ifInOctets", OidStub: ifInOctetsOidStub, ArchiveNames: archiveNames("switch.octets32.local.rx")},
+			{Name: "ifHCInOctets", OidStub: ifHCInOctetsOidStub, ArchiveNames: archiveNames("switch.octets.local.rx")},
+			{Name: "ifHCOutOctets", OidStub: ifHCOutOctetsOidStub, ArchiveNames: archiveNames("switch.octets.local.tx")},
+		},
+	}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{})
+
+	for run, values := range [][3]uint64{{1000, 1000, 1000}, {1001, 12501000, 125001000}} {
+		client.set(ifInOctetsOidStub+".502", gosnmp.Counter32, uint(values[0]))
+		client.setCounter(ifHCInOctetsOidStub+".502", values[1])
+		client.setCounter(ifHCOutOctetsOidStub+".502", values[2])
+		if run == 1 {
+			// Pretend that the last collection was 10s ago.
+			m.lastCollect = time.Now().Add(-10 * time.Second)
+		}
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect run %d", run+1)
+	}
+
+	for _, tt := range []struct {
+		direction   string
+		bps         float64
+		utilization float64
+	}{
+		{"rx", 1e7, 1},
+		{"tx", 1e8, 10},
+	} {
+		labels := []string{"machine", "mlab2", "xe-0/0/11", tt.direction}
+		bps := testutil.ToFloat64(m.rates.WithLabelValues(labels...))
+		if bps < tt.bps*0.99 || bps > tt.bps {
+			t.Errorf("Expected %v rate of about %v bps, but got: %v", tt.direction, tt.bps, bps)
+		}
+		utilization := testutil.ToFloat64(m.utilization.WithLabelValues(labels...))
+		if utilization < tt.utilization*0.99 || utilization > tt.utilization {
+			t.Errorf("Expected %v utilization of about %v%%, but got: %v", tt.direction, tt.utilization, utilization)
+		}
+	}
+}
+
+// speedCounter counts the GETs of interface speeds.
+type speedCounter struct {
+	*tableClient
+	gets int
+}
+
+func (c *speedCounter) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	if strings.HasPrefix(oids[0], ifHighSpeedOidStub+".") {
+		c.gets++
+	}
+	return c.tableClient.Get(oids)
+}
+
+func Test_CollectSpeedInterval(t *testing.T) {
+	client := &speedCounter{tableClient: newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+	)}
+	client.set(ifHighSpeedOidStub+".502", gosnmp.Gauge32, uint(1000))
+	client.setCounter(ifHCInOctetsOidStub+".502", 1000)
+	cfg := config.Config{
+		Interfaces: []config.Interface{{Name: "machine", IfAlias: "{{.Machine}}"}},
+		Metrics: []config.Metric{
+			{Name: "ifHCInOctets", OidStub: ifHCInOctetsOidStub,
+				ArchiveNames: map[string]string{"machine": "switch.octets.local.rx"}},
+		},
+	}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{})
+	if client.gets != 1 || m.speeds[ifHighSpeedOidStub+".502"] != 1000 {
+		t.Fatalf("Expected the speeds to be read at discovery, but got %d GETs and: %v", client.gets, m.speeds)
+	}
+
+	// Speeds are only read again once SpeedInterval has passed.
+	for i := 0; i < 3; i++ {
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect")
+	}
+	if client.gets != 1 {
+		t.Errorf("Expected no speed GETs within SpeedInterval, but got: %d", client.gets-1)
+	}
+	m.speedsUpdated = time.Now().Add(-m.SpeedInterval)
+	rtx.Must(m.Collect(client, cfg), "Failed to collect")
+	if client.gets != 2 {
+		t.Errorf("Expected the speeds to be read after SpeedInterval, but got %d GETs", client.gets)
+	}
+}
+
+// recorder is an Exporter recording the exported points.
+type recorder struct {
+	points [][]Point
+}
+
+func (r *recorder) Export(points []Point) {
+	r.points = append(r.points, points)
+}
+
+func Test_CollectExporters(t *testing.T) {
+	client := newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+	)
+	cfg := config.Config{
+		Interfaces: []config.Interface{{Name: "machine", IfAlias: "{{.Machine}}"}},
+		Metrics: []config.Metric{{
+			Name:         "ifHCInBits",
+			OidStub:      ifHCInOctetsOidStub,
+			Scale:        8,
+			ArchiveNames: map[string]string{"machine": "switch.bits.local.rx"},
+		}},
+	}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{Names: []string{"scope", "target"}})
+	r := &recorder{}
+	m.Exporters = []Exporter{r}
+
+	for run, value := range []uint64{100, 150, 175} {
+		client.setCounter(ifHCInOctetsOidStub+".502", value)
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect run %d", run+1)
+	}
+
+	// Nothing is exported by the first collection, and counters are
+	// cumulative like their Prometheus counter.
+	if len(r.points) != 2 {
+		t.Fatalf("Expected 2 exports, but got: %v", r.points)
+	}
+	for i, value := range []float64{400, 600} {
+		if len(r.points[i]) != 1 {
+			t.Fatalf("Expected 1 point in export %d, but got: %v", i+1, r.points[i])
+		}
+		p := r.points[i][0]
+		if p.Name != "ifHCInBits" || p.Value != value || p.Gauge || p.Start == 0 || p.Sample.CollectStart <= p.Start {
+			t.Errorf("Unexpected point in export %d: %+v", i+1, p)
+		}
+		expect := map[string]string{"scope": "machine", "target": target, "ifAlias": "mlab2", "interface": "xe-0/0/11"}
+		if !reflect.DeepEqual(p.Labels, expect) {
+			t.Errorf("Expected labels %v, but got: %v", expect, p.Labels)
+		}
+	}
+}
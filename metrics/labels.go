@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LabelNames are the optional labels of the Prometheus metrics, in the order
+// in which they are added:
+//
+//   - scope is the name of the config.Interface selecting the interface e.g.,
+//     "machine" or "uplink".
+//   - target is the switch FQDN.
+//   - machine is the short name of the node e.g., "mlab1".
+//   - site is the site of the node e.g., "abc0t".
+var LabelNames = []string{"scope", "target", "machine", "site"}
+
+// DefaultLabels is the default value of the -prometheus-labels flag.
+const DefaultLabels = "scope,target,machine,site"
+
+// Labels configures the labels of the Prometheus metrics.
+type Labels struct {
+	// Names are the LabelNames added to every metric.
+	Names []string
+	// Site is the value of the site label.
+	Site string
+	// Legacy keeps the label sets of earlier releases: the switch metrics
+	// only have ifAlias and interface labels, and the collection metrics a
+	// machine label with the node's hostname. Names is ignored.
+	//
+	// Deprecated: Legacy will be removed in the next release.
+	Legacy bool
+}
+
+// ParseLabelNames returns the comma-separated LabelNames in s, in the order of
+// LabelNames.
+func ParseLabelNames(s string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isLabelName(name) {
+			return nil, fmt.Errorf("unknown label '%v', must be one of %v", name, strings.Join(LabelNames, ", "))
+		}
+		selected[name] = true
+	}
+	names := []string{}
+	for _, name := range LabelNames {
+		if selected[name] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// isLabelName returns true if name is one of LabelNames.
+func isLabelName(name string) bool {
+	for _, n := range LabelNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// switchNames returns the optional labels of the switch metrics.
+func (l Labels) switchNames() []string {
+	if l.Legacy {
+		return nil
+	}
+	return l.Names
+}
+
+// rateNames returns the optional labels of the rate and utilization metrics,
+// which always have the scope label.
+func (l Labels) rateNames() []string {
+	names := []string{"scope"}
+	for _, name := range l.switchNames() {
+		if name != "scope" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// collectNames returns the optional labels of the metrics of the collection
+// itself, which have no scope.
+func (l Labels) collectNames() []string {
+	if l.Legacy {
+		return []string{"machine"}
+	}
+	names := []string{}
+	for _, name := range l.Names {
+		if name != "scope" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
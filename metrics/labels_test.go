@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestParseLabelNames(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: DefaultLabels, want: []string{"scope", "target", "machine", "site"}},
+		{s: "site, scope", want: []string{"scope", "site"}},
+		{s: "", want: []string{}},
+		{s: "scope,ifAlias", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseLabelNames(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabelNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLabelNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  Labels
+		counter map[string]string
+		collect map[string]string
+	}{
+		{
+			name:   "default",
+			labels: Labels{Names: LabelNames, Site: "abc0t"},
+			counter: map[string]string{
+				"scope": "uplink", "target": target, "machine": "mlab2", "site": "abc0t",
+				"ifAlias": "uplink-10g", "interface": "xe-0/0/45",
+			},
+			collect: map[string]string{"target": target, "machine": "mlab2", "site": "abc0t"},
+		},
+		{
+			name:   "some",
+			labels: Labels{Names: []string{"scope", "site"}, Site: "abc0t"},
+			counter: map[string]string{
+				"scope": "uplink", "site": "abc0t", "ifAlias": "uplink-10g", "interface": "xe-0/0/45",
+			},
+			collect: map[string]string{"site": "abc0t"},
+		},
+		{
+			name:    "legacy",
+			labels:  Labels{Names: LabelNames, Site: "abc0t", Legacy: true},
+			counter: map[string]string{"ifAlias": "uplink-10g", "interface": "xe-0/0/45"},
+			collect: map[string]string{"machine": hostname},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s1 := &mockSwitchClient{run: 1}
+			m := New(s1, c, target, hostname, "mlab2", tt.labels)
+			m.CollectStart = time.Now()
+			m.Collect(s1, c)
+			m.Collect(&mockSwitchClient{run: 2}, c)
+
+			families, err := m.Registry().Gather()
+			rtx.Must(err, "Failed to gather metrics")
+			for _, f := range families {
+				var want map[string]string
+				switch f.GetName() {
+				case "ifHCInOctets":
+					want = tt.counter
+				case "disco_collect_duration_seconds":
+					want = tt.collect
+				default:
+					continue
+				}
+				got := map[string]string{}
+				for _, l := range f.GetMetric()[len(f.GetMetric())-1].GetLabel() {
+					got[l.GetName()] = l.GetValue()
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Expected %v labels %v, but got: %v", f.GetName(), want, got)
+				}
+			}
+		})
+	}
+}
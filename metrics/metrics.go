@@ -2,39 +2,138 @@ package metrics
 
 import (
 	"fmt"
-	"log"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/m-lab/disco/archive"
 	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/logging"
 	"github.com/m-lab/disco/snmp"
+	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/exp/slog"
 )
 
 const (
-	ifAliasOid     = ".1.3.6.1.2.1.31.1.1.1.18"
-	ifDescrOidStub = ".1.3.6.1.2.1.2.2.1.2"
+	// ifHighSpeedOidStub is the speed of interfaces in Mbit/s.
+	ifHighSpeedOidStub = ".1.3.6.1.2.1.31.1.1.1.15"
 )
 
-var (
-	collectDuration *prometheus.HistogramVec
-	collectErrors   *prometheus.CounterVec
+// octets describes an IF-MIB octet counter, from which the rate and
+// utilization gauges of interfaces are computed.
+type octets struct {
+	// direction is "rx" or "tx".
+	direction string
+	// hc is true for the 64-bit counters, which are preferred over the
+	// 32-bit ones if both are collected.
+	hc bool
+}
+
+// octetsOidStubs maps the OID stubs of the IF-MIB octet counters to their
+// direction.
+var octetsOidStubs = map[string]octets{
+	".1.3.6.1.2.1.2.2.1.10":    {"rx", false}, // ifInOctets
+	".1.3.6.1.2.1.2.2.1.16":    {"tx", false}, // ifOutOctets
+	".1.3.6.1.2.1.31.1.1.1.6":  {"rx", true},  // ifHCInOctets
+	".1.3.6.1.2.1.31.1.1.1.10": {"tx", true},  // ifHCOutOctets
+}
+
+const (
+	// collectLogInterval limits the logging of identical collection errors,
+	// such as the timeouts of every collection while the switch is
+	// unreachable.
+	collectLogInterval = time.Minute
+	// speedLogInterval limits the logging of failures to get interface
+	// speeds, which don't prevent collection.
+	speedLogInterval = 10 * time.Minute
+	// DefaultSpeedInterval is the default SpeedInterval, the default write
+	// interval.
+	DefaultSpeedInterval = 5 * time.Minute
+	// maxUnwritten is the number of archives and manifests kept for the next
+	// Write() when writing them fails, a day of archives in two formats with
+	// manifests at the default write interval of 5m.
+	maxUnwritten = 4 * 288
 )
 
 // Metrics represents a collection of oids, plus additional data about the environment.
 type Metrics struct {
 	// TODO(kinkade): remove this field in favor of a more elegant solution.
-	firstRun     bool
-	hostname     string
-	oids         map[string]*oid
-	machine      string
-	mutex        sync.Mutex
-	prom         map[string]*prometheus.CounterVec
+	firstRun   bool
+	hostname   string
+	oids       map[string]*oid
+	aggregates map[string]*aggregate
+	ifaces     []iface
+	machine    string
+	mutex      sync.Mutex
+	prom       map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	// collectDuration, collectErrors and switchInfo describe the collection
+	// itself, rather than the switch's counters.
+	collectDuration *prometheus.HistogramVec
+	collectErrors   *prometheus.CounterVec
+	switchInfo      *prometheus.GaugeVec
+	// rates and utilization are the bits per second and percentage of the
+	// speed of each interface with octet counters, over the last interval.
+	rates       *prometheus.GaugeVec
+	utilization *prometheus.GaugeVec
+	// speeds maps the ifHighSpeed OIDs of the interfaces with rates to their
+	// last known speed in Mbit/s, or 0 if unknown. They are read at discovery
+	// and then every SpeedInterval, at speedsUpdated.
+	speeds        map[string]uint64
+	speedsUpdated time.Time
+	// SpeedInterval is the interval between reads of the interface speeds,
+	// which rarely change, so that collections don't need an extra GET.
+	SpeedInterval time.Duration
+	// lastCollect is the midpoint of the last successful collection, from
+	// which rates are computed.
+	lastCollect time.Time
+	// start is the start of the first successful collection, in nanoseconds
+	// since the epoch, from which the Prometheus counters are summed.
+	start int64
+	// labels configures the optional labels of the Prometheus metrics, and
+	// collectLabels are their values for the collection metrics.
+	labels        Labels
+	collectLabels []string
+	// switchLabels are the label names of the switch metrics.
+	switchLabels []string
+	// registry is the private registry of the Metrics' collector.
+	registry     *prometheus.Registry
+	configHash   string
+	sequence     int
+	target       string
 	CollectStart time.Time
+	// Formats are the archive formats written by Write().
+	Formats []archive.Format
+	// Namer generates the names of archives written by Write().
+	Namer *archive.Namer
+	// Manifests enables writing a sidecar archive.Manifest for each archive.
+	Manifests bool
+	// Exporters push the points of each collection, after the first one.
+	Exporters []Exporter
+	// device is the switch recorded in archives and manifests, if it was
+	// detected.
+	device *archive.Device
+	// metricInfos are the configured metrics, and errors the recent errors,
+	// for State().
+	metricInfos []MetricInfo
+	errors      []Error
+	// logger logs with the target and hostname, and collectLog and speedLog
+	// limit its repetitive records.
+	logger     *slog.Logger
+	collectLog *logging.Limiter
+	speedLog   *logging.Limiter
+	// writeMutex serializes Write(), and protects unwritten, the archives
+	// whose write failed.
+	writeMutex sync.Mutex
+	unwritten  []encodedArchive
+}
+
+// encodedArchive is an archive, or manifest, of a Write().
+type encodedArchive struct {
+	name   string
+	format archive.Format
+	data   []byte
 }
 
 type oid struct {
@@ -44,67 +143,53 @@ type oid struct {
 	ifAlias       string
 	ifDescr       string
 	interval      archive.Model
+	// gauge, scale and offset are the type and conversion of the
+	// config.Metric.
+	gauge  bool
+	scale  float64
+	offset float64
+	// direction is set for the octet counters from which the rate and
+	// utilization of the interface are computed, whose ifHighSpeed is
+	// speedOid.
+	direction string
+	speedOid  string
+	// labels and rateLabels are the values of the labels of the Prometheus
+	// metrics for the OID, and of its rate and utilization.
+	labels     []string
+	rateLabels []string
+	// total is the converted sum of the increases of a counter, which is the
+	// value of its Prometheus counter.
+	total float64
+	// collected is true once the OID has been collected, and hasDelta once
+	// lastDelta, the last increase of a counter, is known. lastGauge is the
+	// last value of a gauge.
+	collected bool
+	hasDelta  bool
+	lastDelta uint64
+	lastGauge int64
 }
 
-// mustGetIfaces uses an ifAlias value to determine the logical interface number and
-// description for the machine's interface and the switch's uplink.
-func mustGetIfaces(client snmp.Client, machine string) map[string]map[string]string {
-	pdus, err := client.BulkWalkAll(ifAliasOid)
-	rtx.Must(err, "Failed to walk the ifAlias OID")
-
-	ifaces := map[string]map[string]string{
-		"machine": {
-			"iface":   "",
-			"ifAlias": "",
-			"ifDescr": "",
-		},
-		"uplink": {
-			"iface":   "",
-			"ifAlias": "",
-			"ifDescr": "",
-		},
-	}
-
-	for _, pdu := range pdus {
-		oidParts := strings.Split(pdu.Name, ".")
-		iface := oidParts[len(oidParts)-1]
-
-		val := strings.TrimSpace(string(pdu.Value.([]byte)))
-		if val == machine {
-			ifDescrOid := createOID(ifDescrOidStub, iface)
-			oidMap, err := getOidsString(client, []string{ifDescrOid})
-			rtx.Must(err, "Failed to determine the machine interface ifDescr")
-			ifaces["machine"]["ifAlias"] = val
-			ifaces["machine"]["ifDescr"] = oidMap[ifDescrOid]
-			ifaces["machine"]["iface"] = iface
-		}
-		if strings.HasPrefix(val, "uplink") {
-			ifDescrOid := createOID(ifDescrOidStub, iface)
-			oidMap, err := getOidsString(client, []string{ifDescrOid})
-			rtx.Must(err, "Failed to determine the uplink interface ifDescr")
-			ifaces["uplink"]["ifAlias"] = val
-			ifaces["uplink"]["ifDescr"] = oidMap[ifDescrOid]
-			ifaces["uplink"]["iface"] = iface
-		}
-	}
-
-	// Fail if any machine information was not found.
-	if ifaces["machine"]["iface"] == "" {
-		log.Fatalf("Failed to find logical iface number for machine: %v", machine)
-	}
-	if ifaces["machine"]["ifDescr"] == "" {
-		log.Fatalf("Failed to find ifDescr for machine logical iface: %v", ifaces["machine"]["iface"])
-	}
+// convert returns raw converted by the scale and offset of the metric.
+func (o *oid) convert(raw float64) float64 {
+	return config.Metric{Scale: o.scale, Offset: o.offset}.Convert(raw)
+}
 
-	// Fail if any uplink information was not found.
-	if ifaces["uplink"]["iface"] == "" {
-		log.Fatal("Failed to find logical iface number for uplink")
-	}
-	if ifaces["uplink"]["ifDescr"] == "" {
-		log.Fatalf("Failed to find ifDescr for uplink logical iface: %v", ifaces["uplink"]["iface"])
+// scaled returns raw converted by the scale and offset of the metric for
+// archive.Sample.Scaled, or nil if the metric has neither.
+func (o *oid) scaled(raw float64) *float64 {
+	if o.scale == 0 && o.offset == 0 {
+		return nil
 	}
+	v := o.convert(raw)
+	return &v
+}
 
-	return ifaces
+// aggregate is the sum of a metric over several interfaces, such as all the
+// uplinks of a switch.
+type aggregate struct {
+	// oids are the keys of the summed oids in Metrics.oids.
+	oids     []string
+	interval archive.Model
 }
 
 // getOidsString accepts a list of OIDS and returns a map of the OIDs to their
@@ -144,6 +229,50 @@ func getOidsInt64(client snmp.Client, oids []string) (map[string]uint64, error)
 	return oidMap, nil
 }
 
+// getOidsGauge accepts a list of gauge OIDs and returns a map of the OIDs to
+// their signed values, since gauges such as optical power may be negative.
+func getOidsGauge(client snmp.Client, oids []string) (map[string]int64, error) {
+	oidMap := make(map[string]int64)
+	result, err := client.Get(oids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pdu := range result.Variables {
+		switch value := pdu.Value.(type) {
+		case int:
+			oidMap[pdu.Name] = int64(value)
+		case int64:
+			oidMap[pdu.Name] = value
+		case uint:
+			oidMap[pdu.Name] = int64(value)
+		case uint32:
+			oidMap[pdu.Name] = int64(value)
+		case uint64:
+			oidMap[pdu.Name] = int64(value)
+		default:
+			return nil, fmt.Errorf("unknown type %T of SNMP type %v for gauge OID %v", value, pdu.Type, pdu.Name)
+		}
+	}
+	return oidMap, nil
+}
+
+// getSpeeds returns the ifHighSpeed of interfaces in Mbit/s, keyed by the
+// requested OIDs. Interfaces without a speed are omitted.
+func getSpeeds(client snmp.Client, oids []string) (map[string]uint64, error) {
+	result, err := client.Get(oids)
+	if err != nil {
+		return nil, err
+	}
+	speeds := make(map[string]uint64)
+	for _, pdu := range result.Variables {
+		if v, ok := pdu.Value.(uint); ok {
+			speeds[pdu.Name] = uint64(v)
+		}
+	}
+	return speeds, nil
+}
+
 // createOID joins an OID stub with a logical interface number, returning the
 // complete OID.
 func createOID(oidStub string, iface string) string {
@@ -158,67 +287,227 @@ func (metrics *Metrics) Collect(client snmp.Client, config config.Config) error
 	metrics.mutex.Lock()
 	defer metrics.mutex.Unlock()
 
-	oids := []string{}
-	for oid := range metrics.oids {
-		oids = append(oids, oid)
+	oids, gaugeOids := []string{}, []string{}
+	for oid, o := range metrics.oids {
+		if o.gauge {
+			gaugeOids = append(gaugeOids, oid)
+		} else {
+			oids = append(oids, oid)
+		}
 	}
 
 	collectStart := time.Now()
-	oidValueMap, err := getOidsInt64(client, oids)
+	var oidValueMap map[string]uint64
+	var gaugeValueMap map[string]int64
+	var err error
+	if len(oids) > 0 {
+		oidValueMap, err = getOidsInt64(client, oids)
+	}
+	if err == nil && len(gaugeOids) > 0 {
+		gaugeValueMap, err = getOidsGauge(client, gaugeOids)
+	}
 	if err != nil {
-		log.Printf("ERROR: failed to GET OIDs (%v) from SNMP server: %v", oids, err)
-		collectErrors.WithLabelValues(metrics.hostname).Inc()
+		metrics.collectLog.Log(metrics.logger, slog.LevelError, err.Error(), "failed to GET OIDs from the SNMP agent",
+			"oids", config.Resolver().Names(append(oids, gaugeOids...)), logging.Err(err))
+		metrics.collectErrors.WithLabelValues(metrics.collectLabels...).Inc()
+		metrics.recordError(err)
 		return err
 	}
 	collectEnd := time.Now()
 
 	// Add the collect duration in seconds to a historgram metric.
-	collectDuration.WithLabelValues(metrics.hostname).Observe(
+	metrics.collectDuration.WithLabelValues(metrics.collectLabels...).Observe(
 		float64(collectEnd.Sub(collectStart)) / float64(time.Second),
 	)
 
+	// Rates are computed over the time between the midpoints of consecutive
+	// collections, which is more precise than the collection interval.
+	collectTime := collectStart.Add(collectEnd.Sub(collectStart) / 2)
+	var interval float64
+	if !metrics.lastCollect.IsZero() {
+		interval = collectTime.Sub(metrics.lastCollect).Seconds()
+	}
+	metrics.lastCollect = collectTime
+	if collectStart.Sub(metrics.speedsUpdated) >= metrics.SpeedInterval {
+		metrics.updateSpeeds(client)
+	}
+
+	newSample := func(increase, value uint64) archive.Sample {
+		return archive.Sample{
+			// NOTE: The value of CollectStart is assigned to every metric
+			// in a given collection, and this fact is taken advantage of in
+			// metrics.Write(). If we start assigning possibly unique
+			// timestamps to each sample metric, then the code in Write()
+			// will need to be modified.
+			Timestamp:    metrics.CollectStart.Unix(),
+			CollectStart: collectStart.UnixNano(),
+			CollectEnd:   collectEnd.UnixNano(),
+			Value:        increase,
+			Counter:      value,
+		}
+	}
+
+	increases := make(map[string]uint64, len(oidValueMap))
+	var points []Point
+	if metrics.firstRun {
+		metrics.start = collectStart.UnixNano()
+	}
 	for oid, value := range oidValueMap {
+		o := metrics.oids[oid]
+		o.collected = true
 		// If this is the first run then we have no previousValue with which to
 		// calculate an increase, so we just record a previousValue and return.
 		if metrics.firstRun {
-			metrics.oids[oid].previousValue = value
+			o.previousValue = value
 			continue
 		}
 
-		increase := value - metrics.oids[oid].previousValue
-		ifAlias := metrics.oids[oid].ifAlias
-		ifDescr := metrics.oids[oid].ifDescr
-		metricName := metrics.oids[oid].name
-		metrics.prom[metricName].WithLabelValues(ifAlias, ifDescr).Add(float64(increase))
-
-		metrics.oids[oid].interval.Samples = append(
-			metrics.oids[oid].interval.Samples,
-			archive.Sample{
-				// NOTE: The value of CollectStart is assigned to every metric
-				// in a given collection, and this fact is taken advantage of in
-				// metrics.Write(). If we start assigning possibly unique
-				// timestamps to each sample metric, then the code in Write()
-				// will need to be modified.
-				Timestamp:    metrics.CollectStart.Unix(),
-				CollectStart: collectStart.UnixNano(),
-				CollectEnd:   collectEnd.UnixNano(),
-				Value:        increase,
-				Counter:      value},
-		)
+		increase := value - o.previousValue
+		o.hasDelta, o.lastDelta = true, increase
+		metrics.prom[o.name].WithLabelValues(o.labels...).Add(o.convert(float64(increase)))
+		o.total += o.convert(float64(increase))
+
+		sample := newSample(increase, value)
+		sample.Scaled = o.scaled(float64(increase))
+		o.interval.Samples = append(o.interval.Samples, sample)
+		points = append(points, metrics.point(o, o.total, sample))
+		if o.direction != "" && interval > 0 {
+			metrics.setRate(o, float64(increase)*8/interval)
+		}
 
 		metrics.oids[oid].previousValue = value
+		increases[oid] = increase
+	}
+
+	// Gauges are skipped on the first run too, so that every OID has samples
+	// for the same collections.
+	for oid, value := range gaugeValueMap {
+		o := metrics.oids[oid]
+		o.collected, o.lastGauge = true, value
+		if metrics.firstRun {
+			continue
+		}
+		metrics.gauges[o.name].WithLabelValues(o.labels...).Set(o.convert(float64(value)))
+
+		raw := value
+		sample := newSample(0, 0)
+		sample.Gauge = &raw
+		sample.Scaled = o.scaled(float64(value))
+		o.interval.Samples = append(o.interval.Samples, sample)
+		points = append(points, metrics.point(o, o.convert(float64(value)), sample))
+	}
+
+	// Aggregates are only archived, since Prometheus can sum the series of
+	// the individual interfaces.
+	for _, agg := range metrics.aggregates {
+		if metrics.firstRun {
+			break
+		}
+		var increase, value uint64
+		for _, oid := range agg.oids {
+			increase += increases[oid]
+			value += metrics.oids[oid].previousValue
+		}
+		sample := newSample(increase, value)
+		// Members share the scale of their metric, and counters have no
+		// offset, so the sum of the scaled increases is the scaled sum.
+		sample.Scaled = metrics.oids[agg.oids[0]].scaled(float64(increase))
+		agg.interval.Samples = append(agg.interval.Samples, sample)
 	}
 
 	if metrics.firstRun {
 		metrics.firstRun = false
+	} else {
+		for _, e := range metrics.Exporters {
+			e.Export(points)
+		}
 	}
 
 	return nil
 }
 
-// Write collects JSON data for all OIDs and then writes the result to an archive.
-func (metrics *Metrics) Write(dataDir string) {
-	var jsonData []byte
+// updateSpeeds gets the ifHighSpeed of the interfaces with rates. Failures are
+// logged, and the utilization of interfaces without a known speed is not
+// updated.
+func (metrics *Metrics) updateSpeeds(client snmp.Client) {
+	// Failures are also only retried after SpeedInterval.
+	metrics.speedsUpdated = time.Now()
+	if len(metrics.speeds) == 0 {
+		return
+	}
+	oids := make([]string, 0, len(metrics.speeds))
+	for oid := range metrics.speeds {
+		oids = append(oids, oid)
+	}
+	speeds, err := getSpeeds(client, oids)
+	if err != nil {
+		metrics.speedLog.Log(metrics.logger, slog.LevelWarn, err.Error(), "failed to GET interface speeds", logging.Err(err))
+		return
+	}
+	for oid := range metrics.speeds {
+		metrics.speeds[oid] = speeds[oid]
+	}
+}
+
+// setRate sets the rate and, if the speed of the interface is known, the
+// utilization gauges for the octet counter o.
+func (metrics *Metrics) setRate(o *oid, bps float64) {
+	metrics.rates.WithLabelValues(o.rateLabels...).Set(bps)
+	if speed := metrics.speeds[o.speedOid]; speed > 0 {
+		metrics.utilization.WithLabelValues(o.rateLabels...).Set(
+			bps / (float64(speed) * 1e6) * 100)
+	}
+}
+
+// Write collects the samples for all OIDs and then writes the result to an
+// archive in sink for each of the configured Formats. Archives are written
+// without holding the lock, so that a slow sink doesn't delay collections
+// running concurrently. No archives are written for an interval without
+// samples e.g., because every collection failed. Archives which fail to be
+// written are kept, and retried by the next Write(), before the new ones.
+func (metrics *Metrics) Write(sink archive.Sink) error {
+	// Writes are serialized, so that archives are written in order.
+	metrics.writeMutex.Lock()
+	defer metrics.writeMutex.Unlock()
+
+	models, start, end, sequence := metrics.takeIntervals()
+
+	archives := metrics.unwritten
+	metrics.unwritten = nil
+	var writeErr error
+	formats := metrics.Formats
+	if len(models) == 0 {
+		metrics.logger.Warn("no samples were collected, skipping the archives")
+		formats = nil
+	}
+	for _, format := range formats {
+		a, err := metrics.encodeArchive(format, models, start, end, sequence)
+		if err != nil {
+			metrics.writeFailed(format, err)
+			writeErr = err
+			continue
+		}
+		archives = append(archives, a...)
+	}
+
+	for i, a := range archives {
+		err := sink.Write(a.name, a.data)
+		if err != nil {
+			// The remaining archives are kept in order, so that manifests
+			// are still written after their archive.
+			metrics.writeFailed(a.format, err)
+			metrics.keepUnwritten(archives[i:])
+			return err
+		}
+	}
+	return writeErr
+}
+
+// takeIntervals returns the models of the current interval with samples, its
+// start and end times and sequence number, and starts the next interval. If
+// there are no samples, no models are returned and the interval continues.
+func (metrics *Metrics) takeIntervals() ([]archive.Model, time.Time, time.Time, int) {
+	var models []archive.Model
 	var endTimeUnix, startTimeUnix int64
 
 	// Set a lock to avoid a race between the collecting and writing of metrics.
@@ -226,10 +515,13 @@ func (metrics *Metrics) Write(dataDir string) {
 	defer metrics.mutex.Unlock()
 
 	for oid, values := range metrics.oids {
-		data := archive.MustMarshalJSON(values.interval)
-		jsonData = append(jsonData, data...)
-		// Adds a newline to the end of the JSON data to effectively create JSONL.
-		jsonData = append(jsonData, '\n')
+		// OIDs have no samples if every collection of the interval failed.
+		if len(values.interval.Samples) == 0 {
+			continue
+		}
+		model := values.interval
+		model.Device = metrics.device
+		models = append(models, model)
 		// Capture the value of the frist and final Unix timestamp of each
 		// sample set. We will use these values to calculate the text of the
 		// start and end time for the file being written. There is an
@@ -250,78 +542,358 @@ func (metrics *Metrics) Write(dataDir string) {
 		// interval.
 		metrics.oids[oid].interval.Samples = []archive.Sample{}
 	}
+	for _, agg := range metrics.aggregates {
+		if len(agg.interval.Samples) == 0 {
+			continue
+		}
+		model := agg.interval
+		model.Device = metrics.device
+		models = append(models, model)
+		agg.interval.Samples = []archive.Sample{}
+	}
+	if len(models) == 0 {
+		return nil, time.Time{}, time.Time{}, metrics.sequence
+	}
+
+	sequence := metrics.sequence
+	metrics.sequence++
+	return models, time.Unix(startTimeUnix, 0), time.Unix(endTimeUnix, 0), sequence
+}
+
+// writeFailed logs and records the failure to write a format archive.
+func (metrics *Metrics) writeFailed(format archive.Format, err error) {
+	metrics.logger.Error("failed to write archive", "format", format, logging.Err(err))
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.recordError(fmt.Errorf("failed to write %v archive: %v", format, err))
+}
+
+// keepUnwritten keeps archives to be retried by the next Write(), dropping the
+// oldest ones beyond maxUnwritten. The caller must hold the writeMutex.
+func (metrics *Metrics) keepUnwritten(archives []encodedArchive) {
+	metrics.unwritten = append(metrics.unwritten, archives...)
+	if dropped := len(metrics.unwritten) - maxUnwritten; dropped > 0 {
+		metrics.logger.Error("too many unwritten archives, dropping the oldest", "dropped", dropped)
+		metrics.unwritten = metrics.unwritten[dropped:]
+	}
+}
 
-	start := time.Unix(startTimeUnix, 0)
-	end := time.Unix(endTimeUnix, 0)
-	archivePath := archive.GetPath(start, end, dataDir, metrics.hostname)
-	err := archive.Write(archivePath, jsonData)
+// encodeArchive encodes models in format, returning the archive followed by
+// its manifest, if Manifests are enabled.
+func (metrics *Metrics) encodeArchive(format archive.Format, models []archive.Model, start, end time.Time, sequence int) ([]encodedArchive, error) {
+	archiveName, err := metrics.Namer.Name(archive.NameData{
+		Hostname: metrics.hostname,
+		Target:   metrics.target,
+		Start:    start,
+		End:      end,
+		Sequence: sequence,
+		Codec:    format,
+	})
 	if err != nil {
-		rtx.Must(err, "Failed to write archive")
+		return nil, err
+	}
+	data, err := archive.Marshal(format, models)
+	if err != nil {
+		return nil, err
+	}
+	archives := []encodedArchive{{name: archiveName, format: format, data: data}}
+	if !metrics.Manifests {
+		return archives, nil
 	}
+
+	// The manifest is written after the archive, so that its presence implies
+	// that the archive was written completely.
+	manifest := archive.NewManifest(archiveName, format, data, models, start, end,
+		prometheusx.GitShortCommit, metrics.configHash)
+	manifest.Device = metrics.device
+	return append(archives, encodedArchive{
+		name:   archiveName + archive.ManifestSuffix,
+		format: format,
+		data:   manifest.MustMarshalJSON(),
+	}), nil
 }
 
-// New creates a new metrics.Metrics struct with various OID maps initialized.
-func New(client snmp.Client, config config.Config, target string, hostname string) *Metrics {
-	machine := hostname[:5]
-	ifaces := mustGetIfaces(client, machine)
+// labelValues returns the values of the optional labels names for an
+// interface with the given scope.
+func (metrics *Metrics) labelValues(names []string, scope string) []string {
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "scope":
+			values = append(values, scope)
+		case "target":
+			values = append(values, metrics.target)
+		case "machine":
+			// The legacy machine label of the collection metrics is the
+			// hostname.
+			if metrics.labels.Legacy {
+				values = append(values, metrics.hostname)
+			} else {
+				values = append(values, metrics.machine)
+			}
+		case "site":
+			values = append(values, metrics.labels.Site)
+		}
+	}
+	return values
+}
 
-	collectDuration = promauto.NewHistogramVec(
+// Interfaces returns the switch interfaces selected by New(), in the order of
+// the config.Interfaces which selected them.
+func (metrics *Metrics) Interfaces() []Interface {
+	interfaces := make([]Interface, 0, len(metrics.ifaces))
+	for _, i := range metrics.ifaces {
+		interfaces = append(interfaces, Interface{
+			Scope:   i.scope,
+			IfIndex: i.index,
+			IfAlias: i.ifAlias,
+			IfDescr: i.ifDescr,
+		})
+	}
+	return interfaces
+}
+
+// SetDevice records the detected switch d in archives, their manifests and
+// the disco_switch_info metric.
+func (metrics *Metrics) SetDevice(d archive.Device) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.device = &d
+	metrics.switchInfo.Reset()
+	metrics.switchInfo.WithLabelValues(metrics.target, d.Vendor, d.Model, d.SysObjectID, d.Profile).Set(1)
+}
+
+// collector implements prometheus.Collector for the Prometheus metrics of a
+// Metrics, whose own Collect() method scrapes the switch.
+type collector struct {
+	metrics *Metrics
+}
+
+// Describe sends the descriptors of all the Prometheus metrics.
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, v := range c.vecs() {
+		v.Describe(ch)
+	}
+}
+
+// Collect sends the current values of all the Prometheus metrics.
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range c.vecs() {
+		v.Collect(ch)
+	}
+}
+
+// vecs returns the Prometheus metric vectors of the Metrics. They are only
+// created by New(), so no lock is needed.
+func (c collector) vecs() []prometheus.Collector {
+	m := c.metrics
+	vecs := []prometheus.Collector{m.collectDuration, m.collectErrors, m.switchInfo, m.rates, m.utilization}
+	for _, v := range m.prom {
+		vecs = append(vecs, v)
+	}
+	for _, v := range m.gauges {
+		vecs = append(vecs, v)
+	}
+	return vecs
+}
+
+// Collector returns a prometheus.Collector for the Prometheus metrics of the
+// Metrics, which may be registered with the registry of the exposed handler.
+func (metrics *Metrics) Collector() prometheus.Collector {
+	return collector{metrics: metrics}
+}
+
+// Registry returns the private registry of the Metrics, on which only its
+// Collector() is registered.
+func (metrics *Metrics) Registry() *prometheus.Registry {
+	return metrics.registry
+}
+
+// New creates a new metrics.Metrics struct with various OID maps initialized.
+// The machine name is used to select the machine's switch interface. The
+// Prometheus metrics have the optional labels configured by labels.
+//
+// The Prometheus metrics are registered on a private Registry(), rather than
+// the default registry, so New may be called more than once.
+func New(client snmp.Client, config config.Config, target string, hostname string, machine string, labels Labels) *Metrics {
+	logger := slog.Default().With(logging.TargetKey, target, logging.HostnameKey, hostname)
+	ifaces := mustGetIfaces(logger, client, config.InterfaceSelectors(), ifaceVars{
+		Machine:  machine,
+		Hostname: hostname,
+		Target:   target,
+	})
+
+	collectDuration := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "disco_collect_duration_seconds",
 			Help:    "SNMP collection duration distribution.",
 			Buckets: []float64{0.1, 0.3, 0.5, 1, 3, 5},
 		},
-		[]string{"machine"},
+		labels.collectNames(),
 	)
 
-	collectErrors = promauto.NewCounterVec(
+	collectErrors := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "disco_collect_errors_total",
 			Help: "Total number SNMP collection errors.",
 		},
-		[]string{"machine"},
+		labels.collectNames(),
+	)
+
+	switchInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "disco_switch_info",
+			Help: "The vendor, model, sysObjectID and metrics profile of the switch. Always 1.",
+		},
+		[]string{"target", "vendor", "model", "sysObjectID", "profile"},
+	)
+
+	rateLabels := append(labels.rateNames(), "ifAlias", "interface", "direction")
+	rates := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "disco_interface_bits_per_second",
+			Help: "Traffic of each interface over the last collection interval, in bits per second.",
+		},
+		rateLabels,
+	)
+	utilization := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "disco_interface_utilization_percent",
+			Help: "Traffic of each interface over the last collection interval, as a percentage of its ifHighSpeed.",
+		},
+		rateLabels,
 	)
 
 	m := &Metrics{
-		firstRun: true,
-		hostname: hostname,
-		machine:  machine,
-		oids:     make(map[string]*oid),
-		prom:     make(map[string]*prometheus.CounterVec),
+		firstRun:   true,
+		hostname:   hostname,
+		machine:    machine,
+		oids:       make(map[string]*oid),
+		aggregates: make(map[string]*aggregate),
+		ifaces:     ifaces,
+		prom:       make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		target:     target,
+		configHash: config.Hash(),
+		Formats:    []archive.Format{archive.JSONL},
+		Namer:      archive.MustNewNamer(archive.DefaultNameTemplate, time.UTC),
+
+		collectDuration: collectDuration,
+		collectErrors:   collectErrors,
+		switchInfo:      switchInfo,
+		rates:           rates,
+		utilization:     utilization,
+		speeds:          make(map[string]uint64),
+		SpeedInterval:   DefaultSpeedInterval,
+		labels:          labels,
+		registry:        prometheus.NewRegistry(),
+		metricInfos:     newMetricInfos(config.Redacted()),
+		logger:          logger,
+		collectLog:      logging.NewLimiter(collectLogInterval),
+		speedLog:        logging.NewLimiter(speedLogInterval),
+	}
+	m.collectLabels = m.labelValues(labels.collectNames(), "")
+	switchLabels := append(append([]string{}, labels.switchNames()...), "ifAlias", "interface")
+	m.switchLabels = switchLabels
+
+	// rates holds the octet counter used for the rate of each interface and
+	// direction.
+	type rate struct {
+		oid *oid
+		hc  bool
 	}
+	rateOids := map[string]rate{}
 
+	resolver := config.Resolver()
 	for _, metric := range config.Metrics {
-		discoNames := map[string]string{
-			"machine": metric.MlabMachineName,
-			"uplink":  metric.MlabUplinkName,
-		}
-		for scope, values := range ifaces {
-			oidStr := createOID(metric.OidStub, values["iface"])
+		for _, i := range ifaces {
+			archiveName := metric.ArchiveName(i.scope)
+			if archiveName == "" {
+				continue
+			}
+			oidStr := createOID(metric.OidStub, i.index)
+			// Gauges such as temperatures can't be meaningfully summed.
+			if i.aggregate && !metric.IsGauge() {
+				agg, ok := m.aggregates[archiveName]
+				if !ok {
+					agg = &aggregate{
+						interval: archive.Model{
+							Experiment: target,
+							Hostname:   hostname,
+							Metric:     archiveName,
+							Unit:       metric.Unit,
+							Samples:    []archive.Sample{},
+						},
+					}
+					m.aggregates[archiveName] = agg
+				}
+				agg.oids = append(agg.oids, oidStr)
+			}
+			archiveName += i.suffix
 			o := &oid{
 				name:    metric.Name,
-				scope:   scope,
-				ifAlias: values["ifAlias"],
-				ifDescr: values["ifDescr"],
+				scope:   i.scope,
+				ifAlias: i.ifAlias,
+				ifDescr: i.ifDescr,
 				interval: archive.Model{
 					Experiment: target,
 					Hostname:   hostname,
-					Metric:     discoNames[scope],
+					Metric:     archiveName,
+					OID:        resolver.Name(oidStr),
+					Unit:       metric.Unit,
 					Samples:    []archive.Sample{},
 				},
+				gauge:  metric.IsGauge(),
+				scale:  metric.Scale,
+				offset: metric.Offset,
+				labels: append(m.labelValues(labels.switchNames(), i.scope), i.ifAlias, i.ifDescr),
 			}
 			m.oids[oidStr] = o
+			logger.Debug("collecting OID", logging.MetricKey, metric.Name, logging.OIDKey, o.interval.OID,
+				"archive", archiveName, "scope", i.scope, "interface", i.ifDescr)
+
+			if octets, ok := octetsOidStubs[metric.OidStub]; ok {
+				key := i.scope + "/" + i.index + "/" + octets.direction
+				prev, ok := rateOids[key]
+				if ok && (prev.hc || !octets.hc) {
+					continue
+				}
+				if ok {
+					prev.oid.direction, prev.oid.speedOid, prev.oid.rateLabels = "", "", nil
+				}
+				o.direction = octets.direction
+				o.rateLabels = append(m.labelValues(labels.rateNames(), i.scope), i.ifAlias, i.ifDescr, o.direction)
+				o.speedOid = createOID(ifHighSpeedOidStub, i.index)
+				m.speeds[o.speedOid] = 0
+				rateOids[key] = rate{oid: o, hc: octets.hc}
+			}
+		}
+		help := metric.Description
+		if metric.Unit != "" {
+			help = fmt.Sprintf("%v Unit: %v.", help, metric.Unit)
 		}
-		m.prom[metric.Name] = promauto.NewCounterVec(
+		if metric.IsGauge() {
+			m.gauges[metric.Name] = prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: metric.Name,
+					Help: help,
+				},
+				switchLabels,
+			)
+			continue
+		}
+		m.prom[metric.Name] = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: metric.Name,
-				Help: metric.Description,
-			},
-			[]string{
-				"ifAlias",
-				"interface",
+				Help: help,
 			},
+			switchLabels,
 		)
 	}
 
+	m.updateSpeeds(client)
+
+	rtx.Must(m.registry.Register(m.Collector()), "Failed to register Prometheus metrics")
 	return m
 }
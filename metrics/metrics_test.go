@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -170,8 +172,10 @@ func (m *mockSwitchClient) Get(oids []string) (result *gosnmp.SnmpPacket, err er
 		}
 	}
 
-	// len(oids) will be greater than one when looking up metrics.
+	// len(oids) will be greater than one when looking up metrics, or the
+	// speeds of interfaces when there is no run.
 	if len(oids) > 1 {
+		packet = &gosnmp.SnmpPacket{}
 		if m.run == 1 {
 			packet = &snmpPacketMetricsRun1
 		}
@@ -184,16 +188,10 @@ func (m *mockSwitchClient) Get(oids []string) (result *gosnmp.SnmpPacket, err er
 }
 
 func Test_New(t *testing.T) {
-	// Each run of New() registers metrics in a global default prometheus
-	// registry. This resets the default registry so that we can run New()
-	// serveral times without getting panics about trying to registser the same
-	// metric twice.
-	prometheus.DefaultRegisterer = prometheus.NewRegistry()
-
 	s := &mockSwitchClient{
 		err: nil,
 	}
-	m := New(s, c, target, hostname)
+	m := New(s, c, target, hostname, "mlab2", Labels{})
 
 	var expectedMetricsOIDs = map[string]*oid{
 		ifOutDiscardsMachineOID: {
@@ -206,8 +204,10 @@ func Test_New(t *testing.T) {
 				Experiment: "s1-abc0t.measurement-lab.org",
 				Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
 				Metric:     "switch.discards.local.tx",
+				OID:        "IF-MIB::ifOutDiscards.524",
 				Samples:    []archive.Sample{},
 			},
+			labels: []string{"mlab2", "xe-0/0/12"},
 		},
 		ifOutDiscardsUplinkOID: {
 			name:          "ifOutDiscards",
@@ -219,8 +219,10 @@ func Test_New(t *testing.T) {
 				Experiment: "s1-abc0t.measurement-lab.org",
 				Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
 				Metric:     "switch.discards.uplink.tx",
+				OID:        "IF-MIB::ifOutDiscards.568",
 				Samples:    []archive.Sample{},
 			},
+			labels: []string{"uplink-10g", "xe-0/0/45"},
 		},
 		ifHCInOctetsMachineOID: {
 			name:          "ifHCInOctets",
@@ -232,8 +234,13 @@ func Test_New(t *testing.T) {
 				Experiment: "s1-abc0t.measurement-lab.org",
 				Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
 				Metric:     "switch.octets.local.rx",
+				OID:        "IF-MIB::ifHCInOctets.524",
 				Samples:    []archive.Sample{},
 			},
+			labels:     []string{"mlab2", "xe-0/0/12"},
+			direction:  "rx",
+			speedOid:   ".1.3.6.1.2.1.31.1.1.1.15.524",
+			rateLabels: []string{"machine", "mlab2", "xe-0/0/12", "rx"},
 		},
 		ifHCInOctetsUplinkOID: {
 			name:          "ifHCInOctets",
@@ -245,8 +252,13 @@ func Test_New(t *testing.T) {
 				Experiment: "s1-abc0t.measurement-lab.org",
 				Hostname:   "mlab2-abc0t.mlab-sandbox.measurement-lab.org",
 				Metric:     "switch.octets.uplink.rx",
+				OID:        "IF-MIB::ifHCInOctets.568",
 				Samples:    []archive.Sample{},
 			},
+			labels:     []string{"uplink-10g", "xe-0/0/45"},
+			direction:  "rx",
+			speedOid:   ".1.3.6.1.2.1.31.1.1.1.15.568",
+			rateLabels: []string{"uplink", "uplink-10g", "xe-0/0/45", "rx"},
 		},
 	}
 
@@ -269,7 +281,6 @@ func Test_New(t *testing.T) {
 }
 
 func Test_Collect(t *testing.T) {
-	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
 	var expectedValues = map[string]map[string]uint64{
 		ifOutDiscardsMachineOID: {
@@ -298,7 +309,7 @@ func Test_Collect(t *testing.T) {
 		err: nil,
 		run: 1,
 	}
-	m := New(s1, c, target, hostname)
+	m := New(s1, c, target, hostname, "mlab2", Labels{})
 	m.Collect(s1, c)
 
 	for oid := range m.oids {
@@ -359,10 +370,9 @@ func Test_getOidsInt64InvalidOID(t *testing.T) {
 }
 
 func Test_CollectWithSnmpError(t *testing.T) {
-	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
 	s := &mockSwitchClient{}
-	m := New(s, c, target, hostname)
+	m := New(s, c, target, hostname, "mlab2", Labels{})
 
 	sErr := &mockSwitchClient{
 		err: fmt.Errorf("An SNMP error occured: %s", "error"),
@@ -375,13 +385,12 @@ func Test_CollectWithSnmpError(t *testing.T) {
 }
 
 func Test_Write(t *testing.T) {
-	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
 	s1 := &mockSwitchClient{
 		err: nil,
 		run: 1,
 	}
-	m := New(s1, c, target, hostname)
+	m := New(s1, c, target, hostname, "mlab2", Labels{})
 	m.CollectStart = time.Now()
 	m.Collect(s1, c)
 
@@ -391,13 +400,13 @@ func Test_Write(t *testing.T) {
 	}
 	m.Collect(s2, c)
 
+	dir := t.TempDir()
 	end := time.Now()
 	start := end.Add(time.Duration(10) * -time.Second)
-	archivePath := archive.GetPath(start, end, "/tmp/disco", hostname)
+	archivePath := archive.GetPath(start, end, dir, hostname)
 	dirPath := path.Dir(archivePath)
 
-	m.Write("/tmp/disco")
-	defer os.RemoveAll("/tmp/disco")
+	m.Write(archive.NewFileSink(dir))
 
 	a, err := ioutil.ReadDir(dirPath)
 	rtx.Must(err, "Could not read test archive directory")
@@ -407,3 +416,180 @@ func Test_Write(t *testing.T) {
 	}
 	os.RemoveAll(fmt.Sprintf("%04d", time.Now().Year()))
 }
+
+// failingSink fails to write until fail is false, and records the names of
+// the archives it wrote.
+type failingSink struct {
+	fail  bool
+	names []string
+}
+
+func (f *failingSink) Write(name string, data []byte) error {
+	if f.fail {
+		return fmt.Errorf("object store unavailable")
+	}
+	f.names = append(f.names, name)
+	return nil
+}
+
+func Test_WriteRetry(t *testing.T) {
+	s1 := &mockSwitchClient{
+		err: nil,
+		run: 1,
+	}
+	m := New(s1, c, target, hostname, "mlab2", Labels{})
+	m.Manifests = true
+	m.CollectStart = time.Now()
+	m.Collect(s1, c)
+	m.Collect(&mockSwitchClient{run: 2}, c)
+
+	sink := &failingSink{fail: true}
+	if err := m.Write(sink); err == nil {
+		t.Error("Expected an error writing to a failing sink, but got nil")
+	}
+	if len(m.State().Errors) != 1 {
+		t.Errorf("Expected the write error to be recorded, but got: %v", m.State().Errors)
+	}
+
+	// The next write retries the unwritten archive and manifest before
+	// writing the new ones.
+	m.Collect(&mockSwitchClient{run: 2}, c)
+	sink.fail = false
+	rtx.Must(m.Write(sink), "Failed to write archives")
+	if len(sink.names) != 4 {
+		t.Fatalf("Expected 2 archives and 2 manifests, but got: %v", sink.names)
+	}
+	if sink.names[1] != sink.names[0]+archive.ManifestSuffix ||
+		sink.names[3] != sink.names[2]+archive.ManifestSuffix {
+		t.Errorf("Expected each archive followed by its manifest, but got: %v", sink.names)
+	}
+	if len(m.unwritten) != 0 {
+		t.Errorf("Expected no unwritten archives, but got: %d", len(m.unwritten))
+	}
+}
+
+func Test_WriteWithoutSamples(t *testing.T) {
+	s1 := &mockSwitchClient{
+		err: nil,
+		run: 1,
+	}
+	m := New(s1, c, target, hostname, "mlab2", Labels{})
+	sink := &failingSink{}
+
+	// Before the second collection, or when every collection of the interval
+	// failed, there are no samples to write.
+	rtx.Must(m.Write(sink), "Failed to write without collections")
+	m.CollectStart = time.Now()
+	m.Collect(s1, c)
+	m.Collect(&mockSwitchClient{run: 2, err: fmt.Errorf("timeout")}, c)
+	rtx.Must(m.Write(sink), "Failed to write without samples")
+	if len(sink.names) != 0 {
+		t.Fatalf("Expected no archives without samples, but got: %v", sink.names)
+	}
+
+	m.Collect(&mockSwitchClient{run: 2}, c)
+	rtx.Must(m.Write(sink), "Failed to write archives")
+	if len(sink.names) != 1 {
+		t.Errorf("Expected one archive, but got: %v", sink.names)
+	}
+}
+
+func Test_WriteFormats(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "TestWriteFormats")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	s1 := &mockSwitchClient{
+		err: nil,
+		run: 1,
+	}
+	m := New(s1, c, target, hostname, "mlab2", Labels{})
+	m.Formats = []archive.Format{archive.JSONL, archive.Parquet}
+	m.Manifests = true
+	device := archive.Device{Vendor: "juniper", SysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82", Profile: "juniper"}
+	m.SetDevice(device)
+	m.CollectStart = time.Now()
+	m.Collect(s1, c)
+
+	s2 := &mockSwitchClient{
+		err: nil,
+		run: 2,
+	}
+	m.Collect(s2, c)
+
+	rtx.Must(m.Write(archive.NewFileSink(dir)), "Failed to write archives")
+
+	start := time.Unix(m.CollectStart.Unix(), 0)
+	for _, f := range m.Formats {
+		name := archive.GetName(start, start, hostname, f)
+		data, err := ioutil.ReadFile(dir + "/" + name)
+		if err != nil {
+			t.Errorf("Expected archive %v to exist: %v", name, err)
+			continue
+		}
+
+		manifest := archive.Manifest{}
+		manifestData, err := ioutil.ReadFile(dir + "/" + name + archive.ManifestSuffix)
+		rtx.Must(err, "Could not read archive manifest")
+		rtx.Must(json.Unmarshal(manifestData, &manifest), "Could not unmarshal archive manifest")
+		if !manifest.Verify(data) {
+			t.Errorf("Manifest for %v does not match its archive", name)
+		}
+		if manifest.Records != 4 || manifest.Samples != 4 {
+			t.Errorf("Expected 4 records and 4 samples, but got: %v and %v", manifest.Records, manifest.Samples)
+		}
+		if manifest.ConfigHash != c.Hash() {
+			t.Errorf("Expected config hash %v, but got: %v", c.Hash(), manifest.ConfigHash)
+		}
+		if manifest.Device == nil || *manifest.Device != device {
+			t.Errorf("Expected device %v, but got: %v", device, manifest.Device)
+		}
+		if f != archive.JSONL {
+			continue
+		}
+		// The device is also recorded in the archive itself.
+		var model archive.Model
+		rtx.Must(json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &model), "Could not unmarshal archive record")
+		if model.Device == nil || *model.Device != device {
+			t.Errorf("Expected archive device %v, but got: %v", device, model.Device)
+		}
+	}
+}
+
+func Test_NewRegistry(t *testing.T) {
+	// New() must not register anything globally, so that it can be called
+	// more than once.
+	var metrics []*Metrics
+	for i := 0; i < 2; i++ {
+		s1 := &mockSwitchClient{run: 1}
+		m := New(s1, c, target, hostname, "mlab2", Labels{})
+		m.SetDevice(archive.Device{Vendor: "juniper", Profile: "generic"})
+		m.CollectStart = time.Now()
+		m.Collect(s1, c)
+		m.Collect(&mockSwitchClient{run: 2}, c)
+		metrics = append(metrics, m)
+	}
+
+	for _, m := range metrics {
+		families, err := m.Registry().Gather()
+		rtx.Must(err, "Failed to gather metrics")
+		names := []string{}
+		for _, f := range families {
+			names = append(names, f.GetName())
+		}
+		expect := []string{"disco_collect_duration_seconds", "disco_interface_bits_per_second",
+			"disco_switch_info", "ifHCInOctets", "ifOutDiscards"}
+		if !reflect.DeepEqual(names, expect) {
+			t.Errorf("Expected metrics %v, but got: %v", expect, names)
+		}
+	}
+
+	// The Collector can also be registered with another registry, such as
+	// the one of the exposed handler.
+	r := prometheus.NewRegistry()
+	rtx.Must(r.Register(metrics[0].Collector()), "Failed to register collector")
+	if err := r.Register(metrics[1].Collector()); err == nil {
+		t.Errorf("Expected registering a second collector with the same metrics to fail")
+	}
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/m-lab/disco/config"
+)
+
+// maxRecentErrors is the number of errors kept for State.
+const maxRecentErrors = 20
+
+// MetricInfo is a configured metric.
+type MetricInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// OID is the symbolic name of the OID stub, if it is known, and OidStub
+	// is the numeric OID stub.
+	OID          string            `json:"oid"`
+	OidStub      string            `json:"oidStub"`
+	Type         string            `json:"type"`
+	Unit         string            `json:"unit,omitempty"`
+	Scale        float64           `json:"scale,omitempty"`
+	Offset       float64           `json:"offset,omitempty"`
+	ArchiveNames map[string]string `json:"archiveNames"`
+}
+
+// OIDState is the state of a collected OID.
+type OIDState struct {
+	// OID is the numeric OID, and Name its symbolic name, if it is known.
+	OID     string `json:"oid"`
+	Name    string `json:"name"`
+	Metric  string `json:"metric"`
+	Archive string `json:"archive"`
+	Scope   string `json:"scope"`
+	IfAlias string `json:"ifAlias"`
+	IfDescr string `json:"ifDescr"`
+	Type    string `json:"type"`
+	// LastCounter is the last value of a counter, and LastDelta its increase
+	// since the previous collection. LastGauge is the last value of a gauge.
+	// They are omitted until they are collected.
+	LastCounter *uint64 `json:"lastCounter,omitempty"`
+	LastDelta   *uint64 `json:"lastDelta,omitempty"`
+	LastGauge   *int64  `json:"lastGauge,omitempty"`
+	// PendingSamples is the number of samples of the current interval, which
+	// will be archived by the next Write().
+	PendingSamples int `json:"pendingSamples"`
+}
+
+// AggregateState is the state of an aggregate of several OIDs.
+type AggregateState struct {
+	Archive        string   `json:"archive"`
+	OIDs           []string `json:"oids"`
+	PendingSamples int      `json:"pendingSamples"`
+}
+
+// Error is a recent error of the collection or archiving of metrics.
+type Error struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// State is a snapshot of a Metrics, for debugging: the interfaces and OIDs
+// selected by New(), the last collected values and the samples waiting to be
+// archived.
+type State struct {
+	Target     string           `json:"target"`
+	Hostname   string           `json:"hostname"`
+	Interfaces []Interface      `json:"interfaces"`
+	Metrics    []MetricInfo     `json:"metrics"`
+	OIDs       []OIDState       `json:"oids"`
+	Aggregates []AggregateState `json:"aggregates"`
+	// Errors are the most recent errors, oldest first.
+	Errors []Error `json:"errors"`
+}
+
+// newMetricInfos returns the MetricInfos of the metrics of c.
+func newMetricInfos(c config.Config) []MetricInfo {
+	resolver := c.Resolver()
+	infos := make([]MetricInfo, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		typ := config.Counter
+		if m.IsGauge() {
+			typ = config.Gauge
+		}
+		archiveNames := map[string]string{}
+		for _, i := range c.InterfaceSelectors() {
+			if name := m.ArchiveName(i.Name); name != "" {
+				archiveNames[i.Name] = name
+			}
+		}
+		infos = append(infos, MetricInfo{
+			Name:         m.Name,
+			Description:  m.Description,
+			OID:          resolver.Name(m.OidStub),
+			OidStub:      m.OidStub,
+			Type:         typ,
+			Unit:         m.Unit,
+			Scale:        m.Scale,
+			Offset:       m.Offset,
+			ArchiveNames: archiveNames,
+		})
+	}
+	return infos
+}
+
+// recordError records err as one of the recent errors. The caller must hold
+// the mutex.
+func (metrics *Metrics) recordError(err error) {
+	metrics.errors = append(metrics.errors, Error{Time: time.Now(), Message: err.Error()})
+	if len(metrics.errors) > maxRecentErrors {
+		metrics.errors = metrics.errors[len(metrics.errors)-maxRecentErrors:]
+	}
+}
+
+// State returns a snapshot of the Metrics.
+func (metrics *Metrics) State() State {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	s := State{
+		Target:     metrics.target,
+		Hostname:   metrics.hostname,
+		Interfaces: metrics.Interfaces(),
+		Metrics:    metrics.metricInfos,
+		OIDs:       make([]OIDState, 0, len(metrics.oids)),
+		Aggregates: make([]AggregateState, 0, len(metrics.aggregates)),
+		Errors:     append([]Error{}, metrics.errors...),
+	}
+	for oidStr, o := range metrics.oids {
+		os := OIDState{
+			OID:            oidStr,
+			Name:           o.interval.OID,
+			Metric:         o.name,
+			Archive:        o.interval.Metric,
+			Scope:          o.scope,
+			IfAlias:        o.ifAlias,
+			IfDescr:        o.ifDescr,
+			Type:           config.Counter,
+			PendingSamples: len(o.interval.Samples),
+		}
+		switch {
+		case o.gauge:
+			os.Type = config.Gauge
+			if o.collected {
+				gauge := o.lastGauge
+				os.LastGauge = &gauge
+			}
+		case o.collected:
+			counter := o.previousValue
+			os.LastCounter = &counter
+			if o.hasDelta {
+				delta := o.lastDelta
+				os.LastDelta = &delta
+			}
+		}
+		s.OIDs = append(s.OIDs, os)
+	}
+	sort.Slice(s.OIDs, func(i, j int) bool {
+		if s.OIDs[i].Archive != s.OIDs[j].Archive {
+			return s.OIDs[i].Archive < s.OIDs[j].Archive
+		}
+		return s.OIDs[i].OID < s.OIDs[j].OID
+	})
+	for _, agg := range metrics.aggregates {
+		s.Aggregates = append(s.Aggregates, AggregateState{
+			Archive:        agg.interval.Metric,
+			OIDs:           append([]string{}, agg.oids...),
+			PendingSamples: len(agg.interval.Samples),
+		})
+	}
+	sort.Slice(s.Aggregates, func(i, j int) bool { return s.Aggregates[i].Archive < s.Aggregates[j].Archive })
+	return s
+}
+
+// StateHandler returns a read-only handler serving the State() as JSON.
+func (metrics *Metrics) StateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(metrics.State())
+	})
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/disco/config"
+	"github.com/m-lab/go/rtx"
+)
+
+func Test_State(t *testing.T) {
+	const rxPowerOidStub = ".1.3.6.1.4.1.2636.3.60.1.1.1.1.5"
+	client := newTableClient(
+		[4]string{"502", "mlab2", "xe-0/0/11", "xe-0/0/11"},
+		[4]string{"568", "uplink-1", "xe-0/0/45", "xe-0/0/45"},
+	)
+	cfg := config.Config{
+		Interfaces: []config.Interface{{Name: "machine", IfAlias: "{{.Machine}}"}},
+		Metrics: []config.Metric{
+			{
+				Name:         "ifHCInOctets",
+				OidStub:      ifHCInOctetsOidStub,
+				ArchiveNames: map[string]string{"machine": "switch.octets.local.rx"},
+			},
+			{
+				Name:         "rxPower",
+				OidStub:      rxPowerOidStub,
+				Type:         config.Gauge,
+				Scale:        0.01,
+				Unit:         "dBm",
+				ArchiveNames: map[string]string{"machine": "switch.rxpower.local"},
+			},
+		},
+	}
+	m := New(client, cfg, target, hostname, "mlab2", Labels{})
+
+	s := m.State()
+	expectIfaces := []Interface{{Scope: "machine", IfIndex: "502", IfAlias: "mlab2", IfDescr: "xe-0/0/11"}}
+	if !reflect.DeepEqual(s.Interfaces, expectIfaces) {
+		t.Errorf("Expected interfaces %v, but got: %v", expectIfaces, s.Interfaces)
+	}
+	if len(s.Metrics) != 2 || s.Metrics[1].Type != config.Gauge || s.Metrics[1].ArchiveNames["machine"] != "switch.rxpower.local" {
+		t.Errorf("Unexpected metrics: %+v", s.Metrics)
+	}
+	if len(s.OIDs) != 2 || s.OIDs[0].LastCounter != nil || s.OIDs[1].LastGauge != nil {
+		t.Errorf("Expected 2 OIDs without values, but got: %+v", s.OIDs)
+	}
+
+	for run, values := range [][2]int{{100, -250}, {150, -300}, {175, -200}} {
+		client.setCounter(ifHCInOctetsOidStub+".502", uint64(values[0]))
+		client.setGauge(rxPowerOidStub+".502", values[1])
+		m.CollectStart = time.Now()
+		rtx.Must(m.Collect(client, cfg), "Failed to collect run %d", run+1)
+	}
+	// A counter of the wrong type fails the collection.
+	client.set(ifHCInOctetsOidStub+".502", gosnmp.OctetString, []byte("bad"))
+	if err := m.Collect(client, cfg); err == nil {
+		t.Errorf("Expected a collection error")
+	}
+
+	s = m.State()
+	octets, power := s.OIDs[0], s.OIDs[1]
+	if octets.Archive != "switch.octets.local.rx" || octets.OID != ifHCInOctetsOidStub+".502" ||
+		octets.LastCounter == nil || *octets.LastCounter != 175 || octets.LastDelta == nil ||
+		*octets.LastDelta != 25 || octets.PendingSamples != 2 {
+		t.Errorf("Unexpected counter state: %+v", octets)
+	}
+	if power.Type != config.Gauge || power.LastGauge == nil || *power.LastGauge != -200 || power.PendingSamples != 2 {
+		t.Errorf("Unexpected gauge state: %+v", power)
+	}
+	if len(s.Errors) != 1 || !strings.Contains(s.Errors[0].Message, "unknown type") {
+		t.Errorf("Expected 1 collection error, but got: %+v", s.Errors)
+	}
+
+	rec := httptest.NewRecorder()
+	m.StateHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/state", nil))
+	var got State
+	rtx.Must(json.Unmarshal(rec.Body.Bytes(), &got), "Failed to decode state")
+	if len(got.OIDs) != 2 || *got.OIDs[0].LastDelta != 25 || got.Target != target {
+		t.Errorf("Unexpected state served: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	m.StateHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/state", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be rejected, but got: %d", rec.Code)
+	}
+}
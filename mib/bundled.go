@@ -0,0 +1,123 @@
+package mib
+
+// object is a named OID defined by a MIB module.
+type object struct {
+	module string
+	name   string
+	oid    string
+}
+
+// bundled is the subset of standard and vendor MIBs built into disco: the
+// roots of the OID tree, the SNMPv2-MIB system group, the IF-MIB interface
+// tables, ENTITY-MIB and ENTITY-SENSOR-MIB sensors, and the optical (DOM)
+// sensors of Juniper and Cisco switches.
+var bundled = []object{
+	{"SNMPv2-SMI", "iso", ".1"},
+	{"SNMPv2-SMI", "org", ".1.3"},
+	{"SNMPv2-SMI", "dod", ".1.3.6"},
+	{"SNMPv2-SMI", "internet", ".1.3.6.1"},
+	{"SNMPv2-SMI", "directory", ".1.3.6.1.1"},
+	{"SNMPv2-SMI", "mgmt", ".1.3.6.1.2"},
+	{"SNMPv2-SMI", "mib-2", ".1.3.6.1.2.1"},
+	{"SNMPv2-SMI", "transmission", ".1.3.6.1.2.1.10"},
+	{"SNMPv2-SMI", "experimental", ".1.3.6.1.3"},
+	{"SNMPv2-SMI", "private", ".1.3.6.1.4"},
+	{"SNMPv2-SMI", "enterprises", ".1.3.6.1.4.1"},
+	{"SNMPv2-SMI", "security", ".1.3.6.1.5"},
+	{"SNMPv2-SMI", "snmpV2", ".1.3.6.1.6"},
+	{"SNMPv2-SMI", "snmpModules", ".1.3.6.1.6.3"},
+
+	{"SNMPv2-MIB", "system", ".1.3.6.1.2.1.1"},
+	{"SNMPv2-MIB", "sysDescr", ".1.3.6.1.2.1.1.1"},
+	{"SNMPv2-MIB", "sysObjectID", ".1.3.6.1.2.1.1.2"},
+	{"SNMPv2-MIB", "sysUpTime", ".1.3.6.1.2.1.1.3"},
+	{"SNMPv2-MIB", "sysContact", ".1.3.6.1.2.1.1.4"},
+	{"SNMPv2-MIB", "sysName", ".1.3.6.1.2.1.1.5"},
+	{"SNMPv2-MIB", "sysLocation", ".1.3.6.1.2.1.1.6"},
+	{"SNMPv2-MIB", "sysServices", ".1.3.6.1.2.1.1.7"},
+
+	{"IF-MIB", "interfaces", ".1.3.6.1.2.1.2"},
+	{"IF-MIB", "ifNumber", ".1.3.6.1.2.1.2.1"},
+	{"IF-MIB", "ifTable", ".1.3.6.1.2.1.2.2"},
+	{"IF-MIB", "ifEntry", ".1.3.6.1.2.1.2.2.1"},
+	{"IF-MIB", "ifIndex", ".1.3.6.1.2.1.2.2.1.1"},
+	{"IF-MIB", "ifDescr", ".1.3.6.1.2.1.2.2.1.2"},
+	{"IF-MIB", "ifType", ".1.3.6.1.2.1.2.2.1.3"},
+	{"IF-MIB", "ifMtu", ".1.3.6.1.2.1.2.2.1.4"},
+	{"IF-MIB", "ifSpeed", ".1.3.6.1.2.1.2.2.1.5"},
+	{"IF-MIB", "ifPhysAddress", ".1.3.6.1.2.1.2.2.1.6"},
+	{"IF-MIB", "ifAdminStatus", ".1.3.6.1.2.1.2.2.1.7"},
+	{"IF-MIB", "ifOperStatus", ".1.3.6.1.2.1.2.2.1.8"},
+	{"IF-MIB", "ifLastChange", ".1.3.6.1.2.1.2.2.1.9"},
+	{"IF-MIB", "ifInOctets", ".1.3.6.1.2.1.2.2.1.10"},
+	{"IF-MIB", "ifInUcastPkts", ".1.3.6.1.2.1.2.2.1.11"},
+	{"IF-MIB", "ifInNUcastPkts", ".1.3.6.1.2.1.2.2.1.12"},
+	{"IF-MIB", "ifInDiscards", ".1.3.6.1.2.1.2.2.1.13"},
+	{"IF-MIB", "ifInErrors", ".1.3.6.1.2.1.2.2.1.14"},
+	{"IF-MIB", "ifInUnknownProtos", ".1.3.6.1.2.1.2.2.1.15"},
+	{"IF-MIB", "ifOutOctets", ".1.3.6.1.2.1.2.2.1.16"},
+	{"IF-MIB", "ifOutUcastPkts", ".1.3.6.1.2.1.2.2.1.17"},
+	{"IF-MIB", "ifOutNUcastPkts", ".1.3.6.1.2.1.2.2.1.18"},
+	{"IF-MIB", "ifOutDiscards", ".1.3.6.1.2.1.2.2.1.19"},
+	{"IF-MIB", "ifOutErrors", ".1.3.6.1.2.1.2.2.1.20"},
+	{"IF-MIB", "ifOutQLen", ".1.3.6.1.2.1.2.2.1.21"},
+	{"IF-MIB", "ifSpecific", ".1.3.6.1.2.1.2.2.1.22"},
+	{"IF-MIB", "ifMIB", ".1.3.6.1.2.1.31"},
+	{"IF-MIB", "ifMIBObjects", ".1.3.6.1.2.1.31.1"},
+	{"IF-MIB", "ifXTable", ".1.3.6.1.2.1.31.1.1"},
+	{"IF-MIB", "ifXEntry", ".1.3.6.1.2.1.31.1.1.1"},
+	{"IF-MIB", "ifName", ".1.3.6.1.2.1.31.1.1.1.1"},
+	{"IF-MIB", "ifInMulticastPkts", ".1.3.6.1.2.1.31.1.1.1.2"},
+	{"IF-MIB", "ifInBroadcastPkts", ".1.3.6.1.2.1.31.1.1.1.3"},
+	{"IF-MIB", "ifOutMulticastPkts", ".1.3.6.1.2.1.31.1.1.1.4"},
+	{"IF-MIB", "ifOutBroadcastPkts", ".1.3.6.1.2.1.31.1.1.1.5"},
+	{"IF-MIB", "ifHCInOctets", ".1.3.6.1.2.1.31.1.1.1.6"},
+	{"IF-MIB", "ifHCInUcastPkts", ".1.3.6.1.2.1.31.1.1.1.7"},
+	{"IF-MIB", "ifHCInMulticastPkts", ".1.3.6.1.2.1.31.1.1.1.8"},
+	{"IF-MIB", "ifHCInBroadcastPkts", ".1.3.6.1.2.1.31.1.1.1.9"},
+	{"IF-MIB", "ifHCOutOctets", ".1.3.6.1.2.1.31.1.1.1.10"},
+	{"IF-MIB", "ifHCOutUcastPkts", ".1.3.6.1.2.1.31.1.1.1.11"},
+	{"IF-MIB", "ifHCOutMulticastPkts", ".1.3.6.1.2.1.31.1.1.1.12"},
+	{"IF-MIB", "ifHCOutBroadcastPkts", ".1.3.6.1.2.1.31.1.1.1.13"},
+	{"IF-MIB", "ifLinkUpDownTrapEnable", ".1.3.6.1.2.1.31.1.1.1.14"},
+	{"IF-MIB", "ifHighSpeed", ".1.3.6.1.2.1.31.1.1.1.15"},
+	{"IF-MIB", "ifPromiscuousMode", ".1.3.6.1.2.1.31.1.1.1.16"},
+	{"IF-MIB", "ifConnectorPresent", ".1.3.6.1.2.1.31.1.1.1.17"},
+	{"IF-MIB", "ifAlias", ".1.3.6.1.2.1.31.1.1.1.18"},
+	{"IF-MIB", "ifCounterDiscontinuityTime", ".1.3.6.1.2.1.31.1.1.1.19"},
+	{"IF-MIB", "ifStackTable", ".1.3.6.1.2.1.31.1.2"},
+	{"IF-MIB", "ifStackEntry", ".1.3.6.1.2.1.31.1.2.1"},
+	{"IF-MIB", "ifStackStatus", ".1.3.6.1.2.1.31.1.2.1.3"},
+
+	{"ENTITY-MIB", "entityMIB", ".1.3.6.1.2.1.47"},
+	{"ENTITY-MIB", "entPhysicalTable", ".1.3.6.1.2.1.47.1.1.1"},
+	{"ENTITY-MIB", "entPhysicalEntry", ".1.3.6.1.2.1.47.1.1.1.1"},
+	{"ENTITY-MIB", "entPhysicalDescr", ".1.3.6.1.2.1.47.1.1.1.1.2"},
+	{"ENTITY-MIB", "entPhysicalClass", ".1.3.6.1.2.1.47.1.1.1.1.5"},
+	{"ENTITY-MIB", "entPhysicalName", ".1.3.6.1.2.1.47.1.1.1.1.7"},
+
+	{"ENTITY-SENSOR-MIB", "entitySensorMIB", ".1.3.6.1.2.1.99"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorTable", ".1.3.6.1.2.1.99.1.1"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorEntry", ".1.3.6.1.2.1.99.1.1.1"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorType", ".1.3.6.1.2.1.99.1.1.1.1"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorScale", ".1.3.6.1.2.1.99.1.1.1.2"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorPrecision", ".1.3.6.1.2.1.99.1.1.1.3"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorValue", ".1.3.6.1.2.1.99.1.1.1.4"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorOperStatus", ".1.3.6.1.2.1.99.1.1.1.5"},
+	{"ENTITY-SENSOR-MIB", "entPhySensorUnitsDisplay", ".1.3.6.1.2.1.99.1.1.1.6"},
+
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentTable", ".1.3.6.1.4.1.2636.3.60.1.1.1"},
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentEntry", ".1.3.6.1.4.1.2636.3.60.1.1.1.1"},
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentRxLaserPower", ".1.3.6.1.4.1.2636.3.60.1.1.1.1.5"},
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentTxLaserBiasCurrent", ".1.3.6.1.4.1.2636.3.60.1.1.1.1.6"},
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentTxLaserOutputPower", ".1.3.6.1.4.1.2636.3.60.1.1.1.1.7"},
+	{"JUNIPER-DOM-MIB", "jnxDomCurrentModuleTemperature", ".1.3.6.1.4.1.2636.3.60.1.1.1.1.8"},
+
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorValueTable", ".1.3.6.1.4.1.9.9.91.1.1.1"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorValueEntry", ".1.3.6.1.4.1.9.9.91.1.1.1.1"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorType", ".1.3.6.1.4.1.9.9.91.1.1.1.1.1"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorScale", ".1.3.6.1.4.1.9.9.91.1.1.1.1.2"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorPrecision", ".1.3.6.1.4.1.9.9.91.1.1.1.1.3"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorValue", ".1.3.6.1.4.1.9.9.91.1.1.1.1.4"},
+	{"CISCO-ENTITY-SENSOR-MIB", "entSensorStatus", ".1.3.6.1.4.1.9.9.91.1.1.1.1.5"},
+}
@@ -0,0 +1,136 @@
+// Package mib resolves symbolic OID names such as IF-MIB::ifHCInOctets to
+// numeric OIDs, and numeric OIDs back to symbolic names, using a bundled
+// subset of common MIBs and, optionally, MIB files supplied by the user.
+package mib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// numericRegexp matches numeric OIDs, with or without a leading dot.
+	numericRegexp = regexp.MustCompile(`^\.?[0-9]+(\.[0-9]+)*$`)
+	// symbolRegexp matches symbolic OIDs: an optional module name, an object
+	// name and an optional numeric index e.g., SNMPv2-MIB::sysUpTime.0.
+	symbolRegexp = regexp.MustCompile(`^(?:([A-Za-z][A-Za-z0-9-]*)::)?([a-zA-Z][A-Za-z0-9-]*)((?:\.[0-9]+)*)$`)
+)
+
+// Resolver translates between symbolic and numeric OIDs. Numeric OIDs always
+// have a leading dot, which is how gosnmp names the OIDs in responses.
+type Resolver struct {
+	// byName maps object names to their definitions, of which there may be
+	// several if the name is defined by more than one module.
+	byName map[string][]object
+	// byOID maps OIDs to "MODULE::name".
+	byOID map[string]string
+}
+
+// NewResolver returns a Resolver for the bundled MIBs.
+func NewResolver() *Resolver {
+	r := &Resolver{
+		byName: map[string][]object{},
+		byOID:  map[string]string{},
+	}
+	for _, o := range bundled {
+		r.add(o)
+	}
+	return r
+}
+
+// add defines the object o. Redefinitions by the same module replace the
+// previous definition.
+func (r *Resolver) add(o object) {
+	defs := r.byName[o.name]
+	for i, d := range defs {
+		if d.module == o.module {
+			defs[i] = o
+			r.setName(o)
+			return
+		}
+	}
+	r.byName[o.name] = append(defs, o)
+	r.setName(o)
+}
+
+// setName records the symbolic name of o's OID, unless it already has one.
+func (r *Resolver) setName(o object) {
+	if _, ok := r.byOID[o.oid]; !ok {
+		r.byOID[o.oid] = o.module + "::" + o.name
+	}
+}
+
+// lookup returns the OID of the object name, defined by module or, if module
+// is empty, by any module.
+func (r *Resolver) lookup(module, name string) (string, error) {
+	defs := r.byName[name]
+	if module != "" {
+		for _, d := range defs {
+			if d.module == module {
+				return d.oid, nil
+			}
+		}
+		return "", fmt.Errorf("unknown MIB object '%v::%v'", module, name)
+	}
+	switch {
+	case len(defs) == 0:
+		return "", fmt.Errorf("unknown MIB object '%v'", name)
+	case len(defs) > 1:
+		modules := []string{}
+		for _, d := range defs {
+			modules = append(modules, d.module)
+		}
+		sort.Strings(modules)
+		return "", fmt.Errorf("'%v' is defined by several MIBs (%v), qualify it e.g., %v::%v",
+			name, strings.Join(modules, ", "), modules[0], name)
+	}
+	return defs[0].oid, nil
+}
+
+// Resolve returns the numeric OID for s, which is either a numeric OID or a
+// symbolic name such as IF-MIB::ifHCInOctets, ifHCInOctets or
+// SNMPv2-MIB::sysUpTime.0. The module may only be omitted if the name is
+// defined by a single module.
+func (r *Resolver) Resolve(s string) (string, error) {
+	if numericRegexp.MatchString(s) {
+		return "." + strings.TrimPrefix(s, "."), nil
+	}
+	match := symbolRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return "", fmt.Errorf("'%v' is not a valid OID or MIB object name", s)
+	}
+	module, name, index := match[1], match[2], match[3]
+	oid, err := r.lookup(module, name)
+	if err != nil {
+		return "", err
+	}
+	return oid + index, nil
+}
+
+// Name returns the symbolic form of the numeric OID, using the longest known
+// prefix followed by the remaining sub-identifiers e.g.,
+// IF-MIB::ifHCInOctets.568. If no prefix is known, oid is returned unchanged.
+func (r *Resolver) Name(oid string) string {
+	for prefix := oid; prefix != ""; {
+		if name, ok := r.byOID[prefix]; ok {
+			return name + oid[len(prefix):]
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return oid
+}
+
+// Names returns the symbolic form of each OID, as for Name().
+func (r *Resolver) Names(oids []string) []string {
+	names := make([]string, len(oids))
+	for i, oid := range oids {
+		names[i] = r.Name(oid)
+	}
+	return names
+}
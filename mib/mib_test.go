@@ -0,0 +1,107 @@
+package mib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestResolve(t *testing.T) {
+	r := NewResolver()
+	rtx.Must(r.LoadFiles("testdata/EXAMPLE-DOM-MIB.txt"), "Failed to load test MIB")
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "IF-MIB::ifHCInOctets", want: ".1.3.6.1.2.1.31.1.1.1.6"},
+		{name: "ifHCOutOctets", want: ".1.3.6.1.2.1.31.1.1.1.10"},
+		{name: "SNMPv2-MIB::sysUpTime.0", want: ".1.3.6.1.2.1.1.3.0"},
+		{name: ".1.3.6.1.2.1.2.2.1.14", want: ".1.3.6.1.2.1.2.2.1.14"},
+		{name: "1.3.6.1.2.1.2.2.1.14", want: ".1.3.6.1.2.1.2.2.1.14"},
+		{name: "EXAMPLE-DOM-MIB::exampleDomRxPower", want: ".1.3.6.1.4.1.99999.3.60.1.1.5"},
+		{name: "exampleDomState", want: ".1.3.6.1.4.1.99999.3.60.1.1.6"},
+		{name: "IF-MIB::ifHCInOctet", wantErr: "unknown MIB object 'IF-MIB::ifHCInOctet'"},
+		{name: "SNMPv2-MIB::ifHCInOctets", wantErr: "unknown MIB object"},
+		{name: "ifHCInOctets..1", wantErr: "not a valid OID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve() error = %v, expected it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			rtx.Must(err, "Failed to resolve %v", tt.name)
+			if got != tt.want {
+				t.Errorf("Resolve() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguous(t *testing.T) {
+	r := NewResolver()
+	r.add(object{module: "OTHER-MIB", name: "ifAlias", oid: ".1.3.6.1.4.1.99999.1"})
+	_, err := r.Resolve("ifAlias")
+	if err == nil || !strings.Contains(err.Error(), "IF-MIB::ifAlias") {
+		t.Errorf("Expected an ambiguity error suggesting IF-MIB::ifAlias, but got: %v", err)
+	}
+	oid, err := r.Resolve("OTHER-MIB::ifAlias")
+	if err != nil || oid != ".1.3.6.1.4.1.99999.1" {
+		t.Errorf("Resolve(OTHER-MIB::ifAlias) = %v, %v", oid, err)
+	}
+}
+
+func TestName(t *testing.T) {
+	r := NewResolver()
+	tests := map[string]string{
+		".1.3.6.1.2.1.31.1.1.1.6.568": "IF-MIB::ifHCInOctets.568",
+		".1.3.6.1.2.1.2.2.1.14":       "IF-MIB::ifInErrors",
+		".1.3.6.1.4.1.12345.1.2":      "SNMPv2-SMI::enterprises.12345.1.2",
+		".2.1":                        ".2.1",
+		"":                            "",
+	}
+	for oid, want := range tests {
+		if got := r.Name(oid); got != want {
+			t.Errorf("Name(%q) = %q, expected %q", oid, got, want)
+		}
+	}
+}
+
+func TestLoadFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		rtx.Must(ioutil.WriteFile(path, []byte(data), 0644), "Failed to write %v", path)
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"unknown-parent", "M DEFINITIONS ::= BEGIN\nfoo OBJECT IDENTIFIER ::= { bar 1 }\nEND\n", "bar (parent of M::foo"},
+		{"unterminated-string", "M DEFINITIONS ::= BEGIN\nfoo OBJECT-TYPE DESCRIPTION \"x\n", "unterminated string"},
+		{"no-subids", "M DEFINITIONS ::= BEGIN\nfoo OBJECT IDENTIFIER ::= { mib-2 }\nEND\n", "at least one sub-identifier"},
+		{"outside-module", "foo OBJECT IDENTIFIER ::= { mib-2 1 }\n", "outside of a module"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewResolver().LoadFiles(write(tt.name+".txt", tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadFiles() error = %v, expected it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+	if err := NewResolver().LoadFiles(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing MIB file")
+	}
+}
@@ -0,0 +1,270 @@
+package mib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// macros are the SMI macros whose invocations assign an OID to a name.
+var macros = map[string]bool{
+	"OBJECT-TYPE":        true,
+	"OBJECT-IDENTITY":    true,
+	"MODULE-IDENTITY":    true,
+	"NOTIFICATION-TYPE":  true,
+	"OBJECT-GROUP":       true,
+	"NOTIFICATION-GROUP": true,
+	"MODULE-COMPLIANCE":  true,
+	"AGENT-CAPABILITIES": true,
+}
+
+// namedNumberRegexp matches the "name(number)" form of OID components.
+var namedNumberRegexp = regexp.MustCompile(`^[a-zA-Z][A-Za-z0-9-]*\(([0-9]+)\)$`)
+
+// definition is an OID assignment parsed from a MIB file, relative to a
+// parent object.
+type definition struct {
+	module string
+	name   string
+	parent string
+	// subids are the sub-identifiers following the parent.
+	subids []string
+	file   string
+}
+
+// LoadFiles parses the SMIv1/SMIv2 MIB modules in files and adds the objects
+// they define. Objects may refer to parents defined by the bundled MIBs or by
+// any of the files, in any order.
+//
+// Only OID assignments are parsed; types, descriptions and imports are
+// ignored, so that vendor MIBs can be used without the MIBs they import.
+func (r *Resolver) LoadFiles(files ...string) error {
+	var pending []definition
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read MIB file: %v", err)
+		}
+		defs, err := parseMIB(string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse MIB file '%v': %v", file, err)
+		}
+		for i := range defs {
+			defs[i].file = file
+		}
+		pending = append(pending, defs...)
+	}
+
+	// Resolve definitions whose parents are known until no more progress is
+	// made, since definitions may refer to parents defined later.
+	for len(pending) > 0 {
+		var unresolved []definition
+		for _, d := range pending {
+			oid, ok := r.parentOID(d)
+			if !ok {
+				unresolved = append(unresolved, d)
+				continue
+			}
+			r.add(object{module: d.module, name: d.name, oid: oid + "." + strings.Join(d.subids, ".")})
+		}
+		if len(unresolved) == len(pending) {
+			missing := map[string]bool{}
+			for _, d := range unresolved {
+				missing[fmt.Sprintf("%v (parent of %v::%v in %v)", d.parent, d.module, d.name, d.file)] = true
+			}
+			list := make([]string, 0, len(missing))
+			for m := range missing {
+				list = append(list, m)
+			}
+			sort.Strings(list)
+			return fmt.Errorf("unknown MIB objects: %v", strings.Join(list, ", "))
+		}
+		pending = unresolved
+	}
+	return nil
+}
+
+// parentOID returns the OID of d's parent, preferring a definition by the same
+// module.
+func (r *Resolver) parentOID(d definition) (string, bool) {
+	if oid, err := r.lookup(d.module, d.parent); err == nil {
+		return oid, true
+	}
+	if oid, err := r.lookup("", d.parent); err == nil {
+		return oid, true
+	}
+	// Imported parents which are defined by several modules are ambiguous
+	// without resolving IMPORTS, so the first definition is used.
+	if defs := r.byName[d.parent]; len(defs) > 0 {
+		return defs[0].oid, true
+	}
+	return "", false
+}
+
+// parseMIB returns the OID assignments in the MIB modules of data.
+func parseMIB(data string) ([]definition, error) {
+	tokens, err := tokenize(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var defs []definition
+	module := ""
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		// A module starts with "NAME DEFINITIONS ::= BEGIN".
+		if t == "DEFINITIONS" && i > 0 {
+			module = tokens[i-1]
+			continue
+		}
+		if i == 0 || !isValueName(tokens[i-1]) {
+			continue
+		}
+		name := tokens[i-1]
+
+		var end int
+		switch {
+		case t == "OBJECT" && i+2 < len(tokens) && tokens[i+1] == "IDENTIFIER" && tokens[i+2] == "::=":
+			end = i + 2
+		case macros[t]:
+			// The macro's clauses end with the assignment.
+			end = i + 1
+			for end < len(tokens) && tokens[end] != "::=" {
+				if end > i+1 && macros[tokens[end]] {
+					break
+				}
+				end++
+			}
+			if end >= len(tokens) || tokens[end] != "::=" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if module == "" {
+			return nil, fmt.Errorf("%v is defined outside of a module", name)
+		}
+		d, next, err := parseOIDValue(tokens, end+1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID value for %v: %v", name, err)
+		}
+		d.module, d.name = module, name
+		defs = append(defs, d)
+		i = next
+	}
+	return defs, nil
+}
+
+// parseOIDValue parses "{ parent n ... }" starting at tokens[i], returning the
+// index of the closing brace.
+func parseOIDValue(tokens []string, i int) (definition, int, error) {
+	d := definition{}
+	if i >= len(tokens) || tokens[i] != "{" {
+		return d, i, fmt.Errorf("expected '{'")
+	}
+	for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+		t := tokens[i]
+		if _, err := strconv.ParseUint(t, 10, 32); err == nil {
+			if d.parent == "" {
+				return d, i, fmt.Errorf("absolute OIDs are not supported, use a parent such as iso")
+			}
+			d.subids = append(d.subids, t)
+			continue
+		}
+		if m := namedNumberRegexp.FindStringSubmatch(t); m != nil && d.parent != "" {
+			d.subids = append(d.subids, m[1])
+			continue
+		}
+		if d.parent != "" {
+			return d, i, fmt.Errorf("unexpected '%v'", t)
+		}
+		// The first component may also be in name(number) form e.g., iso(1).
+		d.parent = strings.SplitN(t, "(", 2)[0]
+	}
+	if i >= len(tokens) {
+		return d, i, fmt.Errorf("expected '}'")
+	}
+	if d.parent == "" || len(d.subids) == 0 {
+		return d, i, fmt.Errorf("expected a parent and at least one sub-identifier")
+	}
+	return d, i, nil
+}
+
+// isValueName returns true if t is a valid ASN.1 value reference, which
+// starts with a lowercase letter.
+func isValueName(t string) bool {
+	if t == "" || !unicode.IsLower(rune(t[0])) {
+		return false
+	}
+	for _, r := range t {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits the MIB into tokens, dropping comments and quoted strings.
+// "name(number)" is returned as a single token.
+func tokenize(data string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '-' && i+1 < len(data) && data[i+1] == '-':
+			// Comments end at the end of the line, or at the next "--".
+			flush()
+			i += 2
+			for i < len(data) && data[i] != '\n' && !(data[i] == '-' && i+1 < len(data) && data[i+1] == '-') {
+				i++
+			}
+			if i < len(data) && data[i] == '-' {
+				i++
+			}
+		case c == '"':
+			flush()
+			end := strings.IndexByte(data[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i += end + 1
+		case c == '(' && cur.Len() > 0:
+			// Keep "name(number)" together.
+			end := strings.IndexByte(data[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '('")
+			}
+			inner := strings.TrimSpace(data[i+1 : i+end])
+			if _, err := strconv.ParseUint(inner, 10, 32); err != nil {
+				// e.g., "SIZE(0..255)", which is not an OID component.
+				flush()
+				continue
+			}
+			cur.WriteString("(" + inner + ")")
+			i += end
+			flush()
+		case c == '{' || c == '}' || c == '(' || c == ')' || c == ',' || c == ';' || c == '|':
+			flush()
+			tokens = append(tokens, string(c))
+		case unicode.IsSpace(rune(c)):
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
@@ -0,0 +1,128 @@
+// Package naming derives the machine name and switch target of a node from
+// its hostname.
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+const (
+	// DefaultHostnameRegex matches both v1 hostnames such as
+	// mlab1-lga0t.measurement-lab.org and v2 hostnames such as
+	// mlab1-lga0t.mlab-oti.measurement-lab.org, as well as bare names such as
+	// mlab1-lga0t.
+	DefaultHostnameRegex = `^(?P<machine>mlab[0-9]+)[-.](?P<site>[a-z]{3}[0-9][0-9a-z])(\.|$)`
+	// DefaultMachineTemplate is the machine name e.g., mlab1.
+	DefaultMachineTemplate = `{{.machine}}`
+	// DefaultTargetTemplate is the FQDN of the site's switch e.g.,
+	// s1-lga0t.measurement-lab.org.
+	DefaultTargetTemplate = `s1-{{.site}}.measurement-lab.org`
+)
+
+// Names are the names derived from a hostname.
+type Names struct {
+	Hostname string
+	Machine  string
+	Target   string
+	// Groups holds the values of the named groups of the hostname regex.
+	Groups map[string]string
+}
+
+// Deriver derives Names from hostnames using a regular expression with named
+// groups, and text/templates for the machine name and target. The templates
+// may refer to any named group e.g., {{.site}}, and to the whole hostname as
+// {{.Hostname}}.
+type Deriver struct {
+	regex   *regexp.Regexp
+	machine *template.Template
+	target  *template.Template
+}
+
+// NewDeriver returns a Deriver for the given hostname regex and machine and
+// target templates. It returns an error if the regex has no named groups or
+// a template refers to a group the regex doesn't define.
+func NewDeriver(hostnameRegex, machineTmpl, targetTmpl string) (*Deriver, error) {
+	regex, err := regexp.Compile(hostnameRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname regex: %v", err)
+	}
+	d := &Deriver{regex: regex}
+
+	// Every template must execute using the groups the regex defines.
+	data := map[string]string{"Hostname": "hostname"}
+	for _, name := range regex.SubexpNames() {
+		if name == "Hostname" {
+			return nil, fmt.Errorf("invalid hostname regex: the group name %q is reserved", name)
+		}
+		if name != "" {
+			data[name] = name
+		}
+	}
+	if len(data) == 1 {
+		return nil, fmt.Errorf("invalid hostname regex %q: it has no named groups e.g., (?P<site>...)", hostnameRegex)
+	}
+
+	for _, t := range []struct {
+		name string
+		text string
+		tmpl **template.Template
+	}{
+		{"machine", machineTmpl, &d.machine},
+		{"target", targetTmpl, &d.target},
+	} {
+		*t.tmpl, err = template.New(t.name).Option("missingkey=error").Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v template: %v", t.name, err)
+		}
+		if _, err := execute(*t.tmpl, data); err != nil {
+			return nil, fmt.Errorf("invalid %v template %q for hostname regex %q: %v",
+				t.name, t.text, hostnameRegex, err)
+		}
+	}
+	return d, nil
+}
+
+// Derive returns the Names for hostname. It returns an error if hostname does
+// not match the regex, or if the machine name or target would be empty.
+func (d *Deriver) Derive(hostname string) (Names, error) {
+	n := Names{Hostname: hostname, Groups: map[string]string{}}
+	match := d.regex.FindStringSubmatch(hostname)
+	if match == nil {
+		return n, fmt.Errorf("hostname %q does not match the hostname regex %q", hostname, d.regex)
+	}
+
+	data := map[string]string{"Hostname": hostname}
+	for i, name := range d.regex.SubexpNames() {
+		if name != "" {
+			n.Groups[name] = match[i]
+			data[name] = match[i]
+		}
+	}
+
+	var err error
+	if n.Machine, err = execute(d.machine, data); err != nil {
+		return n, err
+	}
+	if n.Machine == "" {
+		return n, fmt.Errorf("the machine name derived from hostname %q is empty", hostname)
+	}
+	if n.Target, err = execute(d.target, data); err != nil {
+		return n, err
+	}
+	if n.Target == "" {
+		return n, fmt.Errorf("the target derived from hostname %q is empty", hostname)
+	}
+	return n, nil
+}
+
+// execute returns the output of tmpl for data.
+func execute(tmpl *template.Template, data map[string]string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
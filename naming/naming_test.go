@@ -0,0 +1,78 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestDerive(t *testing.T) {
+	d, err := NewDeriver(DefaultHostnameRegex, DefaultMachineTemplate, DefaultTargetTemplate)
+	rtx.Must(err, "Failed to create default Deriver")
+
+	tests := []struct {
+		hostname string
+		machine  string
+		target   string
+		wantErr  bool
+	}{
+		{"mlab1-lga0t", "mlab1", "s1-lga0t.measurement-lab.org", false},
+		{"mlab2-abc0t.measurement-lab.org", "mlab2", "s1-abc0t.measurement-lab.org", false},
+		{"mlab1-lga0t.mlab-oti.measurement-lab.org", "mlab1", "s1-lga0t.measurement-lab.org", false},
+		{"mlab4.lga03.measurement-lab.org", "mlab4", "s1-lga03.measurement-lab.org", false},
+		{"mlab1", "", "", true},
+		{"mlab1-lga0tx.mlab-oti", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			n, err := d.Derive(tt.hostname)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Derive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if n.Machine != tt.machine || n.Target != tt.target || n.Hostname != tt.hostname {
+				t.Errorf("Derive() = %+v, expected machine %q and target %q", n, tt.machine, tt.target)
+			}
+		})
+	}
+}
+
+func TestDeriveCustom(t *testing.T) {
+	d, err := NewDeriver(`^(?P<node>[a-z0-9]+)\.(?P<pop>[a-z]+)\.example\.net$`,
+		`{{.node}}`, `sw-{{.pop}}.{{.Hostname}}`)
+	rtx.Must(err, "Failed to create custom Deriver")
+
+	n, err := d.Derive("node7.ams.example.net")
+	rtx.Must(err, "Failed to derive names")
+	if n.Machine != "node7" || n.Target != "sw-ams.node7.ams.example.net" || n.Groups["pop"] != "ams" {
+		t.Errorf("Unexpected names: %+v", n)
+	}
+}
+
+func TestNewDeriverErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		regex   string
+		machine string
+		target  string
+		errMsg  string
+	}{
+		{"bad-regex", `(`, DefaultMachineTemplate, DefaultTargetTemplate, "invalid hostname regex"},
+		{"no-groups", `^mlab`, DefaultMachineTemplate, DefaultTargetTemplate, "no named groups"},
+		{"reserved-group", `^(?P<Hostname>.*)$`, DefaultMachineTemplate, DefaultTargetTemplate, "reserved"},
+		{"bad-machine-template", DefaultHostnameRegex, `{{.machine`, DefaultTargetTemplate, "invalid machine template"},
+		{"unknown-group", DefaultHostnameRegex, DefaultMachineTemplate, `s1-{{.metro}}`, "invalid target template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDeriver(tt.regex, tt.machine, tt.target)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("NewDeriver() error = %v, expected it to contain %q", err, tt.errMsg)
+			}
+		})
+	}
+}
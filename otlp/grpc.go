@@ -0,0 +1,60 @@
+package otlp
+
+import (
+	"context"
+	"crypto/tls"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcClient sends export requests with OTLP/gRPC.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	client  colmetricpb.MetricsServiceClient
+	headers metadata.MD
+}
+
+// newGRPCClient returns a client for the collector at the host:port endpoint.
+// The connection is established lazily, so that DISCO starts even if the
+// collector is down.
+func newGRPCClient(endpoint string, plaintext bool, headers map[string]string) (*grpcClient, error) {
+	creds := credentials.NewTLS(&tls.Config{})
+	if plaintext {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{
+		conn:    conn,
+		client:  colmetricpb.NewMetricsServiceClient(conn),
+		headers: metadata.New(headers),
+	}, nil
+}
+
+func (c *grpcClient) export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+	_, err := c.client.Export(metadata.NewOutgoingContext(ctx, c.headers), req)
+	return err
+}
+
+// temporary returns true for the gRPC status codes which the OTLP
+// specification lists as retryable.
+func (c *grpcClient) temporary(err error) bool {
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange,
+		codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,95 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// metricsPath is the path of the OTLP/HTTP metrics endpoint.
+const metricsPath = "/v1/metrics"
+
+// httpError is the response of a failed OTLP/HTTP request.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e httpError) Error() string {
+	return e.msg
+}
+
+// httpClient sends export requests with OTLP/HTTP, encoded as protobuf.
+type httpClient struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newHTTPClient returns a client for the collector at the URL endpoint.
+func newHTTPClient(endpoint string, headers map[string]string) (*httpClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP/HTTP endpoint '%v' must be an http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = metricsPath
+	}
+	return &httpClient{url: u.String(), headers: headers, client: &http.Client{}}, nil
+}
+
+func (c *httpClient) export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range c.headers {
+		r.Header.Set(k, v)
+	}
+	r.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return httpError{status: resp.StatusCode, msg: fmt.Sprintf("%v: %s", resp.Status, bytes.TrimSpace(msg))}
+}
+
+// temporary returns true for network errors, and for the HTTP statuses which
+// the OTLP specification lists as retryable.
+func (c *httpClient) temporary(err error) bool {
+	e, ok := err.(httpError)
+	if !ok {
+		return true
+	}
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
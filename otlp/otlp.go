@@ -0,0 +1,267 @@
+// Package otlp pushes the points collected by DISCO to an OpenTelemetry
+// collector with OTLP, over gRPC or HTTP.
+//
+// Counters are exported as cumulative, monotonic sums starting at the first
+// collection, and gauges as gauges, with the same names and attributes as the
+// labels of their Prometheus metrics. Every point is timestamped with the
+// start of its collection.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/go/prometheusx"
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"golang.org/x/exp/slog"
+)
+
+// The OTLP transports.
+const (
+	GRPC = "grpc"
+	HTTP = "http/protobuf"
+)
+
+// Protocols are the supported OTLP transports.
+var Protocols = []string{GRPC, HTTP}
+
+const (
+	// DefaultTimeout bounds each export request.
+	DefaultTimeout = 10 * time.Second
+	// queueSize is the number of collections which may wait to be exported,
+	// an hour at the collection interval of 10s.
+	queueSize = 360
+)
+
+// errorLog limits the logging of failed exports, which may fail for every
+// collection while the collector is down.
+var errorLog = logging.NewLimiter(time.Minute)
+
+// client sends export requests over one of the OTLP transports.
+type client interface {
+	// export sends req. The error is retryable if temporary returns true.
+	export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error
+	// temporary returns true if a request which failed with err may succeed
+	// if it is retried.
+	temporary(err error) bool
+	close() error
+}
+
+// Exporter is a metrics.Exporter which pushes points to an OTLP endpoint.
+// Points are sent by Run().
+type Exporter struct {
+	client   client
+	resource *resourcepb.Resource
+	scope    *commonpb.InstrumentationScope
+	requests chan *colmetricpb.ExportMetricsServiceRequest
+
+	// Timeout bounds each export request.
+	Timeout time.Duration
+	// Retries is the number of times a request failing with a temporary
+	// error is retried, with an exponential backoff starting at MinBackoff.
+	Retries    int
+	MinBackoff time.Duration
+
+	exports *prometheus.CounterVec
+}
+
+// New returns an Exporter sending points to endpoint with protocol, one of
+// Protocols. For GRPC, the endpoint is a host:port, which is connected to
+// with TLS unless insecure is true. For HTTP, it is the URL of the collector,
+// to which /v1/metrics is added if it has no path. The headers are added to
+// every request, and resource are the attributes of the OTLP resource.
+func New(protocol, endpoint string, insecure bool, headers, resource map[string]string) (*Exporter, error) {
+	var c client
+	var err error
+	switch protocol {
+	case GRPC:
+		c, err = newGRPCClient(endpoint, insecure, headers)
+	case HTTP:
+		c, err = newHTTPClient(endpoint, headers)
+	default:
+		err = fmt.Errorf("unknown OTLP protocol '%v'", protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{
+		client:   c,
+		resource: &resourcepb.Resource{Attributes: attributes(resource)},
+		scope: &commonpb.InstrumentationScope{
+			Name:    "github.com/m-lab/disco",
+			Version: prometheusx.GitShortCommit,
+		},
+		requests:   make(chan *colmetricpb.ExportMetricsServiceRequest, queueSize),
+		Timeout:    DefaultTimeout,
+		Retries:    3,
+		MinBackoff: time.Second,
+		exports: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "disco_otlp_exports_total",
+				Help: "Total number of OTLP export requests, by result: sent, failed or dropped.",
+			},
+			[]string{"result"},
+		),
+	}, nil
+}
+
+// Resource returns the resource attributes of the switch target, collected
+// by the node hostname, for New.
+func Resource(hostname, target string, d archive.Device) map[string]string {
+	resource := map[string]string{
+		"service.name":    "disco",
+		"service.version": prometheusx.GitShortCommit,
+		"host.name":       hostname,
+		"disco.target":    target,
+	}
+	for key, value := range map[string]string{
+		"disco.switch.vendor":        d.Vendor,
+		"disco.switch.model":         d.Model,
+		"disco.switch.sys_object_id": d.SysObjectID,
+		"disco.profile":              d.Profile,
+	} {
+		if value != "" {
+			resource[key] = value
+		}
+	}
+	return resource
+}
+
+// Export queues the points of a collection in an export request. If the
+// queue is full, the points are dropped.
+func (e *Exporter) Export(points []metrics.Point) {
+	if len(points) == 0 {
+		return
+	}
+	select {
+	case e.requests <- e.request(points):
+	default:
+		errorLog.Log(slog.Default(), slog.LevelError, "queue", "OTLP export queue is full, dropping points",
+			"points", len(points))
+		e.exports.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run sends the queued requests until ctx is cancelled, and then closes the
+// connection to the endpoint.
+func (e *Exporter) Run(ctx context.Context) {
+	defer func() {
+		if err := e.client.close(); err != nil {
+			slog.Error("failed to close OTLP client", logging.Err(err))
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-e.requests:
+			if err := e.send(ctx, req); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				errorLog.Log(slog.Default(), slog.LevelError, "send", "failed to export to OTLP endpoint, dropping metrics",
+					"metrics", len(req.ResourceMetrics[0].ScopeMetrics[0].Metrics), logging.Err(err))
+				e.exports.WithLabelValues("failed").Inc()
+				continue
+			}
+			e.exports.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+// send sends req, retrying temporary failures.
+func (e *Exporter) send(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) error {
+	backoff := e.MinBackoff
+	for attempt := 0; ; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, e.Timeout)
+		err := e.client.export(reqCtx, req)
+		cancel()
+		if err == nil || attempt >= e.Retries || !e.client.temporary(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// request returns the export request for points, with one metric per name.
+func (e *Exporter) request(points []metrics.Point) *colmetricpb.ExportMetricsServiceRequest {
+	byName := map[string]*metricpb.Metric{}
+	var names []string
+	for _, p := range points {
+		m, ok := byName[p.Name]
+		if !ok {
+			m = &metricpb.Metric{Name: p.Name, Unit: p.Unit}
+			if p.Gauge {
+				m.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{}}
+			} else {
+				m.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+					AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+				}}
+			}
+			byName[p.Name] = m
+			names = append(names, p.Name)
+		}
+		dp := &metricpb.NumberDataPoint{
+			Attributes:   attributes(p.Labels),
+			TimeUnixNano: uint64(p.Sample.CollectStart),
+			Value:        &metricpb.NumberDataPoint_AsDouble{AsDouble: p.Value},
+		}
+		switch data := m.Data.(type) {
+		case *metricpb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, dp)
+		case *metricpb.Metric_Sum:
+			dp.StartTimeUnixNano = uint64(p.Start)
+			data.Sum.DataPoints = append(data.Sum.DataPoints, dp)
+		}
+	}
+
+	sort.Strings(names)
+	ms := make([]*metricpb.Metric, 0, len(names))
+	for _, name := range names {
+		ms = append(ms, byName[name])
+	}
+	return &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource:     e.resource,
+			ScopeMetrics: []*metricpb.ScopeMetrics{{Scope: e.scope, Metrics: ms}},
+		}},
+	}
+}
+
+// attributes returns m as OTLP attributes, sorted by key.
+func attributes(m map[string]string) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(m))
+	for k, v := range m {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+		})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+// Describe sends the descriptors of the Exporter's metrics.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.exports.Describe(ch)
+}
+
+// Collect sends the Exporter's metrics.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.exports.Collect(ch)
+}
@@ -0,0 +1,197 @@
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/go/rtx"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand-in for an OpenTelemetry collector, which fails the
+// first failures requests and then records the requests.
+type collector struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	mutex    sync.Mutex
+	failures int
+	requests []*colmetricpb.ExportMetricsServiceRequest
+	tokens   []string
+	received chan struct{}
+}
+
+// record records req, or returns false if it must fail.
+func (c *collector) record(req *colmetricpb.ExportMetricsServiceRequest, token string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tokens = append(c.tokens, token)
+	if c.failures > 0 {
+		c.failures--
+		return false
+	}
+	c.requests = append(c.requests, req)
+	c.received <- struct{}{}
+	return true
+}
+
+func (c *collector) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = v[0]
+	}
+	if !c.record(req, token) {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected request", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	rtx.Must(err, "Failed to read request")
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	rtx.Must(proto.Unmarshal(body, req), "Failed to decode request")
+	if !c.record(req, r.Header.Get("Authorization")) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write([]byte{})
+}
+
+// serve starts c with protocol, and returns its endpoint and a function
+// stopping it.
+func serve(t *testing.T, c *collector, protocol string) (string, func()) {
+	if protocol == HTTP {
+		srv := httptest.NewServer(c)
+		return srv.URL, srv.Close
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	rtx.Must(err, "Failed to listen")
+	srv := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(srv, c)
+	go srv.Serve(lis)
+	return lis.Addr().String(), srv.Stop
+}
+
+// attrs returns OTLP attributes as a map.
+func attrs(kvs []*commonpb.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+func TestExporter(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	collect := start.Add(10 * time.Second)
+	labels := map[string]string{"scope": "machine", "ifAlias": "mlab1", "interface": "xe-0/0/11"}
+	points := []metrics.Point{
+		{
+			Name: "ifHCInOctets", Labels: labels, Unit: "octets", Value: 1000,
+			Start: start.UnixNano(), Sample: archive.Sample{CollectStart: collect.UnixNano()},
+		},
+		{
+			Name: "jnxDomCurrentRxLaserPower", Labels: labels, Unit: "dBm", Gauge: true, Value: -2.5,
+			Start: start.UnixNano(), Sample: archive.Sample{CollectStart: collect.UnixNano()},
+		},
+	}
+	device := archive.Device{Vendor: "juniper", Model: "qfx5100-48s-6q", SysObjectID: ".1.3.6.1.4.1.2636.1.1.1.2.82"}
+
+	for _, protocol := range Protocols {
+		t.Run(protocol, func(t *testing.T) {
+			c := &collector{failures: 1, received: make(chan struct{}, 1)}
+			endpoint, stop := serve(t, c, protocol)
+			defer stop()
+
+			headers := map[string]string{"Authorization": "Bearer secret"}
+			e, err := New(protocol, endpoint, true, headers, Resource("mlab1.abc0t", "s1.abc0t", device))
+			rtx.Must(err, "Failed to create exporter")
+			e.MinBackoff = time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				e.Run(ctx)
+				close(done)
+			}()
+			e.Export(points)
+			select {
+			case <-c.received:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out waiting for the export")
+			}
+			cancel()
+			<-done
+
+			// The first request failed, and was retried.
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if len(c.tokens) != 2 || c.tokens[1] != "Bearer secret" {
+				t.Errorf("Expected 2 requests with the token, but got: %v", c.tokens)
+			}
+			rm := c.requests[0].ResourceMetrics[0]
+			resource := attrs(rm.Resource.Attributes)
+			if resource["host.name"] != "mlab1.abc0t" || resource["disco.target"] != "s1.abc0t" ||
+				resource["disco.switch.model"] != "qfx5100-48s-6q" {
+				t.Errorf("Unexpected resource attributes: %v", resource)
+			}
+			ms := rm.ScopeMetrics[0].Metrics
+			if len(ms) != 2 {
+				t.Fatalf("Expected 2 metrics, but got: %v", ms)
+			}
+
+			sum := ms[0].GetSum()
+			if ms[0].Name != "ifHCInOctets" || ms[0].Unit != "octets" || sum == nil || !sum.IsMonotonic ||
+				sum.AggregationTemporality != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+				t.Fatalf("Expected a cumulative monotonic sum, but got: %v", ms[0])
+			}
+			dp := sum.DataPoints[0]
+			if dp.GetAsDouble() != 1000 || dp.StartTimeUnixNano != uint64(start.UnixNano()) ||
+				dp.TimeUnixNano != uint64(collect.UnixNano()) || attrs(dp.Attributes)["ifAlias"] != "mlab1" {
+				t.Errorf("Unexpected sum data point: %v", dp)
+			}
+
+			gauge := ms[1].GetGauge()
+			if gauge == nil || gauge.DataPoints[0].GetAsDouble() != -2.5 || gauge.DataPoints[0].StartTimeUnixNano != 0 {
+				t.Errorf("Expected a gauge of -2.5, but got: %v", ms[1])
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		protocol string
+		endpoint string
+		wantErr  bool
+	}{
+		{protocol: GRPC, endpoint: "localhost:4317"},
+		{protocol: HTTP, endpoint: "https://otel.example.org:4318"},
+		{protocol: HTTP, endpoint: "localhost:4318", wantErr: true},
+		{protocol: "http/json", endpoint: "https://otel.example.org:4318", wantErr: true},
+	} {
+		_, err := New(tt.protocol, tt.endpoint, false, nil, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%v, %v) returned error %v, want error: %v", tt.protocol, tt.endpoint, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/m-lab/disco/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runPrintConfig implements the "print-config" command, which prints the
+// effective metrics config: the built-in metrics merged with the -metrics
+// file, if any. If -sys-object-id or -sys-descr is given, the matching
+// profile is applied, and if -target or -hostname is given, the matching
+// overrides are applied.
+func runPrintConfig(args []string) error {
+	fs := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	metricsFile := fs.String("metrics", "", "Path to YAML file defining metrics to scrape. Default: the built-in metrics.")
+	sysObjectID := fs.String("sys-object-id", "", "Apply the profile matching this switch sysObjectID.")
+	sysDescr := fs.String("sys-descr", "", "Apply the profile matching this switch sysDescr.")
+	target := fs.String("target", "", "Apply the overrides matching this switch FQDN.")
+	hostname := fs.String("hostname", "", "Apply the overrides matching this node FQDN.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := config.Load(*metricsFile)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if *sysObjectID != "" || *sysDescr != "" {
+		var profile string
+		c, profile, err = c.ForDevice(*sysObjectID, *sysDescr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Using profile %q\n", profile)
+	}
+	if *target != "" || *hostname != "" {
+		c, err = c.ForTarget(*target, *hostname)
+		if err != nil {
+			return err
+		}
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,209 @@
+// Package probe implements an HTTP endpoint which collects the configured
+// metrics from a switch on demand and returns them in the Prometheus
+// exposition format, like the snmp_exporter. This lets Prometheus poll
+// switches without a DISCO deployment for each of them.
+package probe
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/device"
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/snmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	successDesc = prometheus.NewDesc("disco_probe_success",
+		"Whether the SNMP collection of the probe succeeded.", nil, nil)
+	durationDesc = prometheus.NewDesc("disco_probe_duration_seconds",
+		"How long the probe took, in seconds.", nil, nil)
+	// labels are the labels of the switch metrics.
+	labels = []string{"ifIndex", "ifAlias", "interface"}
+)
+
+// ConnectFunc connects to the SNMP agent of target. The returned io.Closer
+// closes the connection.
+type ConnectFunc func(target string) (snmp.Client, io.Closer, error)
+
+// Handler serves /probe?target=<switch>&module=<profile>. The module is the
+// name of a config.Profile, or config.GenericProfile; if it is omitted, the
+// profile is selected by the sysObjectID and sysDescr of the switch. The
+// Overrides matching the target are applied too.
+//
+// Each metric is collected from every interface of the switch, and labelled
+// with its ifIndex, ifAlias and ifDescr.
+type Handler struct {
+	config  config.Config
+	targets *regexp.Regexp
+	connect ConnectFunc
+}
+
+// NewHandler returns a Handler collecting the metrics of c. Only targets which
+// completely match the regular expression targets may be probed, since
+// probes use DISCO's SNMP credentials.
+func NewHandler(c config.Config, targets string, connect ConnectFunc) (*Handler, error) {
+	re, err := regexp.Compile("^(?:" + targets + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{config: c, targets: re, connect: connect}, nil
+}
+
+// ServeHTTP probes the target. Failures to collect metrics are reported by
+// disco_probe_success, rather than an HTTP error.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	module := r.URL.Query().Get("module")
+	if target == "" {
+		http.Error(w, "target parameter is required", http.StatusBadRequest)
+		return
+	}
+	if !h.targets.MatchString(target) {
+		http.Error(w, fmt.Sprintf("target '%v' may not be probed", target), http.StatusForbidden)
+		return
+	}
+	if module != "" {
+		if _, err := h.config.ForProfile(module); err != nil {
+			http.Error(w, fmt.Sprintf("unknown module '%v'", module), http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := time.Now()
+	metrics, err := h.probe(target, module)
+	success := 1.0
+	if err != nil {
+		slog.Error("failed to probe", logging.TargetKey, target, "module", module, logging.Err(err))
+		metrics, success = nil, 0
+	}
+	metrics = append(metrics,
+		prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, success),
+		prometheus.MustNewConstMetric(durationDesc, prometheus.GaugeValue, time.Since(start).Seconds()),
+	)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector(metrics))
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probe returns the metrics of target.
+func (h *Handler) probe(target, module string) ([]prometheus.Metric, error) {
+	client, conn, err := h.connect(target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c := h.config
+	if module == "" {
+		info, err := device.Detect(client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect the switch model: %v", err)
+		}
+		c, _, err = c.ForDevice(info.SysObjectID, info.SysDescr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		c, err = c.ForProfile(module)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err = c.ForTarget(target, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ifDescrs, err := walkStrings(client, snmp.IfDescrOidStub)
+	if err != nil {
+		return nil, err
+	}
+	ifAliases, err := walkStrings(client, snmp.IfAliasOidStub)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, m := range c.Metrics {
+		help := m.Description
+		if m.Unit != "" {
+			help = fmt.Sprintf("%v Unit: %v.", help, m.Unit)
+		}
+		desc := prometheus.NewDesc(m.Name, help, labels, nil)
+		valueType := prometheus.CounterValue
+		if m.IsGauge() {
+			valueType = prometheus.GaugeValue
+		}
+
+		pdus, err := client.BulkWalkAll(m.OidStub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk %v: %v", c.Resolver().Name(m.OidStub), err)
+		}
+		for _, pdu := range pdus {
+			index := strings.TrimPrefix(pdu.Name, m.OidStub+".")
+			value, ok := toFloat(pdu.Value)
+			if !ok || index == pdu.Name {
+				continue
+			}
+			metrics = append(metrics, prometheus.MustNewConstMetric(desc, valueType, m.Convert(value),
+				index, ifAliases[index], ifDescrs[index]))
+		}
+	}
+	return metrics, nil
+}
+
+// walkStrings returns the string values of the column oidStub, keyed by
+// index.
+func walkStrings(client snmp.Client, oidStub string) (map[string]string, error) {
+	pdus, err := client.BulkWalkAll(oidStub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %v: %v", oidStub, err)
+	}
+	values := map[string]string{}
+	for _, pdu := range pdus {
+		if b, ok := pdu.Value.([]byte); ok {
+			values[strings.TrimPrefix(pdu.Name, oidStub+".")] = string(b)
+		}
+	}
+	return values, nil
+}
+
+// toFloat returns the numeric value of an SNMP variable, as decoded by gosnmp.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// collector is an unchecked prometheus.Collector of constant metrics.
+type collector []prometheus.Metric
+
+// Describe sends no descriptors, since the metrics depend on the switch.
+func (c collector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect sends the metrics.
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
@@ -0,0 +1,179 @@
+package probe
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/disco/config"
+	"github.com/m-lab/disco/snmp"
+	"github.com/m-lab/go/rtx"
+)
+
+var metricsYaml = `
+replaceDefaults: true
+metrics:
+  - name: ifHCInOctets
+    description: Ingress octets.
+    oid: IF-MIB::ifHCInOctets
+    mlabUplinkName: switch.octets.uplink.rx
+    mlabMachineName: switch.octets.local.rx
+profiles:
+  - name: juniper
+    sysObjectID: [enterprises.2636]
+    metrics:
+      - name: jnxDomCurrentRxLaserPower
+        description: Receive laser power.
+        oid: JUNIPER-DOM-MIB::jnxDomCurrentRxLaserPower
+        type: gauge
+        scale: 0.01
+        unit: dBm
+        archiveNames:
+          uplink: switch.rxpower.uplink
+`
+
+// tableClient is an snmp.Client which serves walks and gets from a static
+// table.
+type tableClient struct {
+	pdus []gosnmp.SnmpPDU
+}
+
+func (c *tableClient) BulkWalkAll(rootOid string) ([]gosnmp.SnmpPDU, error) {
+	results := []gosnmp.SnmpPDU{}
+	for _, pdu := range c.pdus {
+		if strings.HasPrefix(pdu.Name, rootOid+".") {
+			results = append(results, pdu)
+		}
+	}
+	return results, nil
+}
+
+func (c *tableClient) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	packet := &gosnmp.SnmpPacket{}
+	for _, oid := range oids {
+		for _, pdu := range c.pdus {
+			if pdu.Name == oid {
+				packet.Variables = append(packet.Variables, pdu)
+			}
+		}
+	}
+	return packet, nil
+}
+
+var switchTable = []gosnmp.SnmpPDU{
+	{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Juniper Networks, Inc. qfx5100-48s-6q")},
+	{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.2636.1.1.1.2.82"},
+	{Name: snmp.IfDescrOidStub + ".502", Type: gosnmp.OctetString, Value: []byte("xe-0/0/11")},
+	{Name: snmp.IfDescrOidStub + ".568", Type: gosnmp.OctetString, Value: []byte("xe-0/0/45")},
+	{Name: snmp.IfAliasOidStub + ".502", Type: gosnmp.OctetString, Value: []byte("mlab2")},
+	{Name: snmp.IfAliasOidStub + ".568", Type: gosnmp.OctetString, Value: []byte("uplink-10g")},
+	{Name: ".1.3.6.1.2.1.31.1.1.1.6.502", Type: gosnmp.Counter64, Value: uint64(1000)},
+	{Name: ".1.3.6.1.2.1.31.1.1.1.6.568", Type: gosnmp.Counter64, Value: uint64(2000)},
+	{Name: ".1.3.6.1.4.1.2636.3.60.1.1.1.1.5.568", Type: gosnmp.Integer, Value: -250},
+}
+
+func TestHandler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metrics.yaml")
+	rtx.Must(ioutil.WriteFile(file, []byte(metricsYaml), 0644), "Could not write config")
+	c, err := config.New(file)
+	rtx.Must(err, "Could not load config")
+
+	connected := []string{}
+	connect := func(target string) (snmp.Client, io.Closer, error) {
+		connected = append(connected, target)
+		if target == "s1-xyz0t.measurement-lab.org" {
+			return nil, nil, errors.New("timeout")
+		}
+		return &tableClient{pdus: switchTable}, ioutil.NopCloser(nil), nil
+	}
+	h, err := NewHandler(c, `s1-[a-z]{3}[0-9][0-9a-z]\.measurement-lab\.org`, connect)
+	rtx.Must(err, "Could not create handler")
+
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		contains []string
+		excludes []string
+	}{
+		{
+			name:  "detected-profile",
+			query: "target=s1-abc0t.measurement-lab.org",
+			code:  http.StatusOK,
+			contains: []string{
+				`ifHCInOctets{ifAlias="mlab2",ifIndex="502",interface="xe-0/0/11"} 1000`,
+				`ifHCInOctets{ifAlias="uplink-10g",ifIndex="568",interface="xe-0/0/45"} 2000`,
+				"# TYPE ifHCInOctets counter",
+				`jnxDomCurrentRxLaserPower{ifAlias="uplink-10g",ifIndex="568",interface="xe-0/0/45"} -2.5`,
+				"# TYPE jnxDomCurrentRxLaserPower gauge",
+				"# HELP jnxDomCurrentRxLaserPower Receive laser power. Unit: dBm.",
+				"disco_probe_success 1",
+			},
+		},
+		{
+			name:     "generic-module",
+			query:    "target=s1-abc0t.measurement-lab.org&module=generic",
+			code:     http.StatusOK,
+			contains: []string{"ifHCInOctets{", "disco_probe_success 1"},
+			excludes: []string{"jnxDomCurrentRxLaserPower"},
+		},
+		{
+			name:     "connect-error",
+			query:    "target=s1-xyz0t.measurement-lab.org",
+			code:     http.StatusOK,
+			contains: []string{"disco_probe_success 0", "disco_probe_duration_seconds"},
+			excludes: []string{"ifHCInOctets"},
+		},
+		{
+			name:  "missing-target",
+			query: "module=generic",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "forbidden-target",
+			query: "target=192.168.0.1",
+			code:  http.StatusForbidden,
+		},
+		{
+			name:  "unknown-module",
+			query: "target=s1-abc0t.measurement-lab.org&module=cisco",
+			code:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?"+tt.query, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("ServeHTTP() code = %v, want %v: %v", rec.Code, tt.code, rec.Body)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected the response to contain %q, but got:\n%v", s, body)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected the response not to contain %q, but got:\n%v", s, body)
+				}
+			}
+		})
+	}
+
+	if len(connected) != 3 {
+		t.Errorf("Expected only valid requests to connect, but got: %v", connected)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	if _, err := NewHandler(config.Config{}, "(", nil); err == nil {
+		t.Errorf("NewHandler() expected an error for an invalid regular expression")
+	}
+}
@@ -0,0 +1,69 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a Prometheus label. The metric name is the __name__ label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a TimeSeries, with its timestamp in milliseconds since
+// the epoch.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a series of samples of a metric with the given labels.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Encode returns series as a snappy-compressed prometheus.WriteRequest
+// protobuf, which is the body of remote write requests.
+//
+// The messages are encoded directly, since only these fields are needed:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func Encode(series []TimeSeries) []byte {
+	var req []byte
+	for _, ts := range series {
+		// Receivers require labels to be sorted by name.
+		labels := append([]Label{}, ts.Labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		var msg []byte
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, label)
+		}
+		for _, s := range ts.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, sample)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, msg)
+	}
+	return snappy.Encode(nil, req)
+}
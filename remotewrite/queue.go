@@ -0,0 +1,152 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// batchSuffix is the extension of the files holding queued batches.
+const batchSuffix = ".batch"
+
+// entry is a batch in a Queue.
+type entry struct {
+	seq  uint64
+	size int64
+}
+
+// Queue is a bounded FIFO of encoded batches, stored as one file per batch in
+// a directory so that batches which have not been sent survive restarts. It
+// is safe for concurrent use.
+type Queue struct {
+	dir      string
+	maxBytes int64
+
+	mutex   sync.Mutex
+	entries []entry
+	bytes   int64
+	next    uint64
+	// dropped is the number of batches dropped because the Queue was full.
+	dropped int
+}
+
+// NewQueue returns a Queue storing at most maxBytes of batches in dir, which
+// is created if necessary. Batches already in dir are queued first.
+func NewQueue(dir string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, maxBytes: maxBytes}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), batchSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), batchSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.entries = append(q.entries, entry{seq: seq, size: f.Size()})
+		q.bytes += f.Size()
+		if seq >= q.next {
+			q.next = seq + 1
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+	return q, nil
+}
+
+// path returns the path of the file holding the batch seq.
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%v", seq, batchSuffix))
+}
+
+// Push adds data to the end of the Queue. If the Queue is full, the oldest
+// batches are dropped to make room.
+func (q *Queue) Push(data []byte) error {
+	size := int64(len(data))
+	if size > q.maxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds the queue size of %d bytes", size, q.maxBytes)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.bytes+size > q.maxBytes && len(q.entries) > 0 {
+		if err := q.removeLocked(q.entries[0].seq); err != nil {
+			return err
+		}
+		q.dropped++
+	}
+
+	// Batches are written to a temporary file first, so that a partially
+	// written batch is never sent.
+	seq := q.next
+	tmp := q.path(seq) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		return err
+	}
+	q.next++
+	q.entries = append(q.entries, entry{seq: seq, size: size})
+	q.bytes += size
+	return nil
+}
+
+// Peek returns the sequence number and data of the oldest batch, or false if
+// the Queue is empty.
+func (q *Queue) Peek() (uint64, []byte, bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.entries) == 0 {
+		return 0, nil, false, nil
+	}
+	seq := q.entries[0].seq
+	data, err := ioutil.ReadFile(q.path(seq))
+	return seq, data, true, err
+}
+
+// Remove removes the batch seq, after it has been sent. Removing a batch which
+// has been dropped is not an error.
+func (q *Queue) Remove(seq uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.removeLocked(seq)
+}
+
+// removeLocked removes the batch seq. The caller must hold the mutex.
+func (q *Queue) removeLocked(seq uint64) error {
+	for i, e := range q.entries {
+		if e.seq != seq {
+			continue
+		}
+		// The batch is forgotten even if its file can't be removed, so that
+		// it isn't sent again.
+		q.entries = append(q.entries[:i], q.entries[i+1:]...)
+		q.bytes -= e.size
+		if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+// Stats returns the number of batches and bytes in the Queue, and the number
+// of batches dropped because it was full.
+func (q *Queue) Stats() (batches int, bytes int64, dropped int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries), q.bytes, q.dropped
+}
@@ -0,0 +1,61 @@
+package remotewrite
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// mustPeek returns the data of the oldest batch of q, or "" if it is empty.
+func mustPeek(t *testing.T, q *Queue) (uint64, string) {
+	seq, data, ok, err := q.Peek()
+	rtx.Must(err, "Failed to peek")
+	if !ok {
+		return 0, ""
+	}
+	return seq, string(data)
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue(dir, 10)
+	rtx.Must(err, "Failed to create queue")
+
+	if _, data := mustPeek(t, q); data != "" {
+		t.Errorf("Expected an empty queue, but got: %v", data)
+	}
+	for _, data := range []string{"aaaa", "bbbb", "cccc"} {
+		rtx.Must(q.Push([]byte(data)), "Failed to push %v", data)
+	}
+	// The oldest batch was dropped to make room for the last one.
+	if batches, bytes, dropped := q.Stats(); batches != 2 || bytes != 8 || dropped != 1 {
+		t.Errorf("Expected 2 batches of 8 bytes and 1 dropped, but got: %d, %d, %d", batches, bytes, dropped)
+	}
+	if err := q.Push([]byte("too large batch")); err == nil {
+		t.Errorf("Expected an error for a batch larger than the queue")
+	}
+
+	seq, data := mustPeek(t, q)
+	if data != "bbbb" {
+		t.Errorf("Expected the oldest batch to be bbbb, but got: %v", data)
+	}
+	rtx.Must(q.Remove(seq), "Failed to remove %d", seq)
+
+	// Batches survive restarts, and new batches are queued after them.
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "junk.tmp"), []byte("junk"), 0644), "Failed to write junk")
+	q, err = NewQueue(dir, 10)
+	rtx.Must(err, "Failed to reopen queue")
+	rtx.Must(q.Push([]byte("dddd")), "Failed to push dddd")
+	for _, expect := range []string{"cccc", "dddd", ""} {
+		seq, data := mustPeek(t, q)
+		if data != expect {
+			t.Errorf("Expected batch %q, but got: %q", expect, data)
+		}
+		rtx.Must(q.Remove(seq), "Failed to remove %d", seq)
+	}
+	if batches, bytes, _ := q.Stats(); batches != 0 || bytes != 0 {
+		t.Errorf("Expected an empty queue, but got %d batches of %d bytes", batches, bytes)
+	}
+}
@@ -0,0 +1,302 @@
+// Package remotewrite pushes the points collected by DISCO to a Prometheus
+// remote write endpoint, for sites where no Prometheus server can scrape it.
+//
+// Points are batched, and the batches are stored in a bounded on-disk Queue
+// until they are sent, so that they survive outages of the endpoint and
+// restarts of DISCO.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/disco/logging"
+	"github.com/m-lab/disco/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// DefaultBatchSize is the default maximum number of samples in a batch.
+	DefaultBatchSize = 2000
+	// DefaultFlushInterval is the default maximum time before incomplete
+	// batches are queued.
+	DefaultFlushInterval = 10 * time.Second
+	// DefaultMinBackoff and DefaultMaxBackoff bound the time between retries
+	// of a failed batch, which doubles after each failure.
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 2 * time.Minute
+)
+
+// errorLog limits the logging of failures to send batches, which are retried
+// until they succeed.
+var errorLog = logging.NewLimiter(time.Minute)
+
+// permanentError is an error which won't be fixed by retrying e.g., a batch
+// rejected by the endpoint with a 400 Bad Request.
+type permanentError struct {
+	error
+}
+
+// Writer is a metrics.Exporter which pushes points to a remote write
+// endpoint. Points are sent by Run().
+type Writer struct {
+	url   string
+	queue *Queue
+
+	// Client is the HTTP client used to send batches.
+	Client *http.Client
+	// BearerTokenFile, if set, is the file containing the bearer token of the
+	// requests. It is read for every request, so that it may be rotated.
+	BearerTokenFile string
+	// BatchSize is the maximum number of samples in a batch.
+	BatchSize int
+	// FlushInterval is the maximum time before incomplete batches are queued.
+	FlushInterval time.Duration
+	// MinBackoff and MaxBackoff bound the time between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mutex   sync.Mutex
+	pending []TimeSeries
+	// queued is signalled when a batch is queued.
+	queued chan struct{}
+
+	batches    *prometheus.CounterVec
+	queueBytes prometheus.GaugeFunc
+	queueDrops prometheus.CounterFunc
+}
+
+// NewWriter returns a Writer sending the batches of queue to url.
+func NewWriter(url string, queue *Queue) *Writer {
+	w := &Writer{
+		url:           url,
+		queue:         queue,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+		BatchSize:     DefaultBatchSize,
+		FlushInterval: DefaultFlushInterval,
+		MinBackoff:    DefaultMinBackoff,
+		MaxBackoff:    DefaultMaxBackoff,
+		queued:        make(chan struct{}, 1),
+		batches: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "disco_remote_write_batches_total",
+				Help: "Total number of remote write batches, by result: sent, retried or rejected.",
+			},
+			[]string{"result"},
+		),
+	}
+	w.queueBytes = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "disco_remote_write_queue_bytes",
+			Help: "Size of the remote write batches waiting to be sent.",
+		},
+		func() float64 {
+			_, bytes, _ := queue.Stats()
+			return float64(bytes)
+		},
+	)
+	w.queueDrops = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: "disco_remote_write_queue_dropped_batches_total",
+			Help: "Total number of remote write batches dropped because the queue was full.",
+		},
+		func() float64 {
+			_, _, dropped := queue.Stats()
+			return float64(dropped)
+		},
+	)
+	return w
+}
+
+// Export adds points to the pending batch, which is queued once it has
+// BatchSize samples.
+func (w *Writer) Export(points []metrics.Point) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, p := range points {
+		labels := make([]Label, 0, len(p.Labels)+1)
+		labels = append(labels, Label{Name: "__name__", Value: p.Name})
+		for name, value := range p.Labels {
+			labels = append(labels, Label{Name: name, Value: value})
+		}
+		w.pending = append(w.pending, TimeSeries{
+			Labels: labels,
+			Samples: []Sample{{
+				Value:     p.Value,
+				Timestamp: p.Sample.CollectStart / int64(time.Millisecond),
+			}},
+		})
+		if len(w.pending) >= w.BatchSize {
+			w.flushLocked()
+		}
+	}
+}
+
+// Flush queues the pending batch, if any.
+func (w *Writer) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.flushLocked()
+}
+
+// flushLocked queues the pending batch. The caller must hold the mutex.
+func (w *Writer) flushLocked() {
+	if len(w.pending) == 0 {
+		return
+	}
+	err := w.queue.Push(Encode(w.pending))
+	if err != nil {
+		slog.Error("failed to queue remote write batch", "samples", len(w.pending), logging.Err(err))
+	}
+	w.pending = nil
+	select {
+	case w.queued <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the queued batches until ctx is cancelled, retrying failed ones
+// with an exponential backoff, and queues the pending batch every
+// FlushInterval. Batches rejected by the endpoint are dropped. The pending
+// batch is queued when Run returns, so that it is sent after a restart.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+	defer w.Flush()
+
+	backoff := w.MinBackoff
+	for {
+		select {
+		case <-ticker.C:
+			w.Flush()
+		default:
+		}
+
+		seq, data, ok, err := w.queue.Peek()
+		if err != nil {
+			// An unreadable batch will never be sent.
+			slog.Error("failed to read remote write batch, dropping it", "batch", seq, logging.Err(err))
+			w.remove(seq)
+			continue
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.Flush()
+			case <-w.queued:
+			}
+			continue
+		}
+
+		err = w.send(ctx, data)
+		if _, permanent := err.(permanentError); err == nil || permanent {
+			if err != nil {
+				slog.Error("remote write batch rejected, dropping it", "batch", seq, logging.Err(err))
+				w.batches.WithLabelValues("rejected").Inc()
+			} else {
+				w.batches.WithLabelValues("sent").Inc()
+			}
+			w.remove(seq)
+			backoff = w.MinBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		errorLog.Log(slog.Default(), slog.LevelError, "send", "failed to send remote write batch, retrying",
+			"batch", seq, "backoff", backoff, logging.Err(err))
+		w.batches.WithLabelValues("retried").Inc()
+		if !w.sleep(ctx, ticker, backoff) {
+			return
+		}
+		backoff *= 2
+		if backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+	}
+}
+
+// sleep waits for d, queueing the pending batch on every tick. It returns
+// false if ctx is cancelled first.
+func (w *Writer) sleep(ctx context.Context, ticker *time.Ticker, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			w.Flush()
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// remove removes the batch seq from the queue.
+func (w *Writer) remove(seq uint64) {
+	if err := w.queue.Remove(seq); err != nil {
+		slog.Error("failed to remove remote write batch", "batch", seq, logging.Err(err))
+	}
+}
+
+// send posts a batch to the endpoint. Client errors other than 429 Too Many
+// Requests are permanent.
+func (w *Writer) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "disco")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(w.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Describe sends the descriptors of the Writer's metrics.
+func (w *Writer) Describe(ch chan<- *prometheus.Desc) {
+	w.batches.Describe(ch)
+	w.queueBytes.Describe(ch)
+	w.queueDrops.Describe(ch)
+}
+
+// Collect sends the Writer's metrics.
+func (w *Writer) Collect(ch chan<- prometheus.Metric) {
+	w.batches.Collect(ch)
+	w.queueBytes.Collect(ch)
+	w.queueDrops.Collect(ch)
+}
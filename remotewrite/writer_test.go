@@ -0,0 +1,231 @@
+package remotewrite
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/m-lab/disco/archive"
+	"github.com/m-lab/disco/metrics"
+	"github.com/m-lab/go/rtx"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields returns the fields of the protobuf message b, by number.
+func fields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	f := map[protowire.Number][][]byte{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("Invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			n = 8
+			v = b[:n]
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(b)
+			v = b[:n]
+		default:
+			t.Fatalf("Unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("Invalid field %v: %v", num, protowire.ParseError(n))
+		}
+		f[num] = append(f[num], v)
+		b = b[n:]
+	}
+	return f
+}
+
+// decode returns the series of a snappy-compressed WriteRequest.
+func decode(t *testing.T, body []byte) []TimeSeries {
+	req, err := snappy.Decode(nil, body)
+	rtx.Must(err, "Failed to decompress request")
+	var series []TimeSeries
+	for _, ts := range fields(t, req)[1] {
+		var s TimeSeries
+		f := fields(t, ts)
+		for _, l := range f[1] {
+			lf := fields(t, l)
+			s.Labels = append(s.Labels, Label{Name: string(lf[1][0]), Value: string(lf[2][0])})
+		}
+		for _, sample := range f[2] {
+			sf := fields(t, sample)
+			value, _ := protowire.ConsumeFixed64(sf[1][0])
+			timestamp, _ := protowire.ConsumeVarint(sf[2][0])
+			s.Samples = append(s.Samples, Sample{Value: math.Float64frombits(value), Timestamp: int64(timestamp)})
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// receiver is a fake remote write endpoint, which responds to requests with
+// the given statuses, and then with 204 No Content.
+type receiver struct {
+	t        *testing.T
+	mutex    sync.Mutex
+	statuses []int
+	requests int
+	series   []TimeSeries
+	received chan struct{}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests++
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("Authorization") != "Bearer secret" {
+		r.t.Errorf("Unexpected headers: %v", req.Header)
+	}
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	rtx.Must(err, "Failed to read request")
+	r.series = append(r.series, decode(r.t, body)...)
+	w.WriteHeader(http.StatusNoContent)
+	r.received <- struct{}{}
+}
+
+func point(name string, value float64, collectStart time.Time) metrics.Point {
+	return metrics.Point{
+		Name:   name,
+		Labels: map[string]string{"target": "s1.abc0t.measurement-lab.org", "interface": "xe-0/0/11"},
+		Value:  value,
+		Sample: archive.Sample{CollectStart: collectStart.UnixNano()},
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		series   int
+	}{
+		{
+			name:     "success",
+			requests: 2,
+			series:   3,
+		},
+		{
+			name:     "retry-server-errors",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			requests: 4,
+			series:   3,
+		},
+		{
+			name:     "drop-rejected",
+			statuses: []int{http.StatusBadRequest},
+			requests: 2,
+			series:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &receiver{t: t, statuses: tt.statuses, received: make(chan struct{}, 10)}
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			token := filepath.Join(dir, "token")
+			rtx.Must(ioutil.WriteFile(token, []byte("secret\n"), 0600), "Failed to write token")
+			q, err := NewQueue(filepath.Join(dir, "queue"), 1<<20)
+			rtx.Must(err, "Failed to create queue")
+
+			w := NewWriter(srv.URL, q)
+			w.BearerTokenFile = token
+			w.BatchSize = 2
+			w.FlushInterval = 10 * time.Millisecond
+			w.MinBackoff = time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				w.Run(ctx)
+				close(done)
+			}()
+
+			start := time.Unix(1600000000, 123456789)
+			// The first two points fill a batch, and the last one is
+			// flushed after FlushInterval.
+			w.Export([]metrics.Point{point("ifHCInOctets", 1, start), point("ifHCOutOctets", 2, start)})
+			w.Export([]metrics.Point{point("ifHCInOctets", 3, start.Add(10*time.Second))})
+
+			for i := 0; i < tt.series; i += 2 {
+				select {
+				case <-r.received:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Timed out waiting for batches")
+				}
+			}
+			// Sent batches are removed from the queue after the response.
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+				if batches, _, _ := q.Stats(); batches == 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			if r.requests != tt.requests || len(r.series) != tt.series {
+				t.Errorf("Expected %d requests and %d series, but got: %d, %v", tt.requests, tt.series, r.requests, r.series)
+			}
+			last := TimeSeries{
+				Labels: []Label{
+					{Name: "__name__", Value: "ifHCInOctets"},
+					{Name: "interface", Value: "xe-0/0/11"},
+					{Name: "target", Value: "s1.abc0t.measurement-lab.org"},
+				},
+				Samples: []Sample{{Value: 3, Timestamp: 1600000010123}},
+			}
+			if got := r.series[len(r.series)-1]; !reflect.DeepEqual(got, last) {
+				t.Errorf("Expected the last series to be %+v, but got: %+v", last, got)
+			}
+			if batches, _, _ := q.Stats(); batches != 0 {
+				t.Errorf("Expected an empty queue, but got %d batches", batches)
+			}
+		})
+	}
+}
+
+func TestWriterQueuesUntilRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue(dir, 1<<20)
+	rtx.Must(err, "Failed to create queue")
+
+	// The endpoint is down, so the batch stays queued.
+	w := NewWriter("http://127.0.0.1:1/api/v1/write", q)
+	w.MinBackoff = time.Hour
+	w.Export([]metrics.Point{point("ifHCInOctets", 1, time.Now())})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	q, err = NewQueue(dir, 1<<20)
+	rtx.Must(err, "Failed to reopen queue")
+	_, data := mustPeek(t, q)
+	if series := decode(t, []byte(data)); len(series) != 1 || series[0].Samples[0].Value != 1 {
+		t.Errorf("Expected the queued series to survive a restart, but got: %+v", series)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/m-lab/go/httpx"
+	"github.com/m-lab/go/prometheusx"
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// mustServeHTTP starts the HTTP server on -prometheusx.listen-address. Like
+// prometheusx.MustServeMetrics(), it serves the metrics of the default
+// Prometheus registry on /metrics and the pprof endpoints, and it also serves
+// handlers, keyed by path.
+func mustServeHTTP(handlers map[string]http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/metrics", promhttp.Handler())
+	for path, h := range handlers {
+		mux.Handle(path, h)
+	}
+
+	server := &http.Server{
+		Addr:    *prometheusx.ListenAddress,
+		Handler: mux,
+	}
+	rtx.Must(httpx.ListenAndServeAsync(server), "Could not start HTTP server")
+	return server
+}
@@ -0,0 +1,215 @@
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/disco/config"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"":       gosnmp.NoAuth,
+		"MD5":    gosnmp.MD5,
+		"SHA":    gosnmp.SHA,
+		"SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256,
+		"SHA384": gosnmp.SHA384,
+		"SHA512": gosnmp.SHA512,
+	}
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"":        gosnmp.NoPriv,
+		"DES":     gosnmp.DES,
+		"AES":     gosnmp.AES,
+		"AES192":  gosnmp.AES192,
+		"AES256":  gosnmp.AES256,
+		"AES192C": gosnmp.AES192C,
+		"AES256C": gosnmp.AES256C,
+	}
+)
+
+// Credentials are the secrets used to authenticate to a switch, either an
+// SNMPv2c community string or SNMPv3 USM user credentials. Credentials are
+// never logged; String() redacts the secrets.
+type Credentials struct {
+	// Version is "2c" (the default) or "3".
+	Version   string `yaml:"version,omitempty"`
+	Community string `yaml:"community,omitempty"`
+	// Username, AuthProtocol, AuthPassphrase, PrivProtocol and
+	// PrivPassphrase are the SNMPv3 USM parameters. AuthProtocol is one of
+	// MD5, SHA, SHA224, SHA256, SHA384 or SHA512, and PrivProtocol is one of
+	// DES, AES, AES192, AES256, AES192C or AES256C. Either may be empty to
+	// disable authentication or privacy.
+	Username       string `yaml:"username,omitempty"`
+	AuthProtocol   string `yaml:"authProtocol,omitempty"`
+	AuthPassphrase string `yaml:"authPassphrase,omitempty"`
+	PrivProtocol   string `yaml:"privProtocol,omitempty"`
+	PrivPassphrase string `yaml:"privPassphrase,omitempty"`
+}
+
+// String describes the Credentials without revealing any secrets.
+func (c Credentials) String() string {
+	if c.Version == "3" {
+		auth, priv := c.AuthProtocol, c.PrivProtocol
+		if auth == "" {
+			auth = "none"
+		}
+		if priv == "" {
+			priv = "none"
+		}
+		return fmt.Sprintf("SNMPv3 user %q (auth: %v, priv: %v)", c.Username, auth, priv)
+	}
+	return "SNMPv2c community <redacted>"
+}
+
+// Validate checks that the Credentials are complete and consistent.
+func (c Credentials) Validate() error {
+	switch c.Version {
+	case "", "2c":
+		if c.Community == "" {
+			return fmt.Errorf("the community is required for SNMPv2c")
+		}
+	case "3":
+		if c.Username == "" {
+			return fmt.Errorf("the username is required for SNMPv3")
+		}
+		if _, ok := authProtocols[c.AuthProtocol]; !ok {
+			return fmt.Errorf("unknown SNMPv3 authProtocol %q", c.AuthProtocol)
+		}
+		if _, ok := privProtocols[c.PrivProtocol]; !ok {
+			return fmt.Errorf("unknown SNMPv3 privProtocol %q", c.PrivProtocol)
+		}
+		if (c.AuthProtocol == "") != (c.AuthPassphrase == "") {
+			return fmt.Errorf("authProtocol and authPassphrase must be set together")
+		}
+		if (c.PrivProtocol == "") != (c.PrivPassphrase == "") {
+			return fmt.Errorf("privProtocol and privPassphrase must be set together")
+		}
+		if c.PrivProtocol != "" && c.AuthProtocol == "" {
+			return fmt.Errorf("privProtocol requires authProtocol")
+		}
+	default:
+		return fmt.Errorf("unsupported SNMP version %q, must be 2c or 3", c.Version)
+	}
+	return nil
+}
+
+// Apply configures g to use the Credentials.
+func (c Credentials) Apply(g *gosnmp.GoSNMP) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.Version != "3" {
+		g.Version = gosnmp.Version2c
+		g.Community = c.Community
+		g.SecurityModel = 0
+		g.MsgFlags = gosnmp.NoAuthNoPriv
+		g.SecurityParameters = nil
+		return nil
+	}
+
+	flags := gosnmp.NoAuthNoPriv
+	switch {
+	case c.PrivProtocol != "":
+		flags = gosnmp.AuthPriv
+	case c.AuthProtocol != "":
+		flags = gosnmp.AuthNoPriv
+	}
+	g.Version = gosnmp.Version3
+	g.Community = ""
+	g.SecurityModel = gosnmp.UserSecurityModel
+	g.MsgFlags = flags
+	g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+		UserName:                 c.Username,
+		AuthenticationProtocol:   authProtocols[c.AuthProtocol],
+		AuthenticationPassphrase: c.AuthPassphrase,
+		PrivacyProtocol:          privProtocols[c.PrivProtocol],
+		PrivacyPassphrase:        c.PrivPassphrase,
+	}
+	return nil
+}
+
+// ReadCommunityFile returns SNMPv2c Credentials with the community string read
+// from file, ignoring surrounding whitespace.
+func ReadCommunityFile(file string) (Credentials, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read community file: %v", err)
+	}
+	c := Credentials{Version: "2c", Community: strings.TrimSpace(string(b))}
+	if c.Community == "" {
+		return Credentials{}, fmt.Errorf("community file '%v' is empty", file)
+	}
+	return c, nil
+}
+
+// ReadCredentialsFile returns the Credentials in the YAML file, after
+// interpolating references to environment variables and files.
+func ReadCredentialsFile(file string) (Credentials, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	b, err = config.Interpolate(b)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to interpolate credentials file '%v': %v", file, err)
+	}
+	c := Credentials{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	// Decoding errors may quote values, so only the file is reported.
+	if err := dec.Decode(&c); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse credentials file '%v'", file)
+	}
+	if err := c.Validate(); err != nil {
+		return Credentials{}, fmt.Errorf("invalid credentials file '%v': %v", file, err)
+	}
+	return c, nil
+}
+
+// CredentialsSource holds the current Credentials, and reloads them so that
+// rotated secrets take effect without a restart. It is safe for concurrent
+// use.
+type CredentialsSource struct {
+	load    func() (Credentials, error)
+	mutex   sync.Mutex
+	current Credentials
+}
+
+// NewCredentialsSource returns a CredentialsSource which loads Credentials
+// using load, e.g., by reading a file.
+func NewCredentialsSource(load func() (Credentials, error)) (*CredentialsSource, error) {
+	c, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialsSource{load: load, current: c}, nil
+}
+
+// Credentials returns the current Credentials.
+func (s *CredentialsSource) Credentials() Credentials {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current
+}
+
+// Reload loads the Credentials again, and returns true if they changed. If
+// loading fails, the current Credentials are kept.
+func (s *CredentialsSource) Reload() (bool, error) {
+	c, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c == s.current {
+		return false, nil
+	}
+	s.current = c
+	return true, nil
+}
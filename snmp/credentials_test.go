@@ -0,0 +1,136 @@
+package snmp
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/m-lab/go/rtx"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	rtx.Must(ioutil.WriteFile(path, []byte(data), 0600), "Failed to write %v", path)
+	return path
+}
+
+func TestReadCredentialsFile(t *testing.T) {
+	t.Setenv("DISCO_TEST_PRIV", "priv-secret")
+
+	tests := []struct {
+		name    string
+		data    string
+		want    Credentials
+		wantErr string
+	}{
+		{
+			name: "v2c",
+			data: "community: s3cret\n",
+			want: Credentials{Community: "s3cret"},
+		},
+		{
+			name: "v3-authpriv-with-env",
+			data: "version: \"3\"\nusername: disco\nauthProtocol: SHA256\nauthPassphrase: auth-secret\n" +
+				"privProtocol: AES\nprivPassphrase: ${DISCO_TEST_PRIV}\n",
+			want: Credentials{Version: "3", Username: "disco", AuthProtocol: "SHA256",
+				AuthPassphrase: "auth-secret", PrivProtocol: "AES", PrivPassphrase: "priv-secret"},
+		},
+		{
+			name:    "unknown-field",
+			data:    "community: s3cret\npassword: s3cret\n",
+			wantErr: "failed to parse",
+		},
+		{
+			name:    "missing-community",
+			data:    "version: 2c\n",
+			wantErr: "community is required",
+		},
+		{
+			name:    "bad-auth-protocol",
+			data:    "version: \"3\"\nusername: disco\nauthProtocol: SHA1\nauthPassphrase: s3cret\n",
+			wantErr: "unknown SNMPv3 authProtocol",
+		},
+		{
+			name:    "priv-without-auth",
+			data:    "version: \"3\"\nusername: disco\nprivProtocol: AES\nprivPassphrase: s3cret\n",
+			wantErr: "privProtocol requires authProtocol",
+		},
+		{
+			name:    "missing-passphrase",
+			data:    "version: \"3\"\nusername: disco\nauthProtocol: SHA\n",
+			wantErr: "must be set together",
+		},
+		{
+			name:    "bad-version",
+			data:    "version: \"1\"\ncommunity: s3cret\n",
+			wantErr: "unsupported SNMP version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadCredentialsFile(writeFile(t, "credentials.yaml", tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadCredentialsFile() error = %v, expected it to contain %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "s3cret") {
+					t.Errorf("ReadCredentialsFile() error reveals a secret: %v", err)
+				}
+				return
+			}
+			rtx.Must(err, "Failed to read credentials")
+			if got != tt.want {
+				t.Errorf("ReadCredentialsFile() = %#v, expected %#v", got, tt.want)
+			}
+			if strings.Contains(got.String(), "secret") {
+				t.Errorf("Credentials.String() reveals a secret: %v", got)
+			}
+		})
+	}
+}
+
+func TestCredentialsApply(t *testing.T) {
+	g := &gosnmp.GoSNMP{}
+	c := Credentials{Version: "3", Username: "disco", AuthProtocol: "SHA", AuthPassphrase: "a",
+		PrivProtocol: "AES256", PrivPassphrase: "p"}
+	rtx.Must(c.Apply(g), "Failed to apply SNMPv3 credentials")
+	usm, ok := g.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if g.Version != gosnmp.Version3 || g.MsgFlags != gosnmp.AuthPriv || !ok ||
+		usm.UserName != "disco" || usm.AuthenticationProtocol != gosnmp.SHA || usm.PrivacyProtocol != gosnmp.AES256 {
+		t.Errorf("Unexpected SNMPv3 parameters: %+v", g)
+	}
+
+	rtx.Must(Credentials{Community: "public"}.Apply(g), "Failed to apply SNMPv2c credentials")
+	if g.Version != gosnmp.Version2c || g.Community != "public" || g.SecurityParameters != nil {
+		t.Errorf("Unexpected SNMPv2c parameters: %+v", g)
+	}
+}
+
+func TestCredentialsSource(t *testing.T) {
+	path := writeFile(t, "community", "first\n")
+	s, err := NewCredentialsSource(func() (Credentials, error) { return ReadCommunityFile(path) })
+	rtx.Must(err, "Failed to create CredentialsSource")
+	if s.Credentials().Community != "first" {
+		t.Errorf("Expected community 'first', but got: %q", s.Credentials().Community)
+	}
+
+	changed, err := s.Reload()
+	if changed || err != nil {
+		t.Errorf("Reload() = %v, %v, expected no change", changed, err)
+	}
+
+	rtx.Must(ioutil.WriteFile(path, []byte("second"), 0600), "Failed to rotate community")
+	changed, err = s.Reload()
+	if !changed || err != nil || s.Credentials().Community != "second" {
+		t.Errorf("Reload() = %v, %v, expected the rotated community", changed, err)
+	}
+
+	// A failed reload keeps the current credentials.
+	rtx.Must(ioutil.WriteFile(path, []byte(" \n"), 0600), "Failed to empty community file")
+	changed, err = s.Reload()
+	if changed || err == nil || s.Credentials().Community != "second" {
+		t.Errorf("Reload() = %v, %v, expected an error and no change", changed, err)
+	}
+}
@@ -1,9 +1,26 @@
 package snmp
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
 	"github.com/gosnmp/gosnmp"
 )
 
+const (
+	// DefaultPort is the SNMP port used if a target has none.
+	DefaultPort = 161
+	// Timeout and Retries apply to every request.
+	Timeout = 5 * time.Second
+	Retries = 1
+	// IfDescrOidStub and IfAliasOidStub are the IF-MIB columns of the
+	// description and alias of interfaces, indexed by ifIndex.
+	IfDescrOidStub = ".1.3.6.1.2.1.2.2.1.2"
+	IfAliasOidStub = ".1.3.6.1.2.1.31.1.1.1.18"
+)
+
 // Client defines a new SNMP interface to abstract SNMP operations.
 type Client interface {
 	BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, err error)
@@ -21,9 +38,38 @@ func (s *SwitchClient) BulkWalkAll(rootOid string) (results []gosnmp.SnmpPDU, er
 	return s.GoSNMP.BulkWalkAll(rootOid)
 }
 
-// Get does an SNMP Get operation on an array of OIDs.
+// Get does an SNMP Get operation on an array of OIDs. Since agents limit the
+// number of OIDs of a request, the OIDs are requested in chunks of at most
+// GoSNMP.MaxOids, and the variables of all responses are returned in one
+// packet.
 func (s *SwitchClient) Get(oids []string) (results *gosnmp.SnmpPacket, err error) {
-	return s.GoSNMP.Get(oids)
+	maxOids := s.GoSNMP.MaxOids
+	if maxOids <= 0 {
+		maxOids = gosnmp.MaxOids
+	}
+	return getChunks(s.GoSNMP.Get, oids, maxOids)
+}
+
+// getChunks calls get with chunks of at most maxOids of oids, and returns the
+// first response with the variables of all responses.
+func getChunks(get func(oids []string) (*gosnmp.SnmpPacket, error), oids []string, maxOids int) (*gosnmp.SnmpPacket, error) {
+	var result *gosnmp.SnmpPacket
+	for start := 0; start < len(oids) || result == nil; start += maxOids {
+		end := start + maxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		packet, err := get(oids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = packet
+			continue
+		}
+		result.Variables = append(result.Variables, packet.Variables...)
+	}
+	return result, nil
 }
 
 // New returns a new SNMP client.
@@ -32,3 +78,30 @@ func New(s *gosnmp.GoSNMP) *SwitchClient {
 		GoSNMP: s,
 	}
 }
+
+// Connect returns a connected GoSNMP for target, which is a hostname or an IP
+// address with an optional port e.g., "s1-abc0t.measurement-lab.org:1161",
+// using the Credentials c.
+func Connect(target string, c Credentials) (*gosnmp.GoSNMP, error) {
+	host, port := target, uint16(DefaultPort)
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in SNMP target '%v'", target)
+		}
+		host, port = h, uint16(n)
+	}
+	g := &gosnmp.GoSNMP{
+		Target:  host,
+		Port:    port,
+		Timeout: Timeout,
+		Retries: Retries,
+	}
+	if err := c.Apply(g); err != nil {
+		return nil, err
+	}
+	if err := g.Connect(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
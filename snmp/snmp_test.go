@@ -1,6 +1,8 @@
 package snmp
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -24,3 +26,88 @@ func Test_New(t *testing.T) {
 		t.Error("Expected return value of New() to implement interface Client.")
 	}
 }
+
+func TestConnect(t *testing.T) {
+	creds := Credentials{Version: "2c", Community: "public"}
+	tests := []struct {
+		target   string
+		wantHost string
+		wantPort uint16
+		wantErr  bool
+	}{
+		{target: "127.0.0.1", wantHost: "127.0.0.1", wantPort: DefaultPort},
+		{target: "127.0.0.1:1161", wantHost: "127.0.0.1", wantPort: 1161},
+		{target: "[::1]:1161", wantHost: "::1", wantPort: 1161},
+		{target: "127.0.0.1:99999", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			g, err := Connect(tt.target, creds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Connect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer g.Conn.Close()
+			if g.Target != tt.wantHost || g.Port != tt.wantPort || g.Community != "public" {
+				t.Errorf("Connect() = %v:%v (%v), want %v:%v", g.Target, g.Port, g.Community, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func Test_getChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		oids    int
+		maxOids int
+		chunks  int
+	}{
+		{name: "none", oids: 0, maxOids: 60, chunks: 1},
+		{name: "one-chunk", oids: 60, maxOids: 60, chunks: 1},
+		{name: "several-chunks", oids: 150, maxOids: 60, chunks: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oids := []string{}
+			for i := 0; i < tt.oids; i++ {
+				oids = append(oids, fmt.Sprintf(".1.3.6.1.2.1.31.1.1.1.6.%d", i))
+			}
+			chunks := 0
+			get := func(oids []string) (*gosnmp.SnmpPacket, error) {
+				chunks++
+				if len(oids) > tt.maxOids {
+					return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)", len(oids), tt.maxOids)
+				}
+				packet := &gosnmp.SnmpPacket{}
+				for _, oid := range oids {
+					packet.Variables = append(packet.Variables, gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Counter64, Value: uint64(1)})
+				}
+				return packet, nil
+			}
+			result, err := getChunks(get, oids, tt.maxOids)
+			if err != nil {
+				t.Fatalf("getChunks() error = %v", err)
+			}
+			if chunks != tt.chunks {
+				t.Errorf("Expected %d requests, but got: %d", tt.chunks, chunks)
+			}
+			if len(result.Variables) != len(oids) {
+				t.Fatalf("Expected %d variables, but got: %d", len(oids), len(result.Variables))
+			}
+			for i, pdu := range result.Variables {
+				if pdu.Name != oids[i] {
+					t.Errorf("Expected variable %d to be %v, but got: %v", i, oids[i], pdu.Name)
+				}
+			}
+		})
+	}
+
+	_, err := getChunks(func(oids []string) (*gosnmp.SnmpPacket, error) {
+		return nil, errors.New("request timeout (after 1 retries)")
+	}, []string{".1.3.6.1.2.1.1.2.0"}, 60)
+	if err == nil {
+		t.Errorf("Expected getChunks() to return the error of a request")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/disco/config"
+)
+
+// runValidateConfig implements the "validate-config" command, which validates
+// metrics YAML files and prints every problem found with its line number.
+func runValidateConfig(args []string) error {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: disco validate-config <metrics.yaml> [...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no metrics files given")
+	}
+
+	invalid := 0
+	for _, file := range fs.Args() {
+		if !validateConfigFile(os.Stdout, file) {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d metrics files are invalid", invalid, fs.NArg())
+	}
+	return nil
+}
+
+// validateConfigFile writes the problems found in file to w, or its
+// warnings if it is valid, and returns whether the file is valid.
+func validateConfigFile(w io.Writer, file string) bool {
+	c, err := config.Load(file)
+	if err == nil {
+		err = c.Validate()
+	}
+
+	var verr *config.ValidationError
+	switch {
+	case err == nil:
+		for _, p := range c.Warnings() {
+			fmt.Fprintf(w, "warning: %v\n", p)
+		}
+		fmt.Fprintf(w, "%v: OK\n", file)
+		return true
+	case errors.As(err, &verr):
+		for _, p := range verr.Problems {
+			fmt.Fprintln(w, p)
+		}
+	default:
+		fmt.Fprintln(w, err)
+	}
+	return false
+}